@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/thomasem/chirpy/internal/auth"
+	"github.com/thomasem/chirpy/internal/database"
+)
+
+type deleteUserRequest struct {
+	Password string `json:"password"`
+}
+
+type suspensionRequest struct {
+	Note string `json:"note"`
+}
+
+// deleteUserHandler schedules the caller's account for deletion once they've
+// confirmed their password. The account is deactivated straight away and
+// purged after the grace period unless they log in again before then.
+func (cs *chirpyService) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	dr, err := decodeBody[deleteUserRequest](r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON request")
+		return
+	}
+	au, err := cs.db.GetAuthUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	if !auth.PasswordMatches(dr.Password, au.Password) {
+		respondWithError(w, http.StatusUnauthorized, "Incorrect password")
+		return
+	}
+	user, err := cs.db.ScheduleUserDeletion(userID, time.Now().Add(cs.config.deletionGrace))
+	if err != nil {
+		log.Printf("error scheduling user deletion in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete user")
+		return
+	}
+	respondWithJSON(w, http.StatusAccepted, cs.userResponse(user, viewer{ID: userID}))
+}
+
+func (cs *chirpyService) suspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	cs.setSuspension(w, r, cs.db.SuspendUser)
+}
+
+func (cs *chirpyService) unsuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	cs.setSuspension(w, r, cs.db.UnsuspendUser)
+}
+
+func (cs *chirpyService) setSuspension(w http.ResponseWriter, r *http.Request, set func(int, int, string) (database.User, error)) {
+	v, ok := cs.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	userID, err := getIDFromPath(r, "userID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID in URL")
+		return
+	}
+	sr, err := decodeBody[suspensionRequest](r)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON request")
+		return
+	}
+	user, err := set(userID, v.ID, sr.Note)
+	if err == database.ErrDoesNotExist {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		log.Printf("error updating suspension in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to update suspension")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cs.userResponse(user, v))
+}
+
+// runPurger cleans up every interval, until done is closed: it deletes
+// accounts whose grace period has ended, expired exports and old webhook
+// deliveries, and expires lapsed Chirpy Red subscriptions.
+func (cs *chirpyService) runPurger(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		cs.purgeDeletedAccounts()
+		cs.purgeExpiredExports()
+		cs.expireSubscriptions()
+		cs.purgeWebhookDeliveries()
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cs *chirpyService) purgeDeletedAccounts() {
+	for _, userID := range cs.db.GetUsersDueForDeletion(time.Now()) {
+		exports := cs.db.GetExports(userID, "")
+		attachments, chirps, err := cs.db.DeleteUser(userID)
+		if err != nil {
+			log.Printf("error deleting user %d: %s", userID, err)
+			continue
+		}
+		for _, chirp := range chirps {
+			cs.bus.Publish(ChirpDeleted{Chirp: chirp})
+		}
+		cs.deleteAttachmentBlobs(attachments)
+		for _, export := range exports {
+			if export.BlobKey != "" {
+				cs.blobs.Delete(export.BlobKey)
+			}
+		}
+	}
+}
+
+// expireSubscriptions records the end of Chirpy Red subscriptions whose
+// billing period has lapsed. Feature gates already check the period end, so
+// this only brings the stored status and history up to date.
+func (cs *chirpyService) expireSubscriptions() {
+	expired, err := cs.db.ExpireSubscriptions(time.Now())
+	if err != nil {
+		log.Printf("error expiring subscriptions in database: %s", err)
+		return
+	}
+	for _, userID := range expired {
+		log.Printf("Chirpy Red subscription for user %d expired", userID)
+		cs.bus.Publish(SubscriptionChanged{UserID: userID, Change: database.BillingExpired})
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/thomasem/chirpy/internal/events"
+)
+
+func TestPurgeDeletedAccounts(t *testing.T) {
+	cs := newTestService(t)
+	rec := events.Record(cs.bus)
+	leaving := mustCreateUser(t, cs, "leaving")
+	staying := mustCreateUser(t, cs, "staying")
+	for _, author := range []int{leaving.ID, staying.ID} {
+		if w := serveAs(t, cs, cs.createChirpHandler, author, http.MethodPost, `{"body":"hello"}`); w.Code != http.StatusCreated {
+			t.Fatalf("create: status %d: %s", w.Code, w.Body)
+		}
+	}
+	created := events.Recorded[ChirpCreated](rec)
+	if _, err := cs.db.ScheduleUserDeletion(leaving.ID, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("ScheduleUserDeletion: %s", err)
+	}
+
+	cs.purgeDeletedAccounts()
+
+	deleted := events.Recorded[ChirpDeleted](rec)
+	if len(deleted) != 1 || deleted[0].Chirp.ID != created[0].Chirp.ID {
+		t.Errorf("purge published %+v, want one ChirpDeleted for chirp %d", deleted, created[0].Chirp.ID)
+	}
+	if _, err := cs.db.GetUser(staying.ID); err != nil {
+		t.Errorf("GetUser(staying): %s", err)
+	}
+
+	// Tokens issued before the purge no longer authenticate anyone
+	if w := serveAs(t, cs, cs.createChirpHandler, leaving.ID, http.MethodPost, `{"body":"still here?"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("create as a deleted user: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/thomasem/chirpy/internal/database"
+)
+
+type muteRequest struct {
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type MutedUser struct {
+	User      User       `json:"user"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func (cs *chirpyService) blockHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	blockedID, err := getIDFromPath(r, "userID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID in URL")
+		return
+	}
+	err = cs.db.BlockUser(userID, blockedID)
+	if err == database.ErrSelfBlock {
+		respondWithError(w, http.StatusBadRequest, "You cannot block yourself")
+		return
+	}
+	if err == database.ErrDoesNotExist {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		log.Printf("error blocking user in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to block user")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cs *chirpyService) unblockHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	blockedID, err := getIDFromPath(r, "userID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID in URL")
+		return
+	}
+	err = cs.db.UnblockUser(userID, blockedID)
+	if err != nil {
+		log.Printf("error unblocking user in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to unblock user")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cs *chirpyService) getBlocksHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	v := viewer{ID: userID}
+	users := cs.db.GetBlockedUsers(userID)
+	response := make([]User, 0, len(users))
+	for _, user := range users {
+		response = append(response, cs.userResponse(user, v))
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// muteHandler mutes a user, indefinitely unless the request body has an
+// "expires_at" time.
+func (cs *chirpyService) muteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	mutedID, err := getIDFromPath(r, "userID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID in URL")
+		return
+	}
+	mr, err := decodeBody[muteRequest](r)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON request")
+		return
+	}
+	if mr.ExpiresAt != nil {
+		if !mr.ExpiresAt.After(time.Now()) {
+			respondWithError(w, http.StatusBadRequest, "expires_at must be in the future")
+			return
+		}
+		expiresAt := mr.ExpiresAt.UTC()
+		mr.ExpiresAt = &expiresAt
+	}
+	err = cs.db.MuteUser(userID, mutedID, mr.ExpiresAt)
+	if err == database.ErrSelfBlock {
+		respondWithError(w, http.StatusBadRequest, "You cannot mute yourself")
+		return
+	}
+	if err == database.ErrDoesNotExist {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		log.Printf("error muting user in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to mute user")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cs *chirpyService) unmuteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	mutedID, err := getIDFromPath(r, "userID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID in URL")
+		return
+	}
+	err = cs.db.UnmuteUser(userID, mutedID)
+	if err != nil {
+		log.Printf("error unmuting user in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to unmute user")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cs *chirpyService) getMutesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	v := viewer{ID: userID}
+	muted := cs.db.GetMutedUsers(userID)
+	response := make([]MutedUser, 0, len(muted))
+	for _, m := range muted {
+		response = append(response, MutedUser{
+			User:      cs.userResponse(m.User, v),
+			ExpiresAt: m.ExpiresAt,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
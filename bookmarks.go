@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/thomasem/chirpy/internal/database"
+)
+
+const (
+	defaultPinLimit   = 1
+	chirpyRedPinLimit = 3
+)
+
+// pinLimit is how many chirps u may pin to their profile at once.
+func pinLimit(u database.User) int {
+	if u.HasChirpyRed(time.Now()) {
+		return chirpyRedPinLimit
+	}
+	return defaultPinLimit
+}
+
+func (cs *chirpyService) bookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	chirpID, err := getIDFromPath(r, "chirpID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID in URL")
+		return
+	}
+	err = cs.db.BookmarkChirp(userID, chirpID)
+	if err == database.ErrDoesNotExist {
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+	if err != nil {
+		log.Printf("error bookmarking chirp in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to bookmark chirp")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cs *chirpyService) removeBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	chirpID, err := getIDFromPath(r, "chirpID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID in URL")
+		return
+	}
+	err = cs.db.RemoveBookmark(userID, chirpID)
+	if err != nil {
+		log.Printf("error removing bookmark in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to remove bookmark")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getBookmarksHandler lists the caller's bookmarks. They're private, so there
+// is no way to see anyone else's.
+func (cs *chirpyService) getBookmarksHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	limit, before, err := getPagination(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	chirps := cs.db.GetBookmarks(userID, before, limit)
+	respondWithJSON(w, http.StatusOK, cs.chirpsResponse(chirps, viewer{ID: userID}))
+}
+
+func (cs *chirpyService) pinHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	chirpID, err := getIDFromPath(r, "chirpID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID in URL")
+		return
+	}
+	user, err := cs.db.GetUser(userID)
+	if err != nil {
+		log.Printf("Unable to get user from database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving user")
+		return
+	}
+	limit := pinLimit(user)
+	err = cs.db.PinChirp(userID, chirpID, limit)
+	switch err {
+	case nil:
+	case database.ErrDoesNotExist:
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	case database.ErrNotAuthor:
+		respondWithError(w, http.StatusForbidden, "You can only pin your own chirps")
+		return
+	case database.ErrPinLimit:
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("Pinned chirp limit of %d reached, unpin one first", limit))
+		return
+	default:
+		log.Printf("error pinning chirp in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to pin chirp")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cs *chirpyService) unpinHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	chirpID, err := getIDFromPath(r, "chirpID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID in URL")
+		return
+	}
+	err = cs.db.UnpinChirp(userID, chirpID)
+	if err != nil {
+		log.Printf("error unpinning chirp in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to unpin chirp")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// withPinnedFirst moves authorID's pinned chirps to the front of chirps,
+// marking them as pinned.
+func (cs *chirpyService) withPinnedFirst(authorID int, chirps []Chirp, v viewer) []Chirp {
+	pinned := cs.db.GetPinnedChirps(v.ID, authorID)
+	if len(pinned) == 0 {
+		return chirps
+	}
+	isPinned := make(map[int]bool, len(pinned))
+	response := make([]Chirp, 0, len(chirps))
+	for _, c := range pinned {
+		chirp := cs.chirpResponse(c, v)
+		chirp.Pinned = true
+		response = append(response, chirp)
+		isPinned[c.ID] = true
+	}
+	for _, chirp := range chirps {
+		if !isPinned[chirp.ID] {
+			response = append(response, chirp)
+		}
+	}
+	return response
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/thomasem/chirpy/internal/database"
+)
+
+const (
+	// The scheduler wakes at least every maxSchedulerSleep, and waits at
+	// least minSchedulerSleep so that a chirp that keeps failing to publish
+	// can't make it spin.
+	minSchedulerSleep = time.Second
+	maxSchedulerSleep = time.Minute
+)
+
+type Draft struct {
+	ID            int                    `json:"id"`
+	Body          string                 `json:"body"`
+	AttachmentIDs []int                  `json:"attachment_ids"`
+	Visibility    database.Visibility    `json:"visibility"`
+	Status        database.PendingStatus `json:"status"`
+	PublishAt     *time.Time             `json:"publish_at"`
+	FailureReason string                 `json:"failure_reason,omitempty"`
+	CreatedAt     time.Time              `json:"created_at"`
+	UpdatedAt     time.Time              `json:"updated_at"`
+}
+
+// draftRequest saves a draft, or schedules it if PublishAt is set.
+type draftRequest struct {
+	Body          string     `json:"body"`
+	AttachmentIDs []int      `json:"attachment_ids"`
+	Visibility    string     `json:"visibility"`
+	PublishAt     *time.Time `json:"publish_at"`
+}
+
+func draftResponse(p database.PendingChirp) Draft {
+	d := Draft{
+		ID:            p.ID,
+		Body:          p.Body,
+		AttachmentIDs: p.AttachmentIDs,
+		Visibility:    p.Visibility,
+		Status:        p.Status,
+		FailureReason: p.FailureReason,
+		CreatedAt:     p.CreatedAt,
+		UpdatedAt:     p.UpdatedAt,
+	}
+	if d.AttachmentIDs == nil {
+		d.AttachmentIDs = []int{}
+	}
+	if d.Visibility == "" {
+		d.Visibility = database.VisibilityPublic
+	}
+	if !p.PublishAt.IsZero() {
+		d.PublishAt = &p.PublishAt
+	}
+	return d
+}
+
+// validateDraft checks a draft or scheduled chirp from author. Drafts are
+// works in progress so only the hard limits apply; scheduled chirps get the
+// full checks up front so authors find out about problems straight away,
+// though they are checked again when published.
+func (cs *chirpyService) validateDraft(author database.User, dr draftRequest) (database.PendingChirp, error) {
+	p := database.PendingChirp{
+		AuthorID:      author.ID,
+		Body:          dr.Body,
+		AttachmentIDs: dr.AttachmentIDs,
+		Status:        database.PendingDraft,
+	}
+	var err error
+	p.Visibility, err = parseVisibility(dr.Visibility, database.VisibilityPublic)
+	if err != nil {
+		return p, err
+	}
+	for _, a := range cs.db.GetAttachments(dr.AttachmentIDs) {
+		if a.OwnerID != author.ID || a.ChirpID != 0 {
+			return p, invalidChirpError{invalidAttachmentsMessage}
+		}
+	}
+	if dr.PublishAt == nil {
+		return p, checkChirpLimits(author, dr.Body, dr.AttachmentIDs)
+	}
+	if dr.PublishAt.Before(time.Now()) {
+		return p, invalidChirpError{"Scheduled time must be in the future"}
+	}
+	_, err = cs.buildChirp(author, dr.Body, dr.AttachmentIDs)
+	if err != nil {
+		return p, err
+	}
+	p.Status = database.PendingScheduled
+	p.PublishAt = dr.PublishAt.UTC()
+	return p, nil
+}
+
+// getOwnDraft loads the draft named in the URL, responding with an error
+// unless it belongs to userID.
+func (cs *chirpyService) getOwnDraft(w http.ResponseWriter, r *http.Request, userID int) (database.PendingChirp, bool) {
+	draftID, err := getIDFromPath(r, "draftID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid draft ID in URL")
+		return database.PendingChirp{}, false
+	}
+	p, err := cs.db.GetPendingChirp(draftID)
+	// Other people's drafts are private, so don't reveal that they exist
+	if err == database.ErrDoesNotExist || (err == nil && p.AuthorID != userID) {
+		respondWithError(w, http.StatusNotFound, "Draft not found")
+		return database.PendingChirp{}, false
+	}
+	if err != nil {
+		log.Printf("Unable to get draft from database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving draft")
+		return database.PendingChirp{}, false
+	}
+	return p, true
+}
+
+func (cs *chirpyService) createDraftHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	dr, err := decodeBody[draftRequest](r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON request")
+		return
+	}
+	author, err := cs.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	p, err := cs.validateDraft(author, dr)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	p, err = cs.db.CreatePendingChirp(p)
+	if err != nil {
+		log.Printf("error creating draft in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to save draft")
+		return
+	}
+	cs.wakeScheduler()
+	respondWithJSON(w, http.StatusCreated, draftResponse(p))
+}
+
+func (cs *chirpyService) getDraftsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	status := database.PendingStatus(r.URL.Query().Get("status"))
+	switch status {
+	case "", database.PendingDraft, database.PendingScheduled, database.PendingFailed:
+	default:
+		respondWithError(w, http.StatusBadRequest, "Invalid status filter")
+		return
+	}
+	pending := cs.db.GetPendingChirps(userID, status)
+	response := make([]Draft, 0, len(pending))
+	for _, p := range pending {
+		response = append(response, draftResponse(p))
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cs *chirpyService) getDraftHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	p, ok := cs.getOwnDraft(w, r, userID)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, draftResponse(p))
+}
+
+// updateDraftHandler edits a draft, and schedules, reschedules or unschedules
+// it depending on publish_at.
+func (cs *chirpyService) updateDraftHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	dr, err := decodeBody[draftRequest](r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON request")
+		return
+	}
+	existing, ok := cs.getOwnDraft(w, r, userID)
+	if !ok {
+		return
+	}
+	author, err := cs.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	p, err := cs.validateDraft(author, dr)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	p, err = cs.db.UpdatePendingChirp(existing.ID, p)
+	if err == database.ErrDoesNotExist {
+		// Published by the scheduler in the meantime
+		respondWithError(w, http.StatusConflict, "Draft has already been published")
+		return
+	}
+	if err != nil {
+		log.Printf("error updating draft in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to save draft")
+		return
+	}
+	cs.wakeScheduler()
+	respondWithJSON(w, http.StatusOK, draftResponse(p))
+}
+
+func (cs *chirpyService) deleteDraftHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	p, ok := cs.getOwnDraft(w, r, userID)
+	if !ok {
+		return
+	}
+	err = cs.db.DeletePendingChirp(p.ID)
+	if err != nil {
+		log.Printf("error deleting draft from database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete draft")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cs *chirpyService) publishDraftHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	p, ok := cs.getOwnDraft(w, r, userID)
+	if !ok {
+		return
+	}
+	chirp, err := cs.publishPendingChirp(p, time.Time{})
+	var invalid invalidChirpError
+	if errors.As(err, &invalid) {
+		respondWithError(w, http.StatusBadRequest, invalid.Error())
+		return
+	}
+	if err == database.ErrDoesNotExist {
+		respondWithError(w, http.StatusConflict, "Draft has already been published")
+		return
+	}
+	if err == database.ErrPendingChanged {
+		respondWithError(w, http.StatusConflict, "Draft was changed while being published")
+		return
+	}
+	if err != nil {
+		log.Printf("error publishing draft: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to publish draft")
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, cs.chirpResponse(chirp, viewer{ID: userID}))
+}
+
+// publishPendingChirp posts a draft or scheduled chirp, applying the same
+// checks as a chirp posted directly. If dueBy isn't zero, the chirp is only
+// published if it's still scheduled for then or earlier.
+func (cs *chirpyService) publishPendingChirp(p database.PendingChirp, dueBy time.Time) (database.Chirp, error) {
+	author, err := cs.db.GetUser(p.AuthorID)
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	chirp, err := cs.buildChirp(author, p.Body, p.AttachmentIDs)
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	chirp.Visibility = p.Visibility
+	published, err := cs.db.PublishPendingChirp(p, chirp, dueBy)
+	if err == database.ErrInvalidAttachment {
+		return database.Chirp{}, invalidChirpError{invalidAttachmentsMessage}
+	}
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	cs.bus.Publish(ChirpCreated{Chirp: published})
+	return published, nil
+}
+
+func (cs *chirpyService) wakeScheduler() {
+	select {
+	case cs.schedulerWake <- struct{}{}:
+	default:
+	}
+}
+
+// runScheduler publishes scheduled chirps as they fall due, until done is
+// closed. Scheduled chirps live in the database, so any that fell due while
+// the server was down are published as soon as it starts.
+func (cs *chirpyService) runScheduler(done <-chan struct{}) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+		case <-cs.schedulerWake:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		}
+		cs.publishDueChirps()
+		wait := maxSchedulerSleep
+		if next, ok := cs.db.NextPublishTime(); ok {
+			wait = max(minSchedulerSleep, min(wait, time.Until(next)))
+		}
+		timer.Reset(wait)
+	}
+}
+
+// publishDueChirps publishes the chirps scheduled for now or earlier. Ones
+// their authors change in the meantime are left for the next pass, which will
+// see the change.
+func (cs *chirpyService) publishDueChirps() {
+	now := time.Now().UTC()
+	for _, p := range cs.db.GetDuePendingChirps(now) {
+		_, err := cs.publishPendingChirp(p, now)
+		var invalid invalidChirpError
+		if errors.As(err, &invalid) {
+			err = cs.db.FailPendingChirp(p.ID, invalid.Error())
+		}
+		if err != nil && err != database.ErrDoesNotExist && err != database.ErrPendingChanged {
+			log.Printf("error publishing scheduled chirp %d: %s", p.ID, err)
+		}
+	}
+}
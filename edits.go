@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/thomasem/chirpy/internal/database"
+)
+
+type ChirpRevision struct {
+	Body      string    `json:"body"`
+	Entities  []Entity  `json:"entities"`
+	WrittenAt time.Time `json:"written_at"`
+}
+
+// editWindow is how long after posting u may edit their chirps.
+func (cs *chirpyService) editWindow(u database.User) time.Duration {
+	if u.HasChirpyRed(time.Now()) {
+		return cs.config.redEditWindow
+	}
+	return cs.config.editWindow
+}
+
+func (cs *chirpyService) editChirpHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	chirpIDStr := r.PathValue("chirpID")
+	chirpID, err := getIDFromPath(r, "chirpID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unexpected path value: %s", chirpIDStr))
+		return
+	}
+	cr, err := decodeBody[chirpRequest](r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON request")
+		return
+	}
+	chirp, err := cs.db.GetChirp(chirpID)
+	if err == database.ErrDoesNotExist {
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Unable to get chirp from database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving chirp")
+		return
+	}
+	if chirp.AuthorID != userID {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	author, err := cs.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	if time.Since(chirp.CreatedAt) > cs.editWindow(author) {
+		respondWithError(w, http.StatusForbidden, "Chirp can no longer be edited")
+		return
+	}
+	edit, ok := cs.prepareChirp(w, author, cr.Body, chirp.AttachmentIDs)
+	if !ok {
+		return
+	}
+	edit.Visibility, err = parseVisibility(cr.Visibility, chirp.EffectiveVisibility())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	edited, err := cs.db.EditChirp(chirpID, edit)
+	if err != nil {
+		log.Printf("error editing chirp in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to edit chirp")
+		return
+	}
+	cs.bus.Publish(ChirpUpdated{Chirp: edited})
+	respondWithJSON(w, http.StatusOK, cs.chirpResponse(edited, viewer{ID: userID}))
+}
+
+func (cs *chirpyService) getChirpHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	chirpIDStr := r.PathValue("chirpID")
+	chirpID, err := getIDFromPath(r, "chirpID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unexpected path value: %s", chirpIDStr))
+		return
+	}
+	revisions, err := cs.db.GetChirpHistory(cs.getViewer(r).ID, chirpID)
+	if err == database.ErrDoesNotExist {
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Unable to get chirp history from database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving chirp history")
+		return
+	}
+	response := make([]ChirpRevision, 0, len(revisions))
+	for _, rev := range revisions {
+		response = append(response, ChirpRevision{
+			Body:      rev.Body,
+			Entities:  entitiesResponse(rev.Entities),
+			WrittenAt: rev.WrittenAt,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
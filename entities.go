@@ -0,0 +1,106 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/thomasem/chirpy/internal/database"
+	"github.com/thomasem/chirpy/internal/entities"
+)
+
+type Entity struct {
+	Type      database.EntityType `json:"type"`
+	Text      string              `json:"text"`
+	UserID    int                 `json:"user_id,omitempty"`
+	Start     int                 `json:"start"`
+	End       int                 `json:"end"`
+	RuneStart int                 `json:"rune_start"`
+	RuneEnd   int                 `json:"rune_end"`
+}
+
+func entitiesResponse(es []database.Entity) []Entity {
+	response := make([]Entity, 0, len(es))
+	for _, e := range es {
+		response = append(response, Entity(e))
+	}
+	return response
+}
+
+// parseEntities extracts the mentions and hashtags from a chirp body. Mentions
+// that don't resolve to a user are dropped.
+func (cs *chirpyService) parseEntities(body string) []database.Entity {
+	parsed := entities.Parse(body)
+	result := make([]database.Entity, 0, len(parsed))
+	for _, p := range parsed {
+		e := database.Entity{
+			Type:      database.EntityType(p.Type),
+			Text:      p.Text,
+			Start:     p.Start,
+			End:       p.End,
+			RuneStart: p.RuneStart,
+			RuneEnd:   p.RuneEnd,
+		}
+		if p.Type == entities.Mention {
+			userID, ok := cs.resolveMention(p.Text)
+			if !ok {
+				continue
+			}
+			e.UserID = userID
+		}
+		result = append(result, e)
+	}
+	return result
+}
+
+// resolveMention finds the user a mention refers to, by email address or
+// handle.
+func (cs *chirpyService) resolveMention(text string) (int, bool) {
+	if strings.Contains(text, "@") {
+		user, err := cs.db.GetAuthUserByEmail(text)
+		return user.ID, err == nil
+	}
+	user, err := cs.db.GetUserByHandle(text)
+	return user.ID, err == nil
+}
+
+func (cs *chirpyService) getHashtagChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	tag := entities.NormalizeHashtag(r.PathValue("tag"))
+	if tag == "" {
+		respondWithError(w, http.StatusBadRequest, "Hashtag missing from URL")
+		return
+	}
+	limit, before, err := getPagination(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	v := cs.getViewer(r)
+	chirps := cs.db.GetChirpsByHashtag(v.ID, tag, before, limit)
+	respondWithJSON(w, http.StatusOK, cs.chirpsResponse(chirps, v))
+}
+
+func (cs *chirpyService) getMentionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := getIDFromPath(r, "userID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID in URL")
+		return
+	}
+	limit, before, err := getPagination(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	v := cs.getViewer(r)
+	chirps, err := cs.db.GetChirpsMentioning(v.ID, userID, before, limit)
+	if err == database.ErrDoesNotExist {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		log.Printf("error getting mentions from database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving chirps")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cs.chirpsResponse(chirps, v))
+}
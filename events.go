@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/thomasem/chirpy/internal/database"
+)
+
+// Domain events are published on cs.bus once the change they describe has
+// been committed to the database.
+
+const (
+	eventChirpCreated        = "chirp.created"
+	eventChirpUpdated        = "chirp.updated"
+	eventChirpDeleted        = "chirp.deleted"
+	eventUserFollowed        = "user.followed"
+	eventUserUnfollowed      = "user.unfollowed"
+	eventFollowRequested     = "user.follow_requested"
+	eventSubscriptionChanged = "user.subscription_changed"
+	eventNotificationCreated = "notification.created"
+)
+
+func chirpAggregate(chirpID int) string {
+	return "chirp:" + strconv.Itoa(chirpID)
+}
+
+func userAggregate(userID int) string {
+	return "user:" + strconv.Itoa(userID)
+}
+
+type ChirpCreated struct {
+	Chirp database.Chirp
+}
+
+func (e ChirpCreated) Name() string      { return eventChirpCreated }
+func (e ChirpCreated) Aggregate() string { return chirpAggregate(e.Chirp.ID) }
+
+type ChirpUpdated struct {
+	Chirp database.Chirp
+}
+
+func (e ChirpUpdated) Name() string      { return eventChirpUpdated }
+func (e ChirpUpdated) Aggregate() string { return chirpAggregate(e.Chirp.ID) }
+
+type ChirpDeleted struct {
+	Chirp database.Chirp
+}
+
+func (e ChirpDeleted) Name() string      { return eventChirpDeleted }
+func (e ChirpDeleted) Aggregate() string { return chirpAggregate(e.Chirp.ID) }
+
+// UserFollowed and UserUnfollowed belong to the followee, so their followers
+// change in order. Approved is set when the followee approved a request to
+// follow them.
+type UserFollowed struct {
+	FollowerID int
+	FolloweeID int
+	Approved   bool
+}
+
+func (e UserFollowed) Name() string      { return eventUserFollowed }
+func (e UserFollowed) Aggregate() string { return userAggregate(e.FolloweeID) }
+
+type UserUnfollowed struct {
+	FollowerID int
+	FolloweeID int
+}
+
+func (e UserUnfollowed) Name() string      { return eventUserUnfollowed }
+func (e UserUnfollowed) Aggregate() string { return userAggregate(e.FolloweeID) }
+
+// FollowRequested is published when a user asks to follow a protected
+// account.
+type FollowRequested struct {
+	FollowerID int
+	FolloweeID int
+}
+
+func (e FollowRequested) Name() string      { return eventFollowRequested }
+func (e FollowRequested) Aggregate() string { return userAggregate(e.FolloweeID) }
+
+// SubscriptionChanged is published when a billing event changes a user's
+// Chirpy Red subscription.
+type SubscriptionChanged struct {
+	UserID int
+	Change database.BillingEventType
+}
+
+func (e SubscriptionChanged) Name() string      { return eventSubscriptionChanged }
+func (e SubscriptionChanged) Aggregate() string { return userAggregate(e.UserID) }
+
+// NotificationCreated is published when a user is notified, including when a
+// grouped notification gains an actor. It's published by an asynchronous
+// subscriber, so it must only have synchronous subscribers of its own.
+type NotificationCreated struct {
+	Notification database.Notification
+}
+
+func (e NotificationCreated) Name() string { return eventNotificationCreated }
+func (e NotificationCreated) Aggregate() string {
+	return userAggregate(e.Notification.RecipientID)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/thomasem/chirpy/internal/events"
+)
+
+// serveAs calls handler with a request authenticated as userID, setting the
+// given path values.
+func serveAs(t *testing.T, cs *chirpyService, handler http.HandlerFunc, userID int, method string, body string, pathValues ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	token, err := cs.generateJWT(userID, 0)
+	if err != nil {
+		t.Fatalf("generateJWT: %s", err)
+	}
+	var rb io.Reader
+	if body != "" {
+		rb = strings.NewReader(body)
+	}
+	r := httptest.NewRequest(method, "/", rb)
+	r.Header.Set(authorizationHeader, "Bearer "+token)
+	for i := 0; i+1 < len(pathValues); i += 2 {
+		r.SetPathValue(pathValues[i], pathValues[i+1])
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestChirpHandlersPublishEvents(t *testing.T) {
+	cs := newTestService(t)
+	rec := events.Record(cs.bus)
+	author := mustCreateUser(t, cs, "author")
+
+	w := serveAs(t, cs, cs.createChirpHandler, author.ID, http.MethodPost, `{"body":"hello"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status %d: %s", w.Code, w.Body)
+	}
+	created := events.Recorded[ChirpCreated](rec)
+	if len(created) != 1 || created[0].Chirp.Body != "hello" || created[0].Chirp.AuthorID != author.ID {
+		t.Fatalf("create published %+v, want one ChirpCreated for the chirp", created)
+	}
+
+	chirpID := strconv.Itoa(created[0].Chirp.ID)
+	other := mustCreateUser(t, cs, "other")
+	if w := serveAs(t, cs, cs.deleteChirpHandler, other.ID, http.MethodDelete, "", "chirpID", chirpID); w.Code != http.StatusForbidden {
+		t.Fatalf("delete by another user: status %d", w.Code)
+	}
+	if deleted := events.Recorded[ChirpDeleted](rec); len(deleted) != 0 {
+		t.Fatalf("refused delete published %+v", deleted)
+	}
+	if w := serveAs(t, cs, cs.deleteChirpHandler, author.ID, http.MethodDelete, "", "chirpID", chirpID); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: status %d", w.Code)
+	}
+	deleted := events.Recorded[ChirpDeleted](rec)
+	if len(deleted) != 1 || deleted[0].Chirp.ID != created[0].Chirp.ID {
+		t.Errorf("delete published %+v, want one ChirpDeleted for the chirp", deleted)
+	}
+}
+
+func TestFollowHandlersPublishOnlyChanges(t *testing.T) {
+	cs := newTestService(t)
+	rec := events.Record(cs.bus)
+	follower := mustCreateUser(t, cs, "follower")
+	followee := mustCreateUser(t, cs, "followee")
+	followeeID := strconv.Itoa(followee.ID)
+
+	for i := 0; i < 2; i++ {
+		if w := serveAs(t, cs, cs.followHandler, follower.ID, http.MethodPost, "", "userID", followeeID); w.Code != http.StatusNoContent {
+			t.Fatalf("follow: status %d: %s", w.Code, w.Body)
+		}
+	}
+	followed := events.Recorded[UserFollowed](rec)
+	want := UserFollowed{FollowerID: follower.ID, FolloweeID: followee.ID}
+	if len(followed) != 1 || followed[0] != want {
+		t.Fatalf("following twice published %+v, want only %+v", followed, want)
+	}
+
+	rec.Reset()
+	for i := 0; i < 2; i++ {
+		if w := serveAs(t, cs, cs.unfollowHandler, follower.ID, http.MethodDelete, "", "userID", followeeID); w.Code != http.StatusNoContent {
+			t.Fatalf("unfollow: status %d: %s", w.Code, w.Body)
+		}
+	}
+	if names := rec.Names(); len(names) != 1 || names[0] != eventUserUnfollowed {
+		t.Errorf("unfollowing twice published %v, want only %s", names, eventUserUnfollowed)
+	}
+}
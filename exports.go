@@ -0,0 +1,339 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/thomasem/chirpy/internal/archive"
+	"github.com/thomasem/chirpy/internal/blob"
+	"github.com/thomasem/chirpy/internal/database"
+)
+
+const (
+	// exportTTL is how long a finished archive can be downloaded for.
+	exportTTL = 7 * 24 * time.Hour
+	// failedExportTTL is how long failed exports are kept to report why.
+	failedExportTTL = 24 * time.Hour
+	zipContentType  = "application/zip"
+)
+
+type Export struct {
+	ID          int                   `json:"id"`
+	Status      database.ExportStatus `json:"status"`
+	Size        int64                 `json:"size,omitempty"`
+	Error       string                `json:"error,omitempty"`
+	CreatedAt   time.Time             `json:"created_at"`
+	CompletedAt *time.Time            `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time            `json:"expires_at,omitempty"`
+	DownloadURL string                `json:"download_url,omitempty"`
+}
+
+type exportRelation struct {
+	UserID int       `json:"user_id"`
+	Since  time.Time `json:"since"`
+}
+
+type exportMute struct {
+	UserID    int        `json:"user_id"`
+	MutedAt   time.Time  `json:"muted_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type exportSubscription struct {
+	ChirpyRed bool                          `json:"chirpy_red"`
+	Current   *database.Subscription        `json:"current,omitempty"`
+	History   []database.SubscriptionChange `json:"history"`
+}
+
+type exportSession struct {
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func exportResponse(e database.Export) Export {
+	export := Export{
+		ID:          e.ID,
+		Status:      e.Status,
+		Size:        e.Size,
+		Error:       e.Error,
+		CreatedAt:   e.CreatedAt,
+		CompletedAt: e.CompletedAt,
+		ExpiresAt:   e.ExpiresAt,
+	}
+	if e.Status == database.ExportReady {
+		export.DownloadURL = fmt.Sprintf("/api/exports/%d/download", e.ID)
+	}
+	return export
+}
+
+func exportBlobKey(id int) string {
+	return fmt.Sprintf("exports/%d/archive.zip", id)
+}
+
+// createExportHandler starts building an archive of the caller's data in the
+// background. Only one export is built at a time per user; asking again while
+// one is pending returns that one.
+func (cs *chirpyService) createExportHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	export, err := cs.db.CreateExport(userID)
+	if err == database.ErrAlreadyExists {
+		respondWithJSON(w, http.StatusAccepted, exportResponse(export))
+		return
+	}
+	if err != nil {
+		log.Printf("error creating export in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to start export")
+		return
+	}
+	go cs.buildExport(export)
+	respondWithJSON(w, http.StatusAccepted, exportResponse(export))
+}
+
+func (cs *chirpyService) getExportsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	exports := cs.db.GetExports(userID, "")
+	response := make([]Export, 0, len(exports))
+	for _, e := range exports {
+		response = append(response, exportResponse(e))
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cs *chirpyService) getExportHandler(w http.ResponseWriter, r *http.Request) {
+	export, ok := cs.getOwnExport(w, r)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, exportResponse(export))
+}
+
+func (cs *chirpyService) downloadExportHandler(w http.ResponseWriter, r *http.Request) {
+	export, ok := cs.getOwnExport(w, r)
+	if !ok {
+		return
+	}
+	if export.Status != database.ExportReady || !export.ExpiresAt.After(time.Now()) {
+		respondWithError(w, http.StatusConflict, "Export is not ready to download")
+		return
+	}
+	rc, err := cs.blobs.Get(export.BlobKey)
+	if err == blob.ErrNotFound {
+		respondWithError(w, http.StatusNotFound, "Export not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Unable to get blob '%s': %s", export.BlobKey, err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving export")
+		return
+	}
+	defer rc.Close()
+	filename := fmt.Sprintf("chirpy-export-%s.zip", export.CreatedAt.Format("2006-01-02"))
+	w.Header().Set(contentTypeHeader, zipContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.Header().Set("Content-Length", strconv.FormatInt(export.Size, 10))
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, rc)
+}
+
+// getOwnExport loads the export named in the URL, responding with 404 if it
+// belongs to someone else.
+func (cs *chirpyService) getOwnExport(w http.ResponseWriter, r *http.Request) (database.Export, bool) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return database.Export{}, false
+	}
+	exportID, err := getIDFromPath(r, "exportID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid export ID in URL")
+		return database.Export{}, false
+	}
+	export, err := cs.db.GetExport(exportID)
+	if err == database.ErrDoesNotExist || (err == nil && export.UserID != userID) {
+		respondWithError(w, http.StatusNotFound, "Export not found")
+		return database.Export{}, false
+	}
+	if err != nil {
+		log.Printf("Unable to get export from database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving export")
+		return database.Export{}, false
+	}
+	return export, true
+}
+
+// buildExport writes the archive for a pending export to blob storage and
+// records the outcome.
+func (cs *chirpyService) buildExport(export database.Export) {
+	key := exportBlobKey(export.ID)
+	size, err := cs.writeExport(export, key)
+	if err != nil {
+		log.Printf("error building export %d: %s", export.ID, err)
+		cs.blobs.Delete(key)
+		_, err = cs.db.FailExport(export.ID, "Failed to build archive")
+		if err != nil {
+			log.Printf("error failing export in database: %s", err)
+		}
+		return
+	}
+	_, err = cs.db.CompleteExport(export.ID, key, size, time.Now().Add(exportTTL))
+	if err != nil {
+		log.Printf("error completing export in database: %s", err)
+		cs.blobs.Delete(key)
+	}
+}
+
+// writeExport streams the archive straight into blob storage, returning its
+// size.
+func (cs *chirpyService) writeExport(export database.Export, key string) (int64, error) {
+	data, err := cs.db.GetUserData(export.UserID)
+	if err != nil {
+		return 0, err
+	}
+	docs, files := cs.exportContents(data)
+	pr, pw := io.Pipe()
+	counter := &countingReader{r: pr}
+	go func() {
+		title := fmt.Sprintf("Chirpy data for @%s", data.User.Handle)
+		pw.CloseWithError(archive.Write(pw, title, time.Now().UTC(), docs, files))
+	}()
+	err = cs.blobs.Put(key, counter)
+	pr.CloseWithError(err)
+	return counter.n, err
+}
+
+func (cs *chirpyService) exportContents(data database.UserData) ([]archive.Document, []archive.File) {
+	self := viewer{ID: data.User.ID}
+	drafts := make([]Draft, 0, len(data.PendingChirps))
+	for _, p := range data.PendingChirps {
+		drafts = append(drafts, draftResponse(p))
+	}
+	attachments := make([]Attachment, 0, len(data.Attachments))
+	var files []archive.File
+	for _, a := range data.Attachments {
+		attachments = append(attachments, attachmentResponse(a))
+		name := fmt.Sprintf("media/%d%s", a.ID, extensionFor(a.ContentType))
+		key := a.BlobKey
+		files = append(files, archive.File{
+			Name: name,
+			Open: func() (io.ReadCloser, error) { return cs.blobs.Get(key) },
+		})
+	}
+	mutes := make([]exportMute, 0, len(data.Muted))
+	for id, m := range data.Muted {
+		mutes = append(mutes, exportMute{UserID: id, MutedAt: m.MutedAt, ExpiresAt: m.ExpiresAt})
+	}
+	sessions := make([]exportSession, 0, len(data.Sessions))
+	for _, rt := range data.Sessions {
+		sessions = append(sessions, exportSession{ExpiresAt: rt.Expiration})
+	}
+	webhooks := make([]WebhookEndpoint, 0, len(data.Webhooks))
+	for _, e := range data.Webhooks {
+		webhooks = append(webhooks, webhookEndpointResponse(e))
+	}
+	docs := []archive.Document{
+		{Name: "profile", Title: "Profile", Data: data.User},
+		{Name: "subscription", Title: "Subscription", Data: exportSubscription{
+			ChirpyRed: data.User.HasChirpyRed(time.Now()),
+			Current:   data.User.Subscription,
+			History:   orEmpty(data.Subscription),
+		}},
+		{Name: "chirps", Title: "Chirps", Data: cs.chirpsResponse(data.Chirps, self)},
+		{Name: "chirp_history", Title: "Chirp edit history", Data: data.Revisions},
+		{Name: "drafts", Title: "Drafts and scheduled chirps", Data: drafts},
+		{Name: "media", Title: "Media", Data: attachments},
+		{Name: "following", Title: "Following", Data: relations(data.Following)},
+		{Name: "followers", Title: "Followers", Data: relations(data.Followers)},
+		{Name: "follow_requests", Title: "Follow requests", Data: relations(data.FollowRequests)},
+		{Name: "follow_requests_sent", Title: "Follow requests sent", Data: relations(data.FollowRequested)},
+		{Name: "blocks", Title: "Blocked users", Data: relations(data.Blocked)},
+		{Name: "mutes", Title: "Muted users", Data: mutes},
+		{Name: "bookmarks", Title: "Bookmarked chirp IDs", Data: orEmpty(data.Bookmarks)},
+		{Name: "pins", Title: "Pinned chirp IDs", Data: orEmpty(data.Pins)},
+		{Name: "poll_votes", Title: "Poll votes by chirp ID", Data: data.PollVotes},
+		{Name: "sessions", Title: "Sessions", Data: sessions},
+		{Name: "reports", Title: "Reports you filed", Data: orEmpty(data.Reports)},
+		{Name: "webhooks", Title: "Webhooks", Data: webhooks},
+		{Name: "notifications", Title: "Notifications", Data: orEmpty(data.Notifications)},
+		{Name: "conversations", Title: "Conversations", Data: orEmpty(data.Conversations)},
+		{Name: "messages", Title: "Direct messages", Data: orEmpty(data.Messages)},
+	}
+	return docs, files
+}
+
+func relations(set map[int]time.Time) []exportRelation {
+	response := make([]exportRelation, 0, len(set))
+	for id, since := range set {
+		response = append(response, exportRelation{UserID: id, Since: since})
+	}
+	return response
+}
+
+func orEmpty[T any](items []T) []T {
+	if items == nil {
+		return []T{}
+	}
+	return items
+}
+
+func extensionFor(contentType string) string {
+	switch contentType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	}
+	return ""
+}
+
+// resumeExports restarts building any exports that were interrupted by a
+// shutdown.
+func (cs *chirpyService) resumeExports() {
+	for _, export := range cs.db.GetExports(0, database.ExportPending) {
+		go cs.buildExport(export)
+	}
+}
+
+func (cs *chirpyService) purgeExpiredExports() {
+	for _, export := range cs.db.GetExpiredExports(time.Now(), failedExportTTL) {
+		cs.deleteExport(export)
+	}
+}
+
+func (cs *chirpyService) deleteExport(export database.Export) {
+	if export.BlobKey != "" {
+		err := cs.blobs.Delete(export.BlobKey)
+		if err != nil && err != blob.ErrNotFound {
+			log.Printf("error deleting blob '%s': %s", export.BlobKey, err)
+			return
+		}
+	}
+	err := cs.db.DeleteExport(export.ID)
+	if err != nil {
+		log.Printf("error deleting export in database: %s", err)
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
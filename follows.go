@@ -0,0 +1,195 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/thomasem/chirpy/internal/database"
+)
+
+const followStatusRequested = "requested"
+
+// followResponse is sent when a follow is waiting for approval.
+type followResponse struct {
+	Status string `json:"status"`
+}
+
+// followHandler follows a user, or asks to if their account is protected.
+func (cs *chirpyService) followHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	followeeID, err := getIDFromPath(r, "userID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID in URL")
+		return
+	}
+	result, err := cs.db.FollowUser(userID, followeeID)
+	if err == database.ErrSelfFollow {
+		respondWithError(w, http.StatusBadRequest, "You cannot follow yourself")
+		return
+	}
+	if err == database.ErrBlocked {
+		respondWithError(w, http.StatusForbidden, "You cannot follow this user")
+		return
+	}
+	if err == database.ErrDoesNotExist {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		log.Printf("error following user in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to follow user")
+		return
+	}
+	switch result {
+	case database.Followed:
+		cs.bus.Publish(UserFollowed{FollowerID: userID, FolloweeID: followeeID})
+	case database.FollowRequested:
+		cs.bus.Publish(FollowRequested{FollowerID: userID, FolloweeID: followeeID})
+		fallthrough
+	case database.AlreadyRequested:
+		// Protected accounts approve their followers first
+		respondWithJSON(w, http.StatusAccepted, followResponse{Status: followStatusRequested})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getFollowRequestsHandler lists the users waiting for the caller to approve
+// their requests to follow them.
+func (cs *chirpyService) getFollowRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	users := cs.db.GetFollowRequests(userID)
+	v := viewer{ID: userID}
+	response := make([]User, 0, len(users))
+	for _, user := range users {
+		response = append(response, cs.userResponse(user, v))
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cs *chirpyService) approveFollowRequestHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	requesterID, err := getIDFromPath(r, "userID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID in URL")
+		return
+	}
+	err = cs.db.ApproveFollowRequest(userID, requesterID)
+	if err == database.ErrDoesNotExist {
+		respondWithError(w, http.StatusNotFound, "Follow request not found")
+		return
+	}
+	if err != nil {
+		log.Printf("error approving follow request in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to approve follow request")
+		return
+	}
+	cs.bus.Publish(UserFollowed{FollowerID: requesterID, FolloweeID: userID, Approved: true})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cs *chirpyService) rejectFollowRequestHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	requesterID, err := getIDFromPath(r, "userID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID in URL")
+		return
+	}
+	err = cs.db.RejectFollowRequest(userID, requesterID)
+	if err == database.ErrDoesNotExist {
+		respondWithError(w, http.StatusNotFound, "Follow request not found")
+		return
+	}
+	if err != nil {
+		log.Printf("error rejecting follow request in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to reject follow request")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cs *chirpyService) unfollowHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	followeeID, err := getIDFromPath(r, "userID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID in URL")
+		return
+	}
+	unfollowed, err := cs.db.UnfollowUser(userID, followeeID)
+	if err != nil {
+		log.Printf("error unfollowing user in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to unfollow user")
+		return
+	}
+	if unfollowed {
+		cs.bus.Publish(UserUnfollowed{FollowerID: userID, FolloweeID: followeeID})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cs *chirpyService) getFollowersHandler(w http.ResponseWriter, r *http.Request) {
+	cs.respondWithFollowList(w, r, cs.db.GetFollowers)
+}
+
+func (cs *chirpyService) getFollowingHandler(w http.ResponseWriter, r *http.Request) {
+	cs.respondWithFollowList(w, r, cs.db.GetFollowing)
+}
+
+func (cs *chirpyService) respondWithFollowList(w http.ResponseWriter, r *http.Request, list func(int) ([]database.User, error)) {
+	userID, err := getIDFromPath(r, "userID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID in URL")
+		return
+	}
+	users, err := list(userID)
+	if err == database.ErrDoesNotExist {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		log.Printf("error listing follows from database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving users")
+		return
+	}
+	v := cs.getViewer(r)
+	response := make([]User, 0, len(users))
+	for _, user := range users {
+		response = append(response, cs.userResponse(user, v))
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cs *chirpyService) getTimelineHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	limit, before, err := getPagination(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	chirps := cs.db.GetTimeline(userID, before, limit)
+	respondWithJSON(w, http.StatusOK, cs.chirpsResponse(chirps, viewer{ID: userID}))
+}
@@ -7,4 +7,5 @@ require (
 	github.com/joho/godotenv v1.5.1
 	golang.org/x/crypto v0.26.0
 	golang.org/x/exp v0.0.0-20240808152545-0cdaa3abc0fa
+	golang.org/x/text v0.17.0
 )
@@ -0,0 +1,150 @@
+package archive
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"html/template"
+	"io"
+	"time"
+)
+
+const (
+	indexName = "index.html"
+)
+
+// Document is a section of an archive, written as Name.json and shown under
+// Title in the HTML viewer.
+type Document struct {
+	Name  string
+	Title string
+	Data  any
+}
+
+// File is a file copied into the archive as-is, such as an uploaded image.
+// Open is called while the archive is being written.
+type File struct {
+	Name string
+	Open func() (io.ReadCloser, error)
+}
+
+// Write writes a zip archive to w holding each document as JSON, the files,
+// and an index.html that presents it all without needing a server.
+func Write(w io.Writer, title string, createdAt time.Time, docs []Document, files []File) error {
+	aw := &archiveWriter{zw: zip.NewWriter(w), modified: createdAt}
+	view := indexView{Title: title, CreatedAt: createdAt}
+	for _, doc := range docs {
+		data, err := json.MarshalIndent(doc.Data, "", "  ")
+		if err != nil {
+			return err
+		}
+		err = aw.writeFile(doc.Name+".json", data)
+		if err != nil {
+			return err
+		}
+		view.Sections = append(view.Sections, section{
+			ID:    doc.Name,
+			Title: doc.Title,
+			File:  doc.Name + ".json",
+			JSON:  string(data),
+		})
+	}
+	for _, f := range files {
+		err := aw.copyFile(f)
+		if err != nil {
+			return err
+		}
+		view.Files = append(view.Files, f.Name)
+	}
+	fw, err := aw.create(indexName)
+	if err != nil {
+		return err
+	}
+	err = indexTemplate.Execute(fw, view)
+	if err != nil {
+		return err
+	}
+	return aw.zw.Close()
+}
+
+// archiveWriter stamps every entry with the archive's creation time.
+type archiveWriter struct {
+	zw       *zip.Writer
+	modified time.Time
+}
+
+func (aw *archiveWriter) create(name string) (io.Writer, error) {
+	return aw.zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: aw.modified,
+	})
+}
+
+func (aw *archiveWriter) writeFile(name string, data []byte) error {
+	fw, err := aw.create(name)
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(data)
+	return err
+}
+
+func (aw *archiveWriter) copyFile(f File) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	fw, err := aw.create(f.Name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, rc)
+	return err
+}
+
+type indexView struct {
+	Title     string
+	CreatedAt time.Time
+	Sections  []section
+	Files     []string
+}
+
+type section struct {
+	ID    string
+	Title string
+	File  string
+	JSON  string
+}
+
+var indexTemplate = template.Must(template.New(indexName).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: 2em auto; padding: 0 1em; }
+pre { background: #f4f4f4; padding: 1em; overflow-x: auto; }
+nav a { margin-right: 1em; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Created {{.CreatedAt.Format "2006-01-02 15:04 MST"}}.</p>
+<nav>{{range .Sections}}<a href="#{{.ID}}">{{.Title}}</a>{{end}}{{if .Files}}<a href="#files">Files</a>{{end}}</nav>
+{{range .Sections}}
+<section id="{{.ID}}">
+<h2>{{.Title}}</h2>
+<p><a href="{{.File}}">{{.File}}</a></p>
+<pre>{{.JSON}}</pre>
+</section>
+{{end}}
+{{if .Files}}
+<section id="files">
+<h2>Files</h2>
+<ul>{{range .Files}}<li><a href="{{.}}">{{.}}</a></li>{{end}}</ul>
+</section>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,108 @@
+package blob
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestStore(t *testing.T) (*LocalStore, string) {
+	t.Helper()
+	root := filepath.Join(t.TempDir(), "blobs")
+	ls, err := NewLocalStore(root)
+	if err != nil {
+		t.Fatalf("NewLocalStore: %s", err)
+	}
+	return ls, root
+}
+
+func read(t *testing.T, ls *LocalStore, key string) string {
+	t.Helper()
+	r, err := ls.Get(key)
+	if err != nil {
+		t.Fatalf("Get(%s): %s", key, err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestLocalStore(t *testing.T) {
+	ls, root := newTestStore(t)
+	if err := ls.Put("media/1/original.png", strings.NewReader("first")); err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+	if got := read(t, ls, "media/1/original.png"); got != "first" {
+		t.Errorf("Get = %q, want %q", got, "first")
+	}
+	if err := ls.Put("media/1/original.png", strings.NewReader("second")); err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+	if got := read(t, ls, "media/1/original.png"); got != "second" {
+		t.Errorf("Get after replacing = %q, want %q", got, "second")
+	}
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(filepath.Join(root, "media", "1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("blob directory has %d entries, want 1", len(entries))
+	}
+
+	if err := ls.Delete("media/1/original.png"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	if _, err := ls.Get("media/1/original.png"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete: got error %v, want ErrNotFound", err)
+	}
+	if err := ls.Delete("media/1/original.png"); err != nil {
+		t.Errorf("deleting a missing blob: %s", err)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, errors.New("connection reset") }
+
+func TestLocalStorePutFailureKeepsOldBlob(t *testing.T) {
+	ls, root := newTestStore(t)
+	if err := ls.Put("a", strings.NewReader("old")); err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+	if err := ls.Put("a", io.MultiReader(strings.NewReader("partial"), failingReader{})); err == nil {
+		t.Fatal("Put succeeded with a failing reader")
+	}
+	if got := read(t, ls, "a"); got != "old" {
+		t.Errorf("Get = %q, want the blob from before the failed Put", got)
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("store has %d entries after a failed Put, want 1", len(entries))
+	}
+}
+
+func TestLocalStoreInvalidKeys(t *testing.T) {
+	ls, _ := newTestStore(t)
+	for _, key := range []string{"", "/etc/passwd", "../outside", "a/../../b", "a//b", "a/./b", "a/", "with space", "naïve", `a\b`} {
+		if err := ls.Put(key, strings.NewReader("x")); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put(%q): got error %v, want ErrInvalidKey", key, err)
+		}
+		if _, err := ls.Get(key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Get(%q): got error %v, want ErrInvalidKey", key, err)
+		}
+		if err := ls.Delete(key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Delete(%q): got error %v, want ErrInvalidKey", key, err)
+		}
+	}
+}
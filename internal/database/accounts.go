@@ -0,0 +1,237 @@
+package database
+
+import (
+	"slices"
+	"time"
+)
+
+// Deactivated reports whether the user is suspended or waiting to be
+// deleted, either of which takes them and their chirps out of view.
+func (u User) Deactivated() bool {
+	return u.Suspended || u.DeletionScheduledAt != nil
+}
+
+func (db *DB) GetAuthUser(userID int) (AuthUser, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	user, ok := db.data.Users[userID]
+	if !ok {
+		return AuthUser{}, ErrDoesNotExist
+	}
+	return user, nil
+}
+
+// ScheduleUserDeletion deactivates userID's account and schedules it to be
+// deleted at the given time. All their refresh tokens are revoked.
+func (db *DB) ScheduleUserDeletion(userID int, at time.Time) (User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+	user, ok := db.data.Users[userID]
+	if !ok {
+		return User{}, ErrDoesNotExist
+	}
+	at = at.UTC()
+	user.DeletionScheduledAt = &at
+	db.data.Users[userID] = user
+	db.revokeRefreshTokens(userID)
+	return user.User, db.writeDB()
+}
+
+// CancelUserDeletion reactivates an account that was scheduled for deletion.
+func (db *DB) CancelUserDeletion(userID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	user, ok := db.data.Users[userID]
+	if !ok {
+		return ErrDoesNotExist
+	}
+	user.DeletionScheduledAt = nil
+	db.data.Users[userID] = user
+	return db.writeDB()
+}
+
+// GetUsersDueForDeletion returns the IDs of the users whose deletion grace
+// period has ended.
+func (db *DB) GetUsersDueForDeletion(now time.Time) []int {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	var ids []int
+	for id, u := range db.data.Users {
+		if u.DeletionScheduledAt != nil && !u.DeletionScheduledAt.After(now) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// DeleteUser removes a user and everything that belongs to them in a single
+// write: their chirps, drafts, attachments, votes, bookmarks, follows, blocks,
+// mutes, exports, unresolved reports about them and refresh tokens. Reports
+// they filed are withdrawn while unresolved, and kept without a reporter once
+// resolved. Their email is freed straight away, while their handle is
+// reserved for HandleReservationPeriod so nobody can impersonate them. It
+// returns the deleted attachments so their blobs can be removed too, and the
+// deleted chirps so their deletion can be announced.
+func (db *DB) DeleteUser(userID int) ([]Attachment, []Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return nil, nil, err
+	}
+	user, ok := db.data.Users[userID]
+	if !ok {
+		return nil, nil, ErrDoesNotExist
+	}
+
+	var attachments []Attachment
+	for id, a := range db.data.Attachments {
+		if a.OwnerID == userID {
+			attachments = append(attachments, a)
+			delete(db.data.Attachments, id)
+		}
+	}
+	var chirps []Chirp
+	for _, chirpID := range append([]int{}, db.data.AuthorChirpIndex[userID]...) {
+		chirp := db.data.Chirps[chirpID]
+		chirps = append(chirps, chirp)
+		db.removeChirp(chirp)
+	}
+	for id, p := range db.data.PendingChirps {
+		if p.AuthorID == userID {
+			delete(db.data.PendingChirps, id)
+		}
+	}
+	for id, r := range db.data.Reports {
+		switch {
+		case (r.UserID == userID || r.ReporterID == userID) && r.Status != ReportResolved:
+			delete(db.data.Reports, id)
+		case r.ReporterID == userID:
+			r.ReporterID = 0
+			db.data.Reports[id] = r
+		}
+	}
+	for _, votes := range db.data.PollVotes {
+		delete(votes, userID)
+	}
+	for _, chirpID := range db.data.Bookmarks[userID] {
+		removeFromIndex(db.data.BookmarkIndex, chirpID, userID)
+	}
+	delete(db.data.Bookmarks, userID)
+	delete(db.data.Pins, userID)
+	delete(db.data.SubscriptionHistory, userID)
+	for _, n := range db.data.Notifications {
+		if n.RecipientID == userID {
+			db.removeNotification(n)
+		} else if slices.Contains(n.ActorIDs, userID) {
+			db.removeNotificationActor(n, userID)
+		}
+	}
+	for id, e := range db.data.WebhookEndpoints {
+		if e.OwnerID == userID {
+			db.removeWebhookEndpoint(id)
+		}
+	}
+	for _, id := range slices.Clone(db.data.ConversationIndex[userID]) {
+		db.leaveConversation(db.data.Conversations[id], userID)
+	}
+	removeAllFromSets(db.data.Follows, db.data.FollowerIndex, userID)
+	removeAllFromSets(db.data.Blocks, db.data.BlockedByIndex, userID)
+	removeAllFromSets(db.data.FollowRequests, db.data.FollowRequestIndex, userID)
+	delete(db.data.Mutes, userID)
+	for muterID, mutes := range db.data.Mutes {
+		delete(mutes, userID)
+		if len(mutes) == 0 {
+			delete(db.data.Mutes, muterID)
+		}
+	}
+	delete(db.data.MentionIndex, userID)
+	for id, e := range db.data.Exports {
+		if e.UserID == userID {
+			delete(db.data.Exports, id)
+		}
+	}
+	db.revokeRefreshTokens(userID)
+
+	delete(db.data.UserEmailIndex, user.Email)
+	if user.Handle != "" {
+		key := handleKey(user.Handle)
+		delete(db.data.UserHandleIndex, key)
+		db.data.ReservedHandles[key] = HandleReservation{
+			UserID: userID,
+			Until:  time.Now().UTC().Add(HandleReservationPeriod),
+		}
+	}
+	delete(db.data.Users, userID)
+	return attachments, chirps, db.writeDB()
+}
+
+// SuspendUser suspends a user on a moderator's say-so, outside of any report.
+func (db *DB) SuspendUser(userID int, moderatorID int, note string) (User, error) {
+	return db.setSuspended(userID, moderatorID, note, true)
+}
+
+// UnsuspendUser lifts a suspension.
+func (db *DB) UnsuspendUser(userID int, moderatorID int, note string) (User, error) {
+	return db.setSuspended(userID, moderatorID, note, false)
+}
+
+func (db *DB) setSuspended(userID int, moderatorID int, note string, suspended bool) (User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+	user, ok := db.data.Users[userID]
+	if !ok {
+		return User{}, ErrDoesNotExist
+	}
+	action := ActionUnsuspendUser
+	if suspended {
+		action = ActionSuspendUser
+		db.suspend(userID)
+	} else {
+		user.Suspended = false
+		db.data.Users[userID] = user
+	}
+	db.audit(moderatorID, action, Report{UserID: userID}, note)
+	return db.data.Users[userID].User, db.writeDB()
+}
+
+// suspend marks a user suspended and signs them out everywhere.
+func (db *DB) suspend(userID int) {
+	user := db.data.Users[userID]
+	user.Suspended = true
+	db.data.Users[userID] = user
+	db.revokeRefreshTokens(userID)
+}
+
+func (db *DB) revokeRefreshTokens(userID int) {
+	for token, rt := range db.data.RefreshTokens {
+		if rt.UserID == userID {
+			delete(db.data.RefreshTokens, token)
+		}
+	}
+}
+
+// removeAllFromSets removes key from a relation stored as sets, along with
+// the reverse index of it.
+func removeAllFromSets(sets map[int]map[int]time.Time, reverse map[int]map[int]time.Time, key int) {
+	for member := range sets[key] {
+		removeFromSet(reverse, member, key)
+	}
+	for member := range reverse[key] {
+		removeFromSet(sets, member, key)
+	}
+	delete(sets, key)
+	delete(reverse, key)
+}
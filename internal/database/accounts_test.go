@@ -0,0 +1,52 @@
+package database
+
+import "testing"
+
+func TestDeleteUser(t *testing.T) {
+	db := newTestDB(t)
+	leaving := mustCreateUser(t, db, "leaving")
+	other := mustCreateUser(t, db, "other")
+	first := mustPost(t, db, leaving)
+	second := mustPost(t, db, leaving)
+	kept := mustPost(t, db, other)
+
+	resolved, err := db.ReportChirp(leaving.ID, kept.ID, ReasonSpam, "")
+	if err != nil {
+		t.Fatalf("ReportChirp: %s", err)
+	}
+	if _, err := db.ResolveReport(resolved.ID, other.ID, ActionDismiss, ""); err != nil {
+		t.Fatalf("ResolveReport: %s", err)
+	}
+	if _, err := db.ReportUser(leaving.ID, other.ID, ReasonHarassment, ""); err != nil {
+		t.Fatalf("ReportUser: %s", err)
+	}
+	if _, err := db.ReportUser(other.ID, leaving.ID, ReasonSpam, ""); err != nil {
+		t.Fatalf("ReportUser: %s", err)
+	}
+
+	_, chirps, err := db.DeleteUser(leaving.ID)
+	if err != nil {
+		t.Fatalf("DeleteUser: %s", err)
+	}
+	if len(chirps) != 2 || !(chirps[0].ID == first.ID && chirps[1].ID == second.ID || chirps[0].ID == second.ID && chirps[1].ID == first.ID) {
+		t.Errorf("DeleteUser returned chirps %+v, want %d and %d", chirps, first.ID, second.ID)
+	}
+	for _, chirp := range []Chirp{first, second} {
+		if _, err := db.GetChirp(chirp.ID); err != ErrDoesNotExist {
+			t.Errorf("GetChirp(%d) after DeleteUser: got error %v, want ErrDoesNotExist", chirp.ID, err)
+		}
+	}
+	if _, err := db.GetUser(leaving.ID); err != ErrDoesNotExist {
+		t.Errorf("GetUser after DeleteUser: got error %v, want ErrDoesNotExist", err)
+	}
+
+	// Only the resolved report they filed is left, and it no longer names them
+	reports := db.GetReports(ReportOpen, ReportClaimed, ReportResolved)
+	if len(reports) != 1 || reports[0].ID != resolved.ID || reports[0].ReporterID != 0 {
+		t.Errorf("reports after DeleteUser = %+v, want only report %d without a reporter", reports, resolved.ID)
+	}
+
+	if _, _, err := db.DeleteUser(leaving.ID); err != ErrDoesNotExist {
+		t.Errorf("deleting twice: got error %v, want ErrDoesNotExist", err)
+	}
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	MaxAttachmentsPerChirp = 4
+)
+
+var (
+	ErrInvalidAttachment = errors.New("attachment missing, not owned by author or already used")
+)
+
+// Attachment is an uploaded image. The image itself lives in blob storage
+// under BlobKey, with its thumbnail under ThumbnailKey. ChirpID is 0 until the
+// attachment is posted.
+type Attachment struct {
+	ID                   int       `json:"id"`
+	OwnerID              int       `json:"owner_id"`
+	ChirpID              int       `json:"chirp_id"`
+	ContentType          string    `json:"content_type"`
+	Size                 int       `json:"size"`
+	Width                int       `json:"width"`
+	Height               int       `json:"height"`
+	BlobKey              string    `json:"blob_key"`
+	ThumbnailContentType string    `json:"thumbnail_content_type"`
+	ThumbnailWidth       int       `json:"thumbnail_width"`
+	ThumbnailHeight      int       `json:"thumbnail_height"`
+	ThumbnailKey         string    `json:"thumbnail_key"`
+	CreatedAt            time.Time `json:"created_at"`
+}
+
+// CreateAttachment stores the metadata of an uploaded image, assigning its ID.
+// keys is called with the new ID to name the blobs.
+func (db *DB) CreateAttachment(a Attachment, keys func(id int) (blobKey string, thumbnailKey string)) (Attachment, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return Attachment{}, err
+	}
+	a.ID = db.data.LastAttachmentID + 1
+	a.ChirpID = 0
+	a.BlobKey, a.ThumbnailKey = keys(a.ID)
+	a.CreatedAt = time.Now().UTC()
+	db.data.Attachments[a.ID] = a
+	db.data.LastAttachmentID = a.ID
+	err = db.writeDB()
+	if err != nil {
+		return Attachment{}, err
+	}
+	return a, nil
+}
+
+func (db *DB) GetAttachment(attachmentID int) (Attachment, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	a, ok := db.data.Attachments[attachmentID]
+	if !ok {
+		return Attachment{}, ErrDoesNotExist
+	}
+	return a, nil
+}
+
+// GetAttachments returns the attachments with the given IDs, in the same
+// order, skipping any that don't exist.
+func (db *DB) GetAttachments(attachmentIDs []int) []Attachment {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	attachments := make([]Attachment, 0, len(attachmentIDs))
+	for _, id := range attachmentIDs {
+		if a, ok := db.data.Attachments[id]; ok {
+			attachments = append(attachments, a)
+		}
+	}
+	return attachments
+}
+
+func (db *DB) DeleteAttachment(attachmentID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	delete(db.data.Attachments, attachmentID)
+	return db.writeDB()
+}
+
+// attachToChirp claims the attachments for a new chirp, checking first that
+// every one belongs to the author and hasn't been posted before.
+func (db *DB) attachToChirp(chirp Chirp) error {
+	if len(chirp.AttachmentIDs) > MaxAttachmentsPerChirp {
+		return ErrInvalidAttachment
+	}
+	seen := make(map[int]bool, len(chirp.AttachmentIDs))
+	for _, id := range chirp.AttachmentIDs {
+		a, ok := db.data.Attachments[id]
+		if !ok || a.OwnerID != chirp.AuthorID || a.ChirpID != 0 || seen[id] {
+			return ErrInvalidAttachment
+		}
+		seen[id] = true
+	}
+	for _, id := range chirp.AttachmentIDs {
+		a := db.data.Attachments[id]
+		a.ChirpID = chirp.ID
+		db.data.Attachments[id] = a
+	}
+	return nil
+}
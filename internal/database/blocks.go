@@ -0,0 +1,196 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrSelfBlock = errors.New("users cannot block or mute themselves")
+	ErrBlocked   = errors.New("one user has blocked the other")
+)
+
+// Mute hides a user's chirps from the muter's timeline, until ExpiresAt if
+// it's set.
+type Mute struct {
+	MutedAt   time.Time  `json:"muted_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func (m Mute) active(now time.Time) bool {
+	return m.ExpiresAt == nil || now.Before(*m.ExpiresAt)
+}
+
+type MutedUser struct {
+	User
+	ExpiresAt *time.Time
+}
+
+// BlockUser records that blockerID blocks blockedID. Any follows between the
+// two are removed, and neither sees the other's chirps until the block is
+// lifted.
+func (db *DB) BlockUser(blockerID int, blockedID int) error {
+	if blockerID == blockedID {
+		return ErrSelfBlock
+	}
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, ok := db.data.Users[blockedID]; !ok {
+		return ErrDoesNotExist
+	}
+	if _, ok := db.data.Blocks[blockerID][blockedID]; ok {
+		return nil
+	}
+	now := time.Now().UTC()
+	addToSet(db.data.Blocks, blockerID, blockedID, now)
+	addToSet(db.data.BlockedByIndex, blockedID, blockerID, now)
+	removeFromSet(db.data.Follows, blockerID, blockedID)
+	removeFromSet(db.data.FollowerIndex, blockedID, blockerID)
+	removeFromSet(db.data.Follows, blockedID, blockerID)
+	removeFromSet(db.data.FollowerIndex, blockerID, blockedID)
+	removeFromSet(db.data.FollowRequests, blockerID, blockedID)
+	removeFromSet(db.data.FollowRequestIndex, blockedID, blockerID)
+	removeFromSet(db.data.FollowRequests, blockedID, blockerID)
+	removeFromSet(db.data.FollowRequestIndex, blockerID, blockedID)
+	return db.writeDB()
+}
+
+// UnblockUser lifts blockerID's block of blockedID, if any.
+func (db *DB) UnblockUser(blockerID int, blockedID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, ok := db.data.Blocks[blockerID][blockedID]; !ok {
+		return nil
+	}
+	removeFromSet(db.data.Blocks, blockerID, blockedID)
+	removeFromSet(db.data.BlockedByIndex, blockedID, blockerID)
+	return db.writeDB()
+}
+
+// GetBlockedUsers lists the users userID has blocked.
+func (db *DB) GetBlockedUsers(userID int) []User {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	return db.usersFromSet(db.data.Blocks[userID])
+}
+
+// IsBlocked reports whether either user has blocked the other.
+func (db *DB) IsBlocked(userID int, otherID int) bool {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	return db.blocked(userID, otherID)
+}
+
+// MuteUser hides mutedID's chirps from muterID's timeline, until expiresAt if
+// it's not nil. Muting someone again replaces the earlier mute.
+func (db *DB) MuteUser(muterID int, mutedID int, expiresAt *time.Time) error {
+	if muterID == mutedID {
+		return ErrSelfBlock
+	}
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, ok := db.data.Users[mutedID]; !ok {
+		return ErrDoesNotExist
+	}
+	mutes, ok := db.data.Mutes[muterID]
+	if !ok {
+		mutes = make(map[int]Mute)
+		db.data.Mutes[muterID] = mutes
+	}
+	now := time.Now().UTC()
+	for id, m := range mutes {
+		if !m.active(now) {
+			delete(mutes, id)
+		}
+	}
+	mutes[mutedID] = Mute{MutedAt: now, ExpiresAt: expiresAt}
+	return db.writeDB()
+}
+
+// UnmuteUser removes muterID's mute of mutedID, if any.
+func (db *DB) UnmuteUser(muterID int, mutedID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, ok := db.data.Mutes[muterID][mutedID]; !ok {
+		return nil
+	}
+	delete(db.data.Mutes[muterID], mutedID)
+	if len(db.data.Mutes[muterID]) == 0 {
+		delete(db.data.Mutes, muterID)
+	}
+	return db.writeDB()
+}
+
+// GetMutedUsers lists the users userID currently has muted.
+func (db *DB) GetMutedUsers(userID int) []MutedUser {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	now := time.Now()
+	muted := make([]MutedUser, 0, len(db.data.Mutes[userID]))
+	for id, m := range db.data.Mutes[userID] {
+		u, ok := db.data.Users[id]
+		if !ok || !m.active(now) {
+			continue
+		}
+		muted = append(muted, MutedUser{User: u.User, ExpiresAt: m.ExpiresAt})
+	}
+	sortSlice(muted, Asc, func(m MutedUser) int { return m.ID })
+	return muted
+}
+
+// CanView reports whether viewerID, or an anonymous viewer if it's 0, may see
+// chirp.
+func (db *DB) CanView(viewerID int, chirp Chirp) bool {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	return !db.hiddenFrom(viewerID)(chirp)
+}
+
+// hiddenFrom returns a filter for the chirps that should be left out of
+// everything viewerID sees: those across a block, and, except for admins,
+// those hidden by moderators, written by deactivated users, or not shared with
+// viewerID. Authors can still see their own hidden chirps.
+func (db *DB) hiddenFrom(viewerID int) func(Chirp) bool {
+	admin := db.data.Users[viewerID].Admin
+	return func(c Chirp) bool {
+		if viewerID != 0 && db.blocked(viewerID, c.AuthorID) {
+			return true
+		}
+		if admin {
+			return false
+		}
+		if db.data.Users[c.AuthorID].Deactivated() || !db.inAudience(viewerID, c) {
+			return true
+		}
+		return c.Hidden && c.AuthorID != viewerID
+	}
+}
+
+func (db *DB) blocked(userID int, otherID int) bool {
+	if _, ok := db.data.Blocks[userID][otherID]; ok {
+		return true
+	}
+	_, ok := db.data.Blocks[otherID][userID]
+	return ok
+}
+
+func (db *DB) muted(muterID int, mutedID int, now time.Time) bool {
+	m, ok := db.data.Mutes[muterID][mutedID]
+	return ok && m.active(now)
+}
@@ -0,0 +1,120 @@
+package database
+
+import (
+	"errors"
+	"slices"
+)
+
+var (
+	ErrNotAuthor = errors.New("chirp belongs to another user")
+	ErrPinLimit  = errors.New("too many pinned chirps")
+)
+
+// BookmarkChirp privately saves chirpID for userID. Bookmarking a chirp twice
+// is a no-op.
+func (db *DB) BookmarkChirp(userID int, chirpID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	chirp, ok := db.data.Chirps[chirpID]
+	if !ok || db.hiddenFrom(userID)(chirp) {
+		return ErrDoesNotExist
+	}
+	db.data.Bookmarks[userID] = insertSorted(db.data.Bookmarks[userID], chirpID)
+	db.data.BookmarkIndex[chirpID] = insertSorted(db.data.BookmarkIndex[chirpID], userID)
+	return db.writeDB()
+}
+
+// RemoveBookmark removes userID's bookmark of chirpID, if any.
+func (db *DB) RemoveBookmark(userID int, chirpID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	removeFromIndex(db.data.Bookmarks, userID, chirpID)
+	removeFromIndex(db.data.BookmarkIndex, chirpID, userID)
+	return db.writeDB()
+}
+
+// GetBookmarks returns a newest-first page of the chirps userID bookmarked.
+func (db *DB) GetBookmarks(userID int, beforeID int, limit int) []Chirp {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	return db.pageFromIndex(db.data.Bookmarks[userID], beforeID, limit, db.hiddenFrom(userID))
+}
+
+// PinChirp pins one of userID's own chirps to the top of their profile. At
+// most maxPins chirps can be pinned at once; pinning an already pinned chirp
+// moves it to the top.
+func (db *DB) PinChirp(userID int, chirpID int, maxPins int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	chirp, ok := db.data.Chirps[chirpID]
+	if !ok {
+		return ErrDoesNotExist
+	}
+	if chirp.AuthorID != userID {
+		return ErrNotAuthor
+	}
+	pins := slices.DeleteFunc(db.data.Pins[userID], func(id int) bool { return id == chirpID })
+	if len(pins) >= maxPins {
+		return ErrPinLimit
+	}
+	db.data.Pins[userID] = append([]int{chirpID}, pins...)
+	return db.writeDB()
+}
+
+// UnpinChirp unpins chirpID from userID's profile, if it was pinned.
+func (db *DB) UnpinChirp(userID int, chirpID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	db.unpin(userID, chirpID)
+	return db.writeDB()
+}
+
+// GetPinnedChirps returns the chirps pinned by userID, most recently pinned
+// first, if viewerID may see them.
+func (db *DB) GetPinnedChirps(viewerID int, userID int) []Chirp {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	hidden := db.hiddenFrom(viewerID)
+	chirps := make([]Chirp, 0, len(db.data.Pins[userID]))
+	for _, id := range db.data.Pins[userID] {
+		if chirp := db.data.Chirps[id]; !hidden(chirp) {
+			chirps = append(chirps, chirp)
+		}
+	}
+	return chirps
+}
+
+func (db *DB) unpin(userID int, chirpID int) {
+	pins := slices.DeleteFunc(db.data.Pins[userID], func(id int) bool { return id == chirpID })
+	if len(pins) == 0 {
+		delete(db.data.Pins, userID)
+		return
+	}
+	db.data.Pins[userID] = pins
+}
+
+// removeBookmarksAndPins drops every reference to a chirp that is being
+// deleted.
+func (db *DB) removeBookmarksAndPins(chirp Chirp) {
+	for _, userID := range db.data.BookmarkIndex[chirp.ID] {
+		removeFromIndex(db.data.Bookmarks, userID, chirp.ID)
+	}
+	delete(db.data.BookmarkIndex, chirp.ID)
+	db.unpin(chirp.AuthorID, chirp.ID)
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"os"
+	"sort"
 	"sync"
 	"time"
 )
@@ -15,23 +16,55 @@ import (
 
 const (
 	fileMode = 0666
+
+	// indexVersion is bumped whenever a derived index is added or changes
+	// shape, so databases written by older versions get their indexes rebuilt
+	// on startup.
+	indexVersion = 3
 )
 
 var (
-	ErrDoesNotExist  = errors.New("does not exist")
-	ErrAlreadyExists = errors.New("already exists")
+	ErrDoesNotExist   = errors.New("does not exist")
+	ErrAlreadyExists  = errors.New("already exists")
+	ErrHandleReserved = errors.New("handle is reserved")
 )
 
 type Chirp struct {
-	ID       int    `json:"id"`
-	AuthorID int    `json:"author_id"`
-	Body     string `json:"body"`
+	ID            int        `json:"id"`
+	AuthorID      int        `json:"author_id"`
+	Body          string     `json:"body"`
+	Entities      []Entity   `json:"entities,omitempty"`
+	Flags         []string   `json:"flags,omitempty"`
+	AttachmentIDs []int      `json:"attachment_ids,omitempty"`
+	Poll          *Poll      `json:"poll,omitempty"`
+	Hidden        bool       `json:"hidden,omitempty"`
+	Visibility    Visibility `json:"visibility,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	EditedAt      time.Time  `json:"edited_at"`
 }
 
 type User struct {
-	ID        int    `json:"id"`
-	Email     string `json:"email"`
-	ChirpyRed bool   `json:"chirpy_red"`
+	ID    int    `json:"id"`
+	Email string `json:"email"`
+	// ChirpyRed is whether the user had Chirpy Red when their subscription
+	// last changed. Use HasChirpyRed to check it at a given time.
+	ChirpyRed   bool   `json:"chirpy_red"`
+	Admin       bool   `json:"admin"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	Avatar      string `json:"avatar"`
+	Suspended   bool   `json:"suspended,omitempty"`
+	// DeletionScheduledAt is set while the user's account is waiting out the
+	// grace period before it's deleted.
+	DeletionScheduledAt *time.Time    `json:"deletion_scheduled_at,omitempty"`
+	Subscription        *Subscription `json:"subscription,omitempty"`
+	// DMsFromFollowingOnly limits who can message the user to people they
+	// follow.
+	DMsFromFollowingOnly bool `json:"dms_from_following_only,omitempty"`
+	// Protected accounts approve their followers, and only share chirps
+	// with them.
+	Protected bool `json:"protected,omitempty"`
 }
 
 type AuthUser struct {
@@ -46,12 +79,59 @@ type RefreshToken struct {
 }
 
 type DBRepresentation struct {
-	LastChirpID    int                     `json:"last_chirp_id"`
-	LastUserID     int                     `json:"last_user_id"`
-	Chirps         map[int]Chirp           `json:"chirps"`
-	Users          map[int]AuthUser        `json:"users"`
-	UserEmailIndex map[string]int          `json:"user_email_idx"`
-	RefreshTokens  map[string]RefreshToken `json:"refresh_tokens"`
+	IndexVersion        int                          `json:"index_version"`
+	LastChirpID         int                          `json:"last_chirp_id"`
+	LastUserID          int                          `json:"last_user_id"`
+	LastAttachmentID    int                          `json:"last_attachment_id"`
+	LastPendingID       int                          `json:"last_pending_id"`
+	LastReportID        int                          `json:"last_report_id"`
+	LastExportID        int                          `json:"last_export_id"`
+	LastEndpointID      int                          `json:"last_endpoint_id"`
+	LastDeliveryID      int                          `json:"last_delivery_id"`
+	LastNotificationID  int                          `json:"last_notification_id"`
+	LastNotificationSeq int                          `json:"last_notification_seq"`
+	LastConversationID  int                          `json:"last_conversation_id"`
+	LastConversationSeq int                          `json:"last_conversation_seq"`
+	LastMessageID       int                          `json:"last_message_id"`
+	Chirps              map[int]Chirp                `json:"chirps"`
+	Users               map[int]AuthUser             `json:"users"`
+	UserEmailIndex      map[string]int               `json:"user_email_idx"`
+	RefreshTokens       map[string]RefreshToken      `json:"refresh_tokens"`
+	UserHandleIndex     map[string]int               `json:"user_handle_idx"`
+	ReservedHandles     map[string]HandleReservation `json:"reserved_handles"`
+	AuthorChirpIndex    map[int][]int                `json:"author_chirp_idx"`
+	Follows             map[int]map[int]time.Time    `json:"follows"`
+	FollowerIndex       map[int]map[int]time.Time    `json:"follower_idx"`
+	HashtagIndex        map[string][]int             `json:"hashtag_idx"`
+	MentionIndex        map[int][]int                `json:"mention_idx"`
+	SearchIndex         map[string]map[int][]int     `json:"search_idx"`
+	ChirpRevisions      map[int][]ChirpRevision      `json:"chirp_revisions"`
+	Attachments         map[int]Attachment           `json:"attachments"`
+	PendingChirps       map[int]PendingChirp         `json:"pending_chirps"`
+	PollVotes           map[int]map[int]int          `json:"poll_votes"`
+	Bookmarks           map[int][]int                `json:"bookmarks"`
+	BookmarkIndex       map[int][]int                `json:"bookmark_index"`
+	Pins                map[int][]int                `json:"pins"`
+	Blocks              map[int]map[int]time.Time    `json:"blocks"`
+	BlockedByIndex      map[int]map[int]time.Time    `json:"blocked_by_index"`
+	Mutes               map[int]map[int]Mute         `json:"mutes"`
+	Reports             map[int]Report               `json:"reports"`
+	AuditLog            []AuditEntry                 `json:"audit_log"`
+	Exports             map[int]Export               `json:"exports"`
+	WebhookEvents       map[string]WebhookEvent      `json:"webhook_events"`
+	// SubscriptionHistory is the Chirpy Red subscription changes of each
+	// user, oldest first.
+	SubscriptionHistory      map[int][]SubscriptionChange `json:"subscription_history"`
+	WebhookEndpoints         map[int]WebhookEndpoint      `json:"webhook_endpoints"`
+	WebhookDeliveries        map[int]WebhookDelivery      `json:"webhook_deliveries"`
+	Notifications            map[int]Notification         `json:"notifications"`
+	NotificationIndex        map[int][]int                `json:"notification_idx"`
+	Conversations            map[int]Conversation         `json:"conversations"`
+	Messages                 map[int]Message              `json:"messages"`
+	ConversationIndex        map[int][]int                `json:"conversation_idx"`
+	ConversationMessageIndex map[int][]int                `json:"conversation_message_idx"`
+	FollowRequests           map[int]map[int]time.Time    `json:"follow_requests"`
+	FollowRequestIndex       map[int]map[int]time.Time    `json:"follow_request_idx"`
 }
 
 type DB struct {
@@ -67,7 +147,59 @@ func (db *DB) ensureDB() error {
 	if err != nil && os.IsNotExist(err) {
 		return db.writeDB()
 	}
-	return err
+	if err != nil {
+		return err
+	}
+	if db.data.IndexVersion < indexVersion {
+		db.rebuildChirpIndexes()
+		db.data.IndexVersion = indexVersion
+		return db.writeDB()
+	}
+	return nil
+}
+
+// rebuildChirpIndexes regenerates every index derived from chirps from the
+// chirps themselves, for databases written before an index existed.
+func (db *DB) rebuildChirpIndexes() {
+	db.data.AuthorChirpIndex = make(map[int][]int)
+	db.data.HashtagIndex = make(map[string][]int)
+	db.data.MentionIndex = make(map[int][]int)
+	db.data.SearchIndex = make(map[string]map[int][]int)
+	ids := make([]int, 0, len(db.data.Chirps))
+	for id := range db.data.Chirps {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		db.indexChirp(db.data.Chirps[id])
+	}
+}
+
+// indexChirp adds chirp to the chirp indexes.
+func (db *DB) indexChirp(chirp Chirp) {
+	db.data.AuthorChirpIndex[chirp.AuthorID] = insertSorted(db.data.AuthorChirpIndex[chirp.AuthorID], chirp.ID)
+	for _, e := range chirp.Entities {
+		switch e.Type {
+		case EntityHashtag:
+			db.data.HashtagIndex[e.Text] = insertSorted(db.data.HashtagIndex[e.Text], chirp.ID)
+		case EntityMention:
+			db.data.MentionIndex[e.UserID] = insertSorted(db.data.MentionIndex[e.UserID], chirp.ID)
+		}
+	}
+	db.indexChirpText(chirp)
+}
+
+func (db *DB) unindexChirp(chirp Chirp) {
+	removeFromIndex(db.data.AuthorChirpIndex, chirp.AuthorID, chirp.ID)
+	for _, e := range chirp.Entities {
+		switch e.Type {
+		case EntityHashtag:
+			removeFromIndex(db.data.HashtagIndex, e.Text, chirp.ID)
+		case EntityMention:
+			removeFromIndex(db.data.MentionIndex, e.UserID, chirp.ID)
+		}
+	}
+	db.unindexChirpText(chirp)
 }
 
 func (db *DB) loadDB() error {
@@ -87,7 +219,7 @@ func (db *DB) writeDB() error {
 	return os.WriteFile(db.path, data, fileMode)
 }
 
-func (db *DB) CreateUser(email string, pwHash string) (User, error) {
+func (db *DB) CreateUser(email string, pwHash string, handle string, displayName string) (User, error) {
 	db.mux.Lock()
 	defer db.mux.Unlock()
 	err := db.loadDB()
@@ -100,13 +232,20 @@ func (db *DB) CreateUser(email string, pwHash string) (User, error) {
 	}
 	newUser := AuthUser{
 		User: User{
-			ID:    db.data.LastUserID + 1,
-			Email: email,
+			ID:          db.data.LastUserID + 1,
+			Email:       email,
+			Handle:      handle,
+			DisplayName: displayName,
 		},
 		Password: pwHash,
 	}
+	err = db.checkHandleAvailable(handle, newUser.ID)
+	if err != nil {
+		return User{}, err
+	}
 	db.data.Users[newUser.ID] = newUser
 	db.data.UserEmailIndex[newUser.Email] = newUser.ID
+	db.data.UserHandleIndex[handleKey(handle)] = newUser.ID
 	db.data.LastUserID = newUser.ID
 	err = db.writeDB()
 	if err != nil {
@@ -120,12 +259,24 @@ func (db *DB) GetUsers() []User {
 	defer db.mux.RUnlock()
 	users := make([]User, 0, len(db.data.Users))
 	for _, u := range db.data.Users {
-		users = append(users, u.User)
+		if !u.Deactivated() {
+			users = append(users, u.User)
+		}
 	}
 	sortSlice(users, Asc, func(u User) int { return u.ID })
 	return users
 }
 
+func (db *DB) GetUser(userID int) (User, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	user, ok := db.data.Users[userID]
+	if !ok {
+		return User{}, ErrDoesNotExist
+	}
+	return user.User, nil
+}
+
 func (db *DB) UserExists(email string) bool {
 	db.mux.RLock()
 	defer db.mux.RUnlock()
@@ -170,22 +321,6 @@ func (db *DB) UpdateUser(userID int, email string, pwHash string) (User, error)
 	return user.User, nil
 }
 
-func (db *DB) UpgradeUser(userID int) error {
-	db.mux.Lock()
-	defer db.mux.Unlock()
-	err := db.loadDB()
-	if err != nil {
-		return err
-	}
-	user, ok := db.data.Users[userID]
-	if !ok {
-		return ErrDoesNotExist
-	}
-	user.ChirpyRed = true
-	db.data.Users[userID] = user
-	return db.writeDB()
-}
-
 func (db *DB) CreateRefreshToken(token string, userID int, expiresInSeconds int) (RefreshToken, error) {
 	db.mux.Lock()
 	defer db.mux.Unlock()
@@ -235,20 +370,19 @@ func (db *DB) DeleteRefreshToken(token string) error {
 	return nil
 }
 
-func (db *DB) CreateChirp(body string, authorID int) (Chirp, error) {
+// CreateChirp stores a new chirp built from the author, body, entities, flags,
+// attachments and poll of chirp, assigning its ID and creation time.
+func (db *DB) CreateChirp(chirp Chirp) (Chirp, error) {
 	db.mux.Lock()
 	defer db.mux.Unlock()
 	err := db.loadDB()
 	if err != nil {
 		return Chirp{}, err
 	}
-	newChirp := Chirp{
-		ID:       db.data.LastChirpID + 1,
-		AuthorID: authorID,
-		Body:     body,
+	newChirp, err := db.insertChirp(chirp)
+	if err != nil {
+		return Chirp{}, err
 	}
-	db.data.Chirps[newChirp.ID] = newChirp
-	db.data.LastChirpID = newChirp.ID
 	err = db.writeDB()
 	if err != nil {
 		return Chirp{}, err
@@ -256,6 +390,30 @@ func (db *DB) CreateChirp(body string, authorID int) (Chirp, error) {
 	return newChirp, nil
 }
 
+// insertChirp adds a new chirp to the in-memory data without writing it out.
+func (db *DB) insertChirp(chirp Chirp) (Chirp, error) {
+	newChirp := Chirp{
+		ID:            db.data.LastChirpID + 1,
+		AuthorID:      chirp.AuthorID,
+		Body:          chirp.Body,
+		Entities:      chirp.Entities,
+		Flags:         chirp.Flags,
+		AttachmentIDs: chirp.AttachmentIDs,
+		Poll:          chirp.Poll,
+		Visibility:    chirp.Visibility,
+		CreatedAt:     time.Now().UTC(),
+	}
+	err := db.attachToChirp(newChirp)
+	if err != nil {
+		return Chirp{}, err
+	}
+	db.data.Chirps[newChirp.ID] = newChirp
+	db.indexChirp(newChirp)
+	db.data.LastChirpID = newChirp.ID
+	db.reportFlags(newChirp)
+	return newChirp, nil
+}
+
 func (db *DB) GetChirp(chirpID int) (Chirp, error) {
 	db.mux.RLock()
 	defer db.mux.RUnlock()
@@ -266,12 +424,27 @@ func (db *DB) GetChirp(chirpID int) (Chirp, error) {
 	return c, nil
 }
 
-func (db *DB) GetChirps(authorID int, sortDirection SortOrder) []Chirp {
+// GetChirps returns every listed chirp viewerID may see, or all of those by
+// authorID if it isn't 0.
+func (db *DB) GetChirps(viewerID int, authorID int, sortDirection SortOrder) []Chirp {
 	db.mux.RLock()
 	defer db.mux.RUnlock()
+	hidden := db.hiddenFrom(viewerID)
+	if authorID != 0 {
+		ids := db.data.AuthorChirpIndex[authorID]
+		chirps := make([]Chirp, 0, len(ids))
+		for _, id := range ids {
+			if chirp := db.data.Chirps[id]; !hidden(chirp) {
+				chirps = append(chirps, chirp)
+			}
+		}
+		sortSlice(chirps, sortDirection, func(c Chirp) int { return c.ID })
+		return chirps
+	}
+	hidden = db.hiddenFromListings(viewerID)
 	chirps := make([]Chirp, 0, len(db.data.Chirps))
 	for _, chirp := range db.data.Chirps {
-		if authorID == 0 || chirp.AuthorID == authorID {
+		if !hidden(chirp) {
 			chirps = append(chirps, chirp)
 		}
 	}
@@ -280,14 +453,38 @@ func (db *DB) GetChirps(authorID int, sortDirection SortOrder) []Chirp {
 }
 
 func (db *DB) DeleteChirp(chirpID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
 	err := db.loadDB()
 	if err != nil {
 		return err
 	}
-	delete(db.data.Chirps, chirpID)
+	chirp, ok := db.data.Chirps[chirpID]
+	if !ok {
+		return nil
+	}
+	db.removeChirp(chirp)
 	return db.writeDB()
 }
 
+// removeChirp deletes a chirp along with its history, attachments, votes and
+// every index and reference to it.
+func (db *DB) removeChirp(chirp Chirp) {
+	delete(db.data.Chirps, chirp.ID)
+	delete(db.data.ChirpRevisions, chirp.ID)
+	delete(db.data.PollVotes, chirp.ID)
+	db.removeBookmarksAndPins(chirp)
+	for _, id := range chirp.AttachmentIDs {
+		delete(db.data.Attachments, id)
+	}
+	db.unindexChirp(chirp)
+	for _, n := range db.data.Notifications {
+		if n.ChirpID == chirp.ID {
+			db.removeNotification(n)
+		}
+	}
+}
+
 func NewDB(path string, truncate bool) (*DB, error) {
 	if truncate {
 		// Might be worth having some extra guarding to ensure we don't accidentally
@@ -297,12 +494,44 @@ func NewDB(path string, truncate bool) (*DB, error) {
 	newDB := &DB{
 		path: path,
 		data: DBRepresentation{
-			LastChirpID:    0,
-			LastUserID:     0,
-			Chirps:         make(map[int]Chirp),
-			Users:          make(map[int]AuthUser),
-			UserEmailIndex: make(map[string]int),
-			RefreshTokens:  make(map[string]RefreshToken),
+			LastChirpID:              0,
+			LastUserID:               0,
+			Chirps:                   make(map[int]Chirp),
+			Users:                    make(map[int]AuthUser),
+			UserEmailIndex:           make(map[string]int),
+			RefreshTokens:            make(map[string]RefreshToken),
+			UserHandleIndex:          make(map[string]int),
+			ReservedHandles:          make(map[string]HandleReservation),
+			AuthorChirpIndex:         make(map[int][]int),
+			Follows:                  make(map[int]map[int]time.Time),
+			FollowerIndex:            make(map[int]map[int]time.Time),
+			HashtagIndex:             make(map[string][]int),
+			MentionIndex:             make(map[int][]int),
+			SearchIndex:              make(map[string]map[int][]int),
+			ChirpRevisions:           make(map[int][]ChirpRevision),
+			Attachments:              make(map[int]Attachment),
+			PendingChirps:            make(map[int]PendingChirp),
+			PollVotes:                make(map[int]map[int]int),
+			Bookmarks:                make(map[int][]int),
+			BookmarkIndex:            make(map[int][]int),
+			Pins:                     make(map[int][]int),
+			Blocks:                   make(map[int]map[int]time.Time),
+			BlockedByIndex:           make(map[int]map[int]time.Time),
+			Mutes:                    make(map[int]map[int]Mute),
+			Reports:                  make(map[int]Report),
+			Exports:                  make(map[int]Export),
+			WebhookEvents:            make(map[string]WebhookEvent),
+			SubscriptionHistory:      make(map[int][]SubscriptionChange),
+			WebhookEndpoints:         make(map[int]WebhookEndpoint),
+			WebhookDeliveries:        make(map[int]WebhookDelivery),
+			Notifications:            make(map[int]Notification),
+			NotificationIndex:        make(map[int][]int),
+			Conversations:            make(map[int]Conversation),
+			Messages:                 make(map[int]Message),
+			ConversationIndex:        make(map[int][]int),
+			ConversationMessageIndex: make(map[int][]int),
+			FollowRequests:           make(map[int]map[int]time.Time),
+			FollowRequestIndex:       make(map[int]map[int]time.Time),
 		},
 		mux: &sync.RWMutex{},
 	}
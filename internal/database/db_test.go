@@ -0,0 +1,27 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// newTestDB returns an empty database in a temporary directory.
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"), true)
+	if err != nil {
+		t.Fatalf("NewDB: %s", err)
+	}
+	return db
+}
+
+// mustCreateUser creates a user with the given handle.
+func mustCreateUser(t *testing.T, db *DB, handle string) User {
+	t.Helper()
+	user, err := db.CreateUser(fmt.Sprintf("%s@example.com", handle), "hash", handle, "")
+	if err != nil {
+		t.Fatalf("CreateUser(%s): %s", handle, err)
+	}
+	return user
+}
@@ -0,0 +1,320 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+)
+
+var (
+	ErrEndpointLimit = errors.New("webhook endpoint limit reached")
+)
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliverySucceeded DeliveryStatus = "succeeded"
+	// DeliveryDead deliveries ran out of attempts and won't be retried
+	// unless their owner asks for them to be.
+	DeliveryDead DeliveryStatus = "dead"
+)
+
+// WebhookEndpoint is a URL that a user has asked to be sent events of the
+// given types, signed with Secret.
+type WebhookEndpoint struct {
+	ID        int       `json:"id"`
+	OwnerID   int       `json:"owner_id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DeliveryAttempt is the result of one attempt to send a webhook delivery.
+// StatusCode is zero if no response was received.
+type DeliveryAttempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+// WebhookDelivery is an event queued for a webhook endpoint. While pending,
+// NextAttemptAt is when it should next be sent.
+type WebhookDelivery struct {
+	ID            int               `json:"id"`
+	EndpointID    int               `json:"endpoint_id"`
+	Event         string            `json:"event"`
+	Payload       json.RawMessage   `json:"payload"`
+	Status        DeliveryStatus    `json:"status"`
+	Attempts      []DeliveryAttempt `json:"attempts"`
+	NextAttemptAt time.Time         `json:"next_attempt_at"`
+	CreatedAt     time.Time         `json:"created_at"`
+	CompletedAt   *time.Time        `json:"completed_at,omitempty"`
+}
+
+// CreateWebhookEndpoint registers endpoint for its owner, who may have at most
+// limit endpoints.
+func (db *DB) CreateWebhookEndpoint(endpoint WebhookEndpoint, limit int) (WebhookEndpoint, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+	if _, ok := db.data.Users[endpoint.OwnerID]; !ok {
+		return WebhookEndpoint{}, ErrDoesNotExist
+	}
+	count := 0
+	for _, e := range db.data.WebhookEndpoints {
+		if e.OwnerID == endpoint.OwnerID {
+			count++
+		}
+	}
+	if count >= limit {
+		return WebhookEndpoint{}, ErrEndpointLimit
+	}
+	db.data.LastEndpointID++
+	endpoint.ID = db.data.LastEndpointID
+	endpoint.CreatedAt = time.Now().UTC()
+	db.data.WebhookEndpoints[endpoint.ID] = endpoint
+	return endpoint, db.writeDB()
+}
+
+func (db *DB) GetWebhookEndpoint(endpointID int) (WebhookEndpoint, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	endpoint, ok := db.data.WebhookEndpoints[endpointID]
+	if !ok {
+		return WebhookEndpoint{}, ErrDoesNotExist
+	}
+	return endpoint, nil
+}
+
+// GetWebhookEndpoints returns ownerID's endpoints, oldest first.
+func (db *DB) GetWebhookEndpoints(ownerID int) []WebhookEndpoint {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	endpoints := []WebhookEndpoint{}
+	for _, e := range db.data.WebhookEndpoints {
+		if e.OwnerID == ownerID {
+			endpoints = append(endpoints, e)
+		}
+	}
+	sortSlice(endpoints, Asc, func(e WebhookEndpoint) int { return e.ID })
+	return endpoints
+}
+
+// DeleteWebhookEndpoint removes an endpoint and its deliveries.
+func (db *DB) DeleteWebhookEndpoint(endpointID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, ok := db.data.WebhookEndpoints[endpointID]; !ok {
+		return nil
+	}
+	db.removeWebhookEndpoint(endpointID)
+	return db.writeDB()
+}
+
+func (db *DB) removeWebhookEndpoint(endpointID int) {
+	delete(db.data.WebhookEndpoints, endpointID)
+	for id, d := range db.data.WebhookDeliveries {
+		if d.EndpointID == endpointID {
+			delete(db.data.WebhookDeliveries, id)
+		}
+	}
+}
+
+// HasWebhookSubscribers reports whether ownerID has an endpoint subscribed to
+// event, so callers can skip building payloads nobody will receive.
+func (db *DB) HasWebhookSubscribers(ownerID int, event string) bool {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	for _, e := range db.data.WebhookEndpoints {
+		if e.OwnerID == ownerID && slices.Contains(e.Events, event) {
+			return true
+		}
+	}
+	return false
+}
+
+// QueueWebhookDeliveries queues payload for each of ownerID's endpoints that
+// are subscribed to event, returning how many deliveries were queued.
+func (db *DB) QueueWebhookDeliveries(ownerID int, event string, payload []byte) (int, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC()
+	queued := 0
+	for _, e := range db.data.WebhookEndpoints {
+		if e.OwnerID != ownerID || !slices.Contains(e.Events, event) {
+			continue
+		}
+		db.data.LastDeliveryID++
+		db.data.WebhookDeliveries[db.data.LastDeliveryID] = WebhookDelivery{
+			ID:            db.data.LastDeliveryID,
+			EndpointID:    e.ID,
+			Event:         event,
+			Payload:       payload,
+			Status:        DeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		}
+		queued++
+	}
+	if queued == 0 {
+		return 0, nil
+	}
+	return queued, db.writeDB()
+}
+
+// ClaimDueWebhookDeliveries returns up to limit pending deliveries that are
+// due at now, oldest first, and pushes their next attempt back by lease so
+// they aren't claimed again while they're being sent.
+func (db *DB) ClaimDueWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	var due []WebhookDelivery
+	for _, d := range db.data.WebhookDeliveries {
+		if d.Status == DeliveryPending && !d.NextAttemptAt.After(now) {
+			due = append(due, d)
+		}
+	}
+	if len(due) == 0 {
+		return nil, nil
+	}
+	sortSlice(due, Asc, func(d WebhookDelivery) int { return d.ID })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	for i, d := range due {
+		d.NextAttemptAt = now.Add(lease).UTC()
+		db.data.WebhookDeliveries[d.ID] = d
+		due[i] = d
+	}
+	return due, db.writeDB()
+}
+
+// NextWebhookAttemptTime returns when the next pending delivery is due, if
+// there is one.
+func (db *DB) NextWebhookAttemptTime() (time.Time, bool) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	var next time.Time
+	found := false
+	for _, d := range db.data.WebhookDeliveries {
+		if d.Status == DeliveryPending && (!found || d.NextAttemptAt.Before(next)) {
+			next = d.NextAttemptAt
+			found = true
+		}
+	}
+	return next, found
+}
+
+// RecordWebhookAttempt adds attempt to a delivery's log and moves it to
+// status. Pending deliveries are retried at retryAt.
+func (db *DB) RecordWebhookAttempt(deliveryID int, attempt DeliveryAttempt, status DeliveryStatus, retryAt time.Time) (WebhookDelivery, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	d, ok := db.data.WebhookDeliveries[deliveryID]
+	if !ok {
+		return WebhookDelivery{}, ErrDoesNotExist
+	}
+	d.Attempts = append(d.Attempts, attempt)
+	d.Status = status
+	if status == DeliveryPending {
+		d.NextAttemptAt = retryAt.UTC()
+	} else {
+		now := time.Now().UTC()
+		d.CompletedAt = &now
+	}
+	db.data.WebhookDeliveries[deliveryID] = d
+	return d, db.writeDB()
+}
+
+func (db *DB) GetWebhookDelivery(deliveryID int) (WebhookDelivery, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	d, ok := db.data.WebhookDeliveries[deliveryID]
+	if !ok {
+		return WebhookDelivery{}, ErrDoesNotExist
+	}
+	return d, nil
+}
+
+// GetWebhookDeliveries returns the deliveries for endpointID, newest first,
+// optionally only those with the given status.
+func (db *DB) GetWebhookDeliveries(endpointID int, status DeliveryStatus) []WebhookDelivery {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	deliveries := []WebhookDelivery{}
+	for _, d := range db.data.WebhookDeliveries {
+		if d.EndpointID == endpointID && (status == "" || d.Status == status) {
+			deliveries = append(deliveries, d)
+		}
+	}
+	sortSlice(deliveries, Desc, func(d WebhookDelivery) int { return d.ID })
+	return deliveries
+}
+
+// RedeliverWebhook queues a finished delivery to be sent again straight away,
+// keeping its log of earlier attempts.
+func (db *DB) RedeliverWebhook(deliveryID int) (WebhookDelivery, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	d, ok := db.data.WebhookDeliveries[deliveryID]
+	if !ok {
+		return WebhookDelivery{}, ErrDoesNotExist
+	}
+	if d.Status == DeliveryPending {
+		return d, nil
+	}
+	d.Status = DeliveryPending
+	d.NextAttemptAt = time.Now().UTC()
+	d.CompletedAt = nil
+	db.data.WebhookDeliveries[deliveryID] = d
+	return d, db.writeDB()
+}
+
+// PurgeWebhookDeliveries deletes deliveries that finished before the given
+// time.
+func (db *DB) PurgeWebhookDeliveries(before time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	purged := false
+	for id, d := range db.data.WebhookDeliveries {
+		if d.CompletedAt != nil && d.CompletedAt.Before(before) {
+			delete(db.data.WebhookDeliveries, id)
+			purged = true
+		}
+	}
+	if !purged {
+		return nil
+	}
+	return db.writeDB()
+}
@@ -0,0 +1,39 @@
+package database
+
+type EntityType string
+
+const (
+	EntityMention EntityType = "mention"
+	EntityHashtag EntityType = "hashtag"
+)
+
+// Entity is a mention or hashtag within a chirp body. Offsets are into the body
+// as stored; mentions carry the ID of the user they resolved to.
+type Entity struct {
+	Type      EntityType `json:"type"`
+	Text      string     `json:"text"`
+	UserID    int        `json:"user_id,omitempty"`
+	Start     int        `json:"start"`
+	End       int        `json:"end"`
+	RuneStart int        `json:"rune_start"`
+	RuneEnd   int        `json:"rune_end"`
+}
+
+// GetChirpsByHashtag returns a newest-first page of chirps tagged with the
+// normalized hashtag tag that viewerID may see.
+func (db *DB) GetChirpsByHashtag(viewerID int, tag string, beforeID int, limit int) []Chirp {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	return db.pageFromIndex(db.data.HashtagIndex[tag], beforeID, limit, db.hiddenFromListings(viewerID))
+}
+
+// GetChirpsMentioning returns a newest-first page of chirps mentioning userID
+// that viewerID may see.
+func (db *DB) GetChirpsMentioning(viewerID int, userID int, beforeID int, limit int) ([]Chirp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	if _, ok := db.data.Users[userID]; !ok {
+		return nil, ErrDoesNotExist
+	}
+	return db.pageFromIndex(db.data.MentionIndex[userID], beforeID, limit, db.hiddenFromListings(viewerID)), nil
+}
@@ -0,0 +1,249 @@
+package database
+
+import (
+	"maps"
+	"slices"
+	"time"
+)
+
+type ExportStatus string
+
+const (
+	ExportPending ExportStatus = "pending"
+	ExportReady   ExportStatus = "ready"
+	ExportFailed  ExportStatus = "failed"
+)
+
+// Export is a personal data archive. BlobKey, Size and ExpiresAt are set once
+// it's ready.
+type Export struct {
+	ID          int          `json:"id"`
+	UserID      int          `json:"user_id"`
+	Status      ExportStatus `json:"status"`
+	BlobKey     string       `json:"blob_key,omitempty"`
+	Size        int64        `json:"size,omitempty"`
+	Error       string       `json:"error,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	CompletedAt *time.Time   `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time   `json:"expires_at,omitempty"`
+}
+
+// UserData is everything stored about a user, for exporting.
+type UserData struct {
+	User          User
+	Chirps        []Chirp
+	Revisions     map[int][]ChirpRevision
+	PendingChirps []PendingChirp
+	Attachments   []Attachment
+	Following     map[int]time.Time
+	Followers     map[int]time.Time
+	// FollowRequests are pending requests to follow the user, and
+	// FollowRequested those the user has made
+	FollowRequests  map[int]time.Time
+	FollowRequested map[int]time.Time
+	Blocked         map[int]time.Time
+	Muted           map[int]Mute
+	Bookmarks       []int
+	Pins            []int
+	PollVotes       map[int]int
+	Sessions        []RefreshToken
+	Reports         []Report
+	Subscription    []SubscriptionChange
+	Webhooks        []WebhookEndpoint
+	Notifications   []Notification
+	Conversations   []Conversation
+	Messages        []Message
+}
+
+// CreateExport starts a new export for userID. If one is already being built
+// that one is returned instead, with ErrAlreadyExists.
+func (db *DB) CreateExport(userID int) (Export, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return Export{}, err
+	}
+	for _, e := range db.data.Exports {
+		if e.UserID == userID && e.Status == ExportPending {
+			return e, ErrAlreadyExists
+		}
+	}
+	export := Export{
+		ID:        db.data.LastExportID + 1,
+		UserID:    userID,
+		Status:    ExportPending,
+		CreatedAt: time.Now().UTC(),
+	}
+	db.data.Exports[export.ID] = export
+	db.data.LastExportID = export.ID
+	return export, db.writeDB()
+}
+
+func (db *DB) GetExport(exportID int) (Export, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	export, ok := db.data.Exports[exportID]
+	if !ok {
+		return Export{}, ErrDoesNotExist
+	}
+	return export, nil
+}
+
+// GetExports returns userID's exports, or every export with status if
+// userID is 0, oldest first.
+func (db *DB) GetExports(userID int, status ExportStatus) []Export {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	exports := make([]Export, 0)
+	for _, e := range db.data.Exports {
+		if (userID == 0 || e.UserID == userID) && (status == "" || e.Status == status) {
+			exports = append(exports, e)
+		}
+	}
+	sortSlice(exports, Asc, func(e Export) int { return e.ID })
+	return exports
+}
+
+// CompleteExport records that an export's archive has been stored.
+func (db *DB) CompleteExport(exportID int, blobKey string, size int64, expiresAt time.Time) (Export, error) {
+	return db.finishExport(exportID, func(e *Export) {
+		e.Status = ExportReady
+		e.BlobKey = blobKey
+		e.Size = size
+		expiresAt = expiresAt.UTC()
+		e.ExpiresAt = &expiresAt
+	})
+}
+
+func (db *DB) FailExport(exportID int, reason string) (Export, error) {
+	return db.finishExport(exportID, func(e *Export) {
+		e.Status = ExportFailed
+		e.Error = reason
+	})
+}
+
+func (db *DB) finishExport(exportID int, update func(*Export)) (Export, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return Export{}, err
+	}
+	export, ok := db.data.Exports[exportID]
+	if !ok {
+		return Export{}, ErrDoesNotExist
+	}
+	update(&export)
+	now := time.Now().UTC()
+	export.CompletedAt = &now
+	db.data.Exports[exportID] = export
+	return export, db.writeDB()
+}
+
+// GetExpiredExports returns the exports that can be deleted: ready ones past
+// their expiry and failed ones older than maxAge.
+func (db *DB) GetExpiredExports(now time.Time, maxAge time.Duration) []Export {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	var expired []Export
+	for _, e := range db.data.Exports {
+		switch {
+		case e.Status == ExportReady && !e.ExpiresAt.After(now):
+			expired = append(expired, e)
+		case e.Status == ExportFailed && now.Sub(e.CreatedAt) > maxAge:
+			expired = append(expired, e)
+		}
+	}
+	return expired
+}
+
+func (db *DB) DeleteExport(exportID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	delete(db.data.Exports, exportID)
+	return db.writeDB()
+}
+
+// GetUserData gathers everything stored about userID from a single,
+// consistent snapshot of the database. The maps and slices it returns are
+// copies, so they can be read after the lock is released.
+func (db *DB) GetUserData(userID int) (UserData, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	user, ok := db.data.Users[userID]
+	if !ok {
+		return UserData{}, ErrDoesNotExist
+	}
+	data := UserData{
+		User:            user.User,
+		Revisions:       make(map[int][]ChirpRevision),
+		Following:       maps.Clone(db.data.Follows[userID]),
+		Followers:       maps.Clone(db.data.FollowerIndex[userID]),
+		FollowRequests:  maps.Clone(db.data.FollowRequests[userID]),
+		FollowRequested: maps.Clone(db.data.FollowRequestIndex[userID]),
+		Blocked:         maps.Clone(db.data.Blocks[userID]),
+		Muted:           maps.Clone(db.data.Mutes[userID]),
+		Bookmarks:       slices.Clone(db.data.Bookmarks[userID]),
+		Pins:            slices.Clone(db.data.Pins[userID]),
+		PollVotes:       make(map[int]int),
+		Subscription:    slices.Clone(db.data.SubscriptionHistory[userID]),
+	}
+	for _, id := range db.data.AuthorChirpIndex[userID] {
+		data.Chirps = append(data.Chirps, db.data.Chirps[id])
+		if revs, ok := db.data.ChirpRevisions[id]; ok {
+			data.Revisions[id] = slices.Clone(revs)
+		}
+	}
+	for _, p := range db.data.PendingChirps {
+		if p.AuthorID == userID {
+			data.PendingChirps = append(data.PendingChirps, p)
+		}
+	}
+	sortSlice(data.PendingChirps, Asc, func(p PendingChirp) int { return p.ID })
+	for _, a := range db.data.Attachments {
+		if a.OwnerID == userID {
+			data.Attachments = append(data.Attachments, a)
+		}
+	}
+	sortSlice(data.Attachments, Asc, func(a Attachment) int { return a.ID })
+	for chirpID, votes := range db.data.PollVotes {
+		if option, ok := votes[userID]; ok {
+			data.PollVotes[chirpID] = option
+		}
+	}
+	for _, rt := range db.data.RefreshTokens {
+		if rt.UserID == userID {
+			data.Sessions = append(data.Sessions, rt)
+		}
+	}
+	sortSlice(data.Sessions, Asc, func(rt RefreshToken) int64 { return rt.Expiration.Unix() })
+	for _, r := range db.data.Reports {
+		if r.ReporterID == userID {
+			data.Reports = append(data.Reports, r)
+		}
+	}
+	sortSlice(data.Reports, Asc, func(r Report) int { return r.ID })
+	for _, e := range db.data.WebhookEndpoints {
+		if e.OwnerID == userID {
+			data.Webhooks = append(data.Webhooks, e)
+		}
+	}
+	sortSlice(data.Webhooks, Asc, func(e WebhookEndpoint) int { return e.ID })
+	for _, id := range db.data.NotificationIndex[userID] {
+		data.Notifications = append(data.Notifications, db.data.Notifications[id])
+	}
+	for _, id := range db.data.ConversationIndex[userID] {
+		data.Conversations = append(data.Conversations, db.data.Conversations[id])
+		for _, messageID := range db.data.ConversationMessageIndex[id] {
+			if m := db.data.Messages[messageID]; !db.messageHidden(userID, m) {
+				data.Messages = append(data.Messages, m)
+			}
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,311 @@
+package database
+
+import (
+	"container/heap"
+	"errors"
+	"sort"
+	"time"
+)
+
+var (
+	ErrSelfFollow = errors.New("users cannot follow themselves")
+)
+
+// FollowResult is what asking to follow a user did.
+type FollowResult int
+
+const (
+	AlreadyFollowing FollowResult = iota
+	Followed
+	// FollowRequested means the followee's account is protected, so they
+	// have been asked to approve the follow.
+	FollowRequested
+	AlreadyRequested
+)
+
+// FollowUser records that followerID follows followeeID, or, if followeeID's
+// account is protected, asks them to approve it. Suspended users, and users
+// waiting to be deleted, can't be followed, as if they didn't exist.
+func (db *DB) FollowUser(followerID int, followeeID int) (FollowResult, error) {
+	if followerID == followeeID {
+		return AlreadyFollowing, ErrSelfFollow
+	}
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return AlreadyFollowing, err
+	}
+	if _, ok := db.data.Users[followerID]; !ok {
+		return AlreadyFollowing, ErrDoesNotExist
+	}
+	followee, ok := db.data.Users[followeeID]
+	if !ok || followee.Deactivated() {
+		return AlreadyFollowing, ErrDoesNotExist
+	}
+	if db.blocked(followerID, followeeID) {
+		return AlreadyFollowing, ErrBlocked
+	}
+	if _, ok := db.data.Follows[followerID][followeeID]; ok {
+		return AlreadyFollowing, nil
+	}
+	now := time.Now().UTC()
+	if followee.Protected {
+		if _, ok := db.data.FollowRequests[followeeID][followerID]; ok {
+			return AlreadyRequested, nil
+		}
+		addToSet(db.data.FollowRequests, followeeID, followerID, now)
+		addToSet(db.data.FollowRequestIndex, followerID, followeeID, now)
+		return FollowRequested, db.writeDB()
+	}
+	db.follow(followerID, followeeID, now)
+	return Followed, db.writeDB()
+}
+
+func (db *DB) follow(followerID int, followeeID int, now time.Time) {
+	addToSet(db.data.Follows, followerID, followeeID, now)
+	addToSet(db.data.FollowerIndex, followeeID, followerID, now)
+	removeFromSet(db.data.FollowRequests, followeeID, followerID)
+	removeFromSet(db.data.FollowRequestIndex, followerID, followeeID)
+}
+
+// UnfollowUser removes the follow from followerID to followeeID, if any,
+// reporting whether there was one. A pending request to follow them is
+// withdrawn.
+func (db *DB) UnfollowUser(followerID int, followeeID int) (bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return false, err
+	}
+	if _, ok := db.data.FollowRequests[followeeID][followerID]; ok {
+		removeFromSet(db.data.FollowRequests, followeeID, followerID)
+		removeFromSet(db.data.FollowRequestIndex, followerID, followeeID)
+		return false, db.writeDB()
+	}
+	if _, ok := db.data.Follows[followerID][followeeID]; !ok {
+		return false, nil
+	}
+	removeFromSet(db.data.Follows, followerID, followeeID)
+	removeFromSet(db.data.FollowerIndex, followeeID, followerID)
+	return true, db.writeDB()
+}
+
+// GetFollowRequests returns the users waiting for userID to approve their
+// requests to follow them.
+func (db *DB) GetFollowRequests(userID int) []User {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	return db.usersFromSet(db.data.FollowRequests[userID])
+}
+
+// ApproveFollowRequest makes requesterID a follower of userID, if they asked
+// to be.
+func (db *DB) ApproveFollowRequest(userID int, requesterID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, ok := db.data.FollowRequests[userID][requesterID]; !ok {
+		return ErrDoesNotExist
+	}
+	db.follow(requesterID, userID, time.Now().UTC())
+	return db.writeDB()
+}
+
+// RejectFollowRequest turns down requesterID's request to follow userID.
+func (db *DB) RejectFollowRequest(userID int, requesterID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, ok := db.data.FollowRequests[userID][requesterID]; !ok {
+		return ErrDoesNotExist
+	}
+	removeFromSet(db.data.FollowRequests, userID, requesterID)
+	removeFromSet(db.data.FollowRequestIndex, requesterID, userID)
+	return db.writeDB()
+}
+
+// SetProtected protects or unprotects userID's account. Existing followers
+// are kept either way. Unprotecting approves every pending follow request;
+// the IDs of those requesters are returned.
+func (db *DB) SetProtected(userID int, protected bool) (User, []int, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return User{}, nil, err
+	}
+	user, ok := db.data.Users[userID]
+	if !ok {
+		return User{}, nil, ErrDoesNotExist
+	}
+	user.Protected = protected
+	db.data.Users[userID] = user
+	var approved []int
+	if !protected {
+		now := time.Now().UTC()
+		for requesterID := range db.data.FollowRequests[userID] {
+			db.follow(requesterID, userID, now)
+			approved = append(approved, requesterID)
+		}
+		sort.Ints(approved)
+	}
+	return user.User, approved, db.writeDB()
+}
+
+// InTimeline reports whether chirps by authorID appear in userID's timeline:
+// userID follows them and hasn't muted them.
+func (db *DB) InTimeline(userID int, authorID int) bool {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	_, ok := db.data.Follows[userID][authorID]
+	return ok && !db.muted(userID, authorID, time.Now())
+}
+
+func (db *DB) IsFollowing(followerID int, followeeID int) bool {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	_, ok := db.data.Follows[followerID][followeeID]
+	return ok
+}
+
+func (db *DB) GetFollowers(userID int) ([]User, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	if _, ok := db.data.Users[userID]; !ok {
+		return nil, ErrDoesNotExist
+	}
+	return db.usersFromSet(db.data.FollowerIndex[userID]), nil
+}
+
+func (db *DB) GetFollowing(userID int) ([]User, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	if _, ok := db.data.Users[userID]; !ok {
+		return nil, ErrDoesNotExist
+	}
+	return db.usersFromSet(db.data.Follows[userID]), nil
+}
+
+// GetTimeline returns up to limit chirps, newest first, written by the users
+// userID follows. Only chirps with an ID below beforeID are returned, unless
+// beforeID is 0.
+//
+// This is a fan-in over the per-author chirp index: each followed author's
+// chirp IDs are already sorted, so we k-way merge from the newest end and stop
+// as soon as the page is full, instead of scanning every chirp. Muted authors
+// are skipped.
+func (db *DB) GetTimeline(userID int, beforeID int, limit int) []Chirp {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	now := time.Now()
+	h := make(chirpCursorHeap, 0, len(db.data.Follows[userID]))
+	for followeeID := range db.data.Follows[userID] {
+		if db.muted(userID, followeeID, now) {
+			continue
+		}
+		ids := db.data.AuthorChirpIndex[followeeID]
+		pos := len(ids)
+		if beforeID > 0 {
+			pos = sort.SearchInts(ids, beforeID)
+		}
+		if pos > 0 {
+			h = append(h, chirpCursor{ids: ids, pos: pos - 1})
+		}
+	}
+	heap.Init(&h)
+	hidden := db.hiddenFrom(userID)
+	chirps := make([]Chirp, 0, limit)
+	for len(chirps) < limit && h.Len() > 0 {
+		cur := &h[0]
+		if chirp := db.data.Chirps[cur.ids[cur.pos]]; !hidden(chirp) {
+			chirps = append(chirps, chirp)
+		}
+		if cur.pos == 0 {
+			heap.Pop(&h)
+			continue
+		}
+		cur.pos--
+		heap.Fix(&h, 0)
+	}
+	return chirps
+}
+
+func (db *DB) usersFromSet(set map[int]time.Time) []User {
+	users := make([]User, 0, len(set))
+	for id := range set {
+		if db.listed(id) {
+			users = append(users, db.data.Users[id].User)
+		}
+	}
+	sortSlice(users, Asc, func(u User) int { return u.ID })
+	return users
+}
+
+// countUsersInSet counts the users usersFromSet would return.
+func (db *DB) countUsersInSet(set map[int]time.Time) int {
+	n := 0
+	for id := range set {
+		if db.listed(id) {
+			n++
+		}
+	}
+	return n
+}
+
+// listed reports whether userID exists and isn't deactivated, so may be
+// listed as someone's follower or followee.
+func (db *DB) listed(userID int) bool {
+	u, ok := db.data.Users[userID]
+	return ok && !u.Deactivated()
+}
+
+func addToSet(sets map[int]map[int]time.Time, key int, member int, at time.Time) {
+	set, ok := sets[key]
+	if !ok {
+		set = make(map[int]time.Time)
+		sets[key] = set
+	}
+	set[member] = at
+}
+
+func removeFromSet(sets map[int]map[int]time.Time, key int, member int) {
+	delete(sets[key], member)
+	if len(sets[key]) == 0 {
+		delete(sets, key)
+	}
+}
+
+// chirpCursor points at the next (newest unread) chirp ID in one author's
+// index.
+type chirpCursor struct {
+	ids []int
+	pos int
+}
+
+// chirpCursorHeap is a max-heap of cursors ordered by the chirp ID they
+// currently point at.
+type chirpCursorHeap []chirpCursor
+
+func (h chirpCursorHeap) Len() int { return len(h) }
+func (h chirpCursorHeap) Less(i, j int) bool {
+	return h[i].ids[h[i].pos] > h[j].ids[h[j].pos]
+}
+func (h chirpCursorHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *chirpCursorHeap) Push(x any) { *h = append(*h, x.(chirpCursor)) }
+
+func (h *chirpCursorHeap) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
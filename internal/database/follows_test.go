@@ -0,0 +1,140 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetTimelineMergesFolloweesNewestFirst(t *testing.T) {
+	db := newTestDB(t)
+	reader := mustCreateUser(t, db, "reader")
+	ann := mustCreateUser(t, db, "ann")
+	bob := mustCreateUser(t, db, "bob")
+	cat := mustCreateUser(t, db, "cat")
+	muted := mustCreateUser(t, db, "muted")
+	for _, u := range []User{ann, bob, muted} {
+		mustFollow(t, db, reader.ID, u.ID, Followed)
+	}
+	if err := db.MuteUser(reader.ID, muted.ID, nil); err != nil {
+		t.Fatalf("MuteUser: %s", err)
+	}
+
+	// Authors post unevenly and interleaved, so the merge has to take from
+	// each of them in turn rather than one author at a time
+	var want []Chirp
+	for _, author := range []User{ann, ann, bob, cat, ann, muted, bob, bob, cat, ann, bob} {
+		chirp := mustPost(t, db, author)
+		if author.ID == ann.ID || author.ID == bob.ID {
+			want = append([]Chirp{chirp}, want...)
+		}
+	}
+
+	assertIDs(t, "GetTimeline", db.GetTimeline(reader.ID, 0, 100), want...)
+	assertIDs(t, "GetTimeline with a limit", db.GetTimeline(reader.ID, 0, 3), want[:3]...)
+	assertIDs(t, "GetTimeline of a user following no one", db.GetTimeline(cat.ID, 0, 100))
+}
+
+func TestGetTimelinePages(t *testing.T) {
+	db := newTestDB(t)
+	reader := mustCreateUser(t, db, "reader")
+	ann := mustCreateUser(t, db, "ann")
+	bob := mustCreateUser(t, db, "bob")
+	mustFollow(t, db, reader.ID, ann.ID, Followed)
+	mustFollow(t, db, reader.ID, bob.ID, Followed)
+	var want []Chirp
+	for i := 0; i < 7; i++ {
+		for _, author := range []User{ann, bob} {
+			want = append([]Chirp{mustPost(t, db, author)}, want...)
+		}
+	}
+
+	var got []Chirp
+	beforeID := 0
+	for pages := 0; ; pages++ {
+		if pages > len(want) {
+			t.Fatal("GetTimeline kept returning pages")
+		}
+		page := db.GetTimeline(reader.ID, beforeID, 4)
+		if len(page) == 0 {
+			break
+		}
+		if len(page) > 4 {
+			t.Fatalf("GetTimeline returned %d chirps, want at most 4", len(page))
+		}
+		got = append(got, page...)
+		beforeID = page[len(page)-1].ID
+	}
+	assertIDs(t, "paged GetTimeline", got, want...)
+
+	// A cursor that isn't one of the timeline's chirps still pages from it
+	assertIDs(t, "GetTimeline before another chirp", db.GetTimeline(reader.ID, want[2].ID+1, 2), want[2:4]...)
+}
+
+func TestGetUserStatsMatchesFollowLists(t *testing.T) {
+	db := newTestDB(t)
+	user := mustCreateUser(t, db, "user")
+	active := mustCreateUser(t, db, "active")
+	suspended := mustCreateUser(t, db, "suspended")
+	leaving := mustCreateUser(t, db, "leaving")
+	for _, u := range []User{active, suspended, leaving} {
+		mustFollow(t, db, u.ID, user.ID, Followed)
+		mustFollow(t, db, user.ID, u.ID, Followed)
+	}
+	if _, err := db.SuspendUser(suspended.ID, user.ID, "spam"); err != nil {
+		t.Fatalf("SuspendUser: %s", err)
+	}
+	if _, err := db.ScheduleUserDeletion(leaving.ID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("ScheduleUserDeletion: %s", err)
+	}
+
+	followers, err := db.GetFollowers(user.ID)
+	if err != nil {
+		t.Fatalf("GetFollowers: %s", err)
+	}
+	following, err := db.GetFollowing(user.ID)
+	if err != nil {
+		t.Fatalf("GetFollowing: %s", err)
+	}
+	stats := db.GetUserStats(user.ID)
+	if stats.Followers != len(followers) || stats.Following != len(following) {
+		t.Errorf("GetUserStats counted %d followers and %d following, but the lists have %d and %d",
+			stats.Followers, stats.Following, len(followers), len(following))
+	}
+	if len(followers) != 1 || followers[0].ID != active.ID {
+		t.Errorf("GetFollowers = %v, want only %s", followers, active.Handle)
+	}
+}
+
+// mustPost creates a public chirp by author.
+func mustPost(t *testing.T, db *DB, author User) Chirp {
+	t.Helper()
+	chirp, err := db.CreateChirp(Chirp{AuthorID: author.ID, Body: "hello", CreatedAt: time.Now().UTC()})
+	if err != nil {
+		t.Fatalf("CreateChirp: %s", err)
+	}
+	return chirp
+}
+
+func TestFollowUserRefusesDeactivatedUsers(t *testing.T) {
+	db := newTestDB(t)
+	follower := mustCreateUser(t, db, "follower")
+	suspended := mustCreateUser(t, db, "suspended")
+	leaving := mustCreateUser(t, db, "leaving")
+	if _, err := db.SuspendUser(suspended.ID, follower.ID, "spam"); err != nil {
+		t.Fatalf("SuspendUser: %s", err)
+	}
+	if _, err := db.ScheduleUserDeletion(leaving.ID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("ScheduleUserDeletion: %s", err)
+	}
+	for _, u := range []User{suspended, leaving} {
+		if _, err := db.FollowUser(follower.ID, u.ID); err != ErrDoesNotExist {
+			t.Errorf("following %s: got error %v, want ErrDoesNotExist", u.Handle, err)
+		}
+		if db.IsFollowing(follower.ID, u.ID) {
+			t.Errorf("following %s was recorded", u.Handle)
+		}
+	}
+	if stats := db.GetUserStats(follower.ID); stats.Following != 0 {
+		t.Errorf("GetUserStats counted %d followees, want 0", stats.Following)
+	}
+}
@@ -0,0 +1,58 @@
+package database
+
+import "sort"
+
+// Indexes of chirp IDs are kept sorted ascending, which lets listings walk
+// them backwards for newest-first pages and binary search for cursors.
+
+// insertSorted adds id to ids if it's not already there. New chirps have the
+// highest ID so this is usually an append, but edited chirps are re-indexed in
+// place.
+func insertSorted(ids []int, id int) []int {
+	i := sort.SearchInts(ids, id)
+	if i < len(ids) && ids[i] == id {
+		return ids
+	}
+	if i == len(ids) {
+		return append(ids, id)
+	}
+	ids = append(ids, 0)
+	copy(ids[i+1:], ids[i:])
+	ids[i] = id
+	return ids
+}
+
+func removeSortedInt(ids []int, id int) []int {
+	i := sort.SearchInts(ids, id)
+	if i < len(ids) && ids[i] == id {
+		return append(ids[:i], ids[i+1:]...)
+	}
+	return ids
+}
+
+func removeFromIndex[K comparable](index map[K][]int, key K, id int) {
+	ids := removeSortedInt(index[key], id)
+	if len(ids) == 0 {
+		delete(index, key)
+		return
+	}
+	index[key] = ids
+}
+
+// pageFromIndex returns up to limit chirps, newest first, from a sorted index
+// of chirp IDs, leaving out those hidden reports. Only chirps with an ID below
+// beforeID are returned, unless beforeID is 0.
+func (db *DB) pageFromIndex(ids []int, beforeID int, limit int, hidden func(Chirp) bool) []Chirp {
+	end := len(ids)
+	if beforeID > 0 {
+		end = sort.SearchInts(ids, beforeID)
+	}
+	chirps := make([]Chirp, 0, min(limit, end))
+	for i := end - 1; i >= 0 && len(chirps) < limit; i-- {
+		chirp := db.data.Chirps[ids[i]]
+		if !hidden(chirp) {
+			chirps = append(chirps, chirp)
+		}
+	}
+	return chirps
+}
@@ -0,0 +1,348 @@
+package database
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"sort"
+	"time"
+)
+
+// ErrCannotMessage is returned when a recipient doesn't accept messages from
+// the sender, because one has blocked the other or the recipient only accepts
+// messages from people they follow.
+var ErrCannotMessage = errors.New("recipient does not accept messages from sender")
+
+// Conversation is a private exchange of messages between two users, or a
+// small group. Seq orders each participant's conversations by activity,
+// newest last, and is bumped by every message.
+type Conversation struct {
+	ID int `json:"id"`
+	// ParticipantIDs are sorted ascending and include the creator
+	ParticipantIDs []int `json:"participant_ids"`
+	CreatorID      int   `json:"creator_id"`
+	Group          bool  `json:"group"`
+	// ReadUpTo is the ID of the latest message each participant has read
+	ReadUpTo      map[int]int `json:"read_up_to"`
+	LastMessageID int         `json:"last_message_id"`
+	Seq           int         `json:"seq"`
+	CreatedAt     time.Time   `json:"created_at"`
+	UpdatedAt     time.Time   `json:"updated_at"`
+}
+
+type Message struct {
+	ID             int       `json:"id"`
+	ConversationID int       `json:"conversation_id"`
+	SenderID       int       `json:"sender_id"`
+	Body           string    `json:"body"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// ConversationSummary is a conversation as one of its participants sees it.
+type ConversationSummary struct {
+	Conversation
+	LastMessage *Message
+	Unread      int
+}
+
+// canMessage checks that recipientID accepts messages from senderID.
+func (db *DB) canMessage(senderID int, recipientID int) error {
+	recipient, ok := db.data.Users[recipientID]
+	if !ok || recipient.Deactivated() {
+		return ErrDoesNotExist
+	}
+	if db.blocked(senderID, recipientID) {
+		return ErrCannotMessage
+	}
+	if _, follows := db.data.Follows[recipientID][senderID]; recipient.DMsFromFollowingOnly && !follows {
+		return ErrCannotMessage
+	}
+	return nil
+}
+
+// CreateConversation sends body from creatorID to the other participants,
+// starting a conversation with them. Messages to a single user go to the
+// conversation the two already have, if there is one; messages to several
+// users always start a new group. Every participant must accept messages from
+// the creator.
+func (db *DB) CreateConversation(creatorID int, participantIDs []int, body string) (Conversation, Message, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return Conversation{}, Message{}, err
+	}
+	participants := []int{creatorID}
+	for _, id := range participantIDs {
+		if id == creatorID {
+			continue
+		}
+		err = db.canMessage(creatorID, id)
+		if err != nil {
+			return Conversation{}, Message{}, err
+		}
+		participants = insertSorted(participants, id)
+	}
+	if len(participants) < 2 {
+		return Conversation{}, Message{}, ErrDoesNotExist
+	}
+	now := time.Now().UTC()
+	group := len(participants) > 2
+	if !group {
+		for _, id := range db.data.ConversationIndex[creatorID] {
+			c := db.data.Conversations[id]
+			if !c.Group && slices.Equal(c.ParticipantIDs, participants) {
+				m := db.addMessage(c, creatorID, body, now)
+				return db.data.Conversations[c.ID], m, db.writeDB()
+			}
+		}
+	}
+	db.data.LastConversationID++
+	c := Conversation{
+		ID:             db.data.LastConversationID,
+		ParticipantIDs: participants,
+		CreatorID:      creatorID,
+		Group:          group,
+		ReadUpTo:       make(map[int]int),
+		CreatedAt:      now,
+	}
+	for _, id := range participants {
+		db.data.ConversationIndex[id] = insertSorted(db.data.ConversationIndex[id], c.ID)
+	}
+	m := db.addMessage(c, creatorID, body, now)
+	return db.data.Conversations[c.ID], m, db.writeDB()
+}
+
+// SendMessage adds a message from senderID to a conversation they're in. In
+// conversations between two users the recipient must still accept messages
+// from the sender; in groups, messages between users who have blocked one
+// another are hidden from them instead.
+func (db *DB) SendMessage(conversationID int, senderID int, body string) (Message, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return Message{}, err
+	}
+	c, ok := db.data.Conversations[conversationID]
+	if !ok || !slices.Contains(c.ParticipantIDs, senderID) {
+		return Message{}, ErrDoesNotExist
+	}
+	if !c.Group {
+		for _, id := range c.ParticipantIDs {
+			if id == senderID {
+				continue
+			}
+			err = db.canMessage(senderID, id)
+			if err != nil {
+				return Message{}, err
+			}
+		}
+	}
+	m := db.addMessage(c, senderID, body, time.Now().UTC())
+	return m, db.writeDB()
+}
+
+// addMessage stores a message in c, which the sender has read by sending it.
+func (db *DB) addMessage(c Conversation, senderID int, body string, now time.Time) Message {
+	db.data.LastMessageID++
+	m := Message{
+		ID:             db.data.LastMessageID,
+		ConversationID: c.ID,
+		SenderID:       senderID,
+		Body:           body,
+		CreatedAt:      now,
+	}
+	db.data.Messages[m.ID] = m
+	db.data.ConversationMessageIndex[c.ID] = insertSorted(db.data.ConversationMessageIndex[c.ID], m.ID)
+	db.data.LastConversationSeq++
+	c.Seq = db.data.LastConversationSeq
+	c.LastMessageID = m.ID
+	c.UpdatedAt = now
+	c.ReadUpTo = maps.Clone(c.ReadUpTo)
+	c.ReadUpTo[senderID] = m.ID
+	db.data.Conversations[c.ID] = c
+	return m
+}
+
+// messageHidden reports whether m is hidden from userID because one of them
+// has blocked the other.
+func (db *DB) messageHidden(userID int, m Message) bool {
+	return m.SenderID != userID && db.blocked(userID, m.SenderID)
+}
+
+// summarize returns c as userID sees it.
+func (db *DB) summarize(c Conversation, userID int) ConversationSummary {
+	s := ConversationSummary{Conversation: c}
+	ids := db.data.ConversationMessageIndex[c.ID]
+	for i := len(ids) - 1; i >= 0; i-- {
+		m := db.data.Messages[ids[i]]
+		if db.messageHidden(userID, m) {
+			continue
+		}
+		if s.LastMessage == nil {
+			s.LastMessage = &m
+		}
+		if m.ID <= c.ReadUpTo[userID] {
+			break
+		}
+		if m.SenderID != userID {
+			s.Unread++
+		}
+	}
+	return s
+}
+
+// GetConversations returns up to limit of userID's conversations, most
+// recently active first, starting before the given Seq if it's set, along with
+// how many unread messages they have in all.
+func (db *DB) GetConversations(userID int, beforeSeq int, limit int) ([]ConversationSummary, int) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	var summaries []ConversationSummary
+	unread := 0
+	for _, id := range db.data.ConversationIndex[userID] {
+		s := db.summarize(db.data.Conversations[id], userID)
+		if s.LastMessage == nil {
+			continue
+		}
+		unread += s.Unread
+		if beforeSeq == 0 || s.Seq < beforeSeq {
+			summaries = append(summaries, s)
+		}
+	}
+	sortSlice(summaries, Desc, func(s ConversationSummary) int { return s.Seq })
+	if len(summaries) > limit {
+		summaries = summaries[:limit]
+	}
+	return summaries, unread
+}
+
+// GetConversation returns a conversation userID is in.
+func (db *DB) GetConversation(conversationID int, userID int) (ConversationSummary, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	c, ok := db.data.Conversations[conversationID]
+	if !ok || !slices.Contains(c.ParticipantIDs, userID) {
+		return ConversationSummary{}, ErrDoesNotExist
+	}
+	return db.summarize(c, userID), nil
+}
+
+// GetMessages returns up to limit messages from a conversation userID is in,
+// newest first, with IDs below beforeID if it's set.
+func (db *DB) GetMessages(conversationID int, userID int, beforeID int, limit int) ([]Message, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	c, ok := db.data.Conversations[conversationID]
+	if !ok || !slices.Contains(c.ParticipantIDs, userID) {
+		return nil, ErrDoesNotExist
+	}
+	ids := db.data.ConversationMessageIndex[conversationID]
+	end := len(ids)
+	if beforeID > 0 {
+		end = sort.SearchInts(ids, beforeID)
+	}
+	messages := make([]Message, 0, min(limit, end))
+	for i := end - 1; i >= 0 && len(messages) < limit; i-- {
+		m := db.data.Messages[ids[i]]
+		if !db.messageHidden(userID, m) {
+			messages = append(messages, m)
+		}
+	}
+	return messages, nil
+}
+
+// DeleteMessage deletes a message for everyone in its conversation. Only its
+// sender can delete it.
+func (db *DB) DeleteMessage(conversationID int, messageID int, userID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	m, ok := db.data.Messages[messageID]
+	if !ok || m.ConversationID != conversationID || m.SenderID != userID {
+		return ErrDoesNotExist
+	}
+	db.removeMessage(m)
+	return db.writeDB()
+}
+
+func (db *DB) removeMessage(m Message) {
+	delete(db.data.Messages, m.ID)
+	removeFromIndex(db.data.ConversationMessageIndex, m.ConversationID, m.ID)
+	c := db.data.Conversations[m.ConversationID]
+	if c.LastMessageID == m.ID {
+		ids := db.data.ConversationMessageIndex[c.ID]
+		c.LastMessageID = 0
+		if len(ids) > 0 {
+			c.LastMessageID = ids[len(ids)-1]
+		}
+		db.data.Conversations[c.ID] = c
+	}
+}
+
+// MarkConversationRead records that userID has read a conversation up to and
+// including messageID, or all of it if messageID is 0, returning how many
+// messages in it are still unread. Read positions never move backwards.
+func (db *DB) MarkConversationRead(conversationID int, userID int, messageID int) (int, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return 0, err
+	}
+	c, ok := db.data.Conversations[conversationID]
+	if !ok || !slices.Contains(c.ParticipantIDs, userID) {
+		return 0, ErrDoesNotExist
+	}
+	if messageID == 0 {
+		messageID = c.LastMessageID
+	} else if m, ok := db.data.Messages[messageID]; !ok || m.ConversationID != conversationID {
+		return 0, ErrDoesNotExist
+	}
+	if messageID > c.ReadUpTo[userID] {
+		c.ReadUpTo = maps.Clone(c.ReadUpTo)
+		c.ReadUpTo[userID] = messageID
+		db.data.Conversations[c.ID] = c
+		err = db.writeDB()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return db.summarize(c, userID).Unread, nil
+}
+
+// leaveConversation takes userID and their messages out of a conversation.
+// Conversations between two users, and groups left with fewer than two
+// participants, are deleted.
+func (db *DB) leaveConversation(c Conversation, userID int) {
+	for _, id := range slices.Clone(db.data.ConversationMessageIndex[c.ID]) {
+		if m := db.data.Messages[id]; m.SenderID == userID {
+			db.removeMessage(m)
+		}
+	}
+	c = db.data.Conversations[c.ID]
+	c.ParticipantIDs = removeSortedInt(slices.Clone(c.ParticipantIDs), userID)
+	c.ReadUpTo = maps.Clone(c.ReadUpTo)
+	delete(c.ReadUpTo, userID)
+	removeFromIndex(db.data.ConversationIndex, userID, c.ID)
+	if !c.Group || len(c.ParticipantIDs) < 2 {
+		db.removeConversation(c)
+		return
+	}
+	db.data.Conversations[c.ID] = c
+}
+
+func (db *DB) removeConversation(c Conversation) {
+	for _, id := range db.data.ConversationMessageIndex[c.ID] {
+		delete(db.data.Messages, id)
+	}
+	delete(db.data.ConversationMessageIndex, c.ID)
+	for _, id := range c.ParticipantIDs {
+		removeFromIndex(db.data.ConversationIndex, id, c.ID)
+	}
+	delete(db.data.Conversations, c.ID)
+}
@@ -0,0 +1,16 @@
+package database
+
+// GetFlaggedChirps returns the chirps the moderation pipeline flagged for
+// review, oldest first. Flagged chirps are published like any other.
+func (db *DB) GetFlaggedChirps() []Chirp {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	chirps := make([]Chirp, 0)
+	for _, chirp := range db.data.Chirps {
+		if len(chirp.Flags) > 0 {
+			chirps = append(chirps, chirp)
+		}
+	}
+	sortSlice(chirps, Asc, func(c Chirp) int { return c.ID })
+	return chirps
+}
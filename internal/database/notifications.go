@@ -0,0 +1,240 @@
+package database
+
+import (
+	"slices"
+	"time"
+)
+
+type NotificationType string
+
+const (
+	NotificationMention NotificationType = "mention"
+	NotificationFollow  NotificationType = "follow"
+	// NotificationFollowRequest is for users asking to follow a protected
+	// account.
+	NotificationFollowRequest NotificationType = "follow_request"
+)
+
+// grouped reports whether notifications of type t collect several actors.
+// While a grouped notification is unread, others of the same type and chirp
+// are added to it instead of creating new notifications.
+func (t NotificationType) grouped() bool {
+	return t == NotificationFollow || t == NotificationFollowRequest
+}
+
+// Notification tells a user that others did something involving them. Seq
+// orders a recipient's notifications, newest last, and is bumped when a group
+// gains an actor so that it moves back to the top.
+type Notification struct {
+	ID          int              `json:"id"`
+	RecipientID int              `json:"recipient_id"`
+	Type        NotificationType `json:"type"`
+	ChirpID     int              `json:"chirp_id,omitempty"`
+	// ActorIDs are the users who caused the notification, most recent first
+	ActorIDs  []int      `json:"actor_ids"`
+	Seq       int        `json:"seq"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+}
+
+// Notify records that actorID did something of type t involving recipientID,
+// optionally about a chirp, returning the new notification or the group it
+// was added to. It reports false if no notification was needed: users aren't
+// notified of their own actions, of actions by users they have blocked, been
+// blocked by or muted, about chirps they can't see, or twice of a mention in
+// the same chirp.
+func (db *DB) Notify(recipientID int, t NotificationType, actorID int, chirpID int) (Notification, bool, error) {
+	if recipientID == actorID {
+		return Notification{}, false, nil
+	}
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return Notification{}, false, err
+	}
+	if _, ok := db.data.Users[recipientID]; !ok {
+		return Notification{}, false, ErrDoesNotExist
+	}
+	if _, ok := db.data.Users[actorID]; !ok {
+		return Notification{}, false, ErrDoesNotExist
+	}
+	now := time.Now().UTC()
+	if db.blocked(recipientID, actorID) || db.muted(recipientID, actorID, now) {
+		return Notification{}, false, nil
+	}
+	if chirp, ok := db.data.Chirps[chirpID]; ok && db.hiddenFrom(recipientID)(chirp) {
+		return Notification{}, false, nil
+	}
+	for _, id := range db.data.NotificationIndex[recipientID] {
+		n := db.data.Notifications[id]
+		if n.Type != t || n.ChirpID != chirpID {
+			continue
+		}
+		if !t.grouped() {
+			if slices.Contains(n.ActorIDs, actorID) {
+				return Notification{}, false, nil
+			}
+			continue
+		}
+		if n.ReadAt != nil {
+			continue
+		}
+		if slices.Contains(n.ActorIDs, actorID) {
+			return Notification{}, false, nil
+		}
+		n.ActorIDs = append([]int{actorID}, n.ActorIDs...)
+		db.data.LastNotificationSeq++
+		n.Seq = db.data.LastNotificationSeq
+		n.UpdatedAt = now
+		db.data.Notifications[id] = n
+		return n, true, db.writeDB()
+	}
+	db.data.LastNotificationID++
+	db.data.LastNotificationSeq++
+	n := Notification{
+		ID:          db.data.LastNotificationID,
+		RecipientID: recipientID,
+		Type:        t,
+		ChirpID:     chirpID,
+		ActorIDs:    []int{actorID},
+		Seq:         db.data.LastNotificationSeq,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	db.data.Notifications[n.ID] = n
+	db.data.NotificationIndex[recipientID] = insertSorted(db.data.NotificationIndex[recipientID], n.ID)
+	return n, true, db.writeDB()
+}
+
+// WithdrawNotification takes actorID out of recipientID's unread
+// notifications of type t, such as when they unfollow before the recipient
+// has seen that they followed.
+func (db *DB) WithdrawNotification(recipientID int, t NotificationType, actorID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	changed := false
+	for _, id := range slices.Clone(db.data.NotificationIndex[recipientID]) {
+		n := db.data.Notifications[id]
+		if n.Type != t || n.ReadAt != nil || !slices.Contains(n.ActorIDs, actorID) {
+			continue
+		}
+		db.removeNotificationActor(n, actorID)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return db.writeDB()
+}
+
+// GetNotifications returns up to limit of recipientID's notifications, most
+// recently updated first, starting before the given Seq if it's set, along
+// with how many unread notifications they have in all.
+//
+// Actors the recipient has since blocked, been blocked by or muted, and
+// deactivated accounts, are left out, as are users whose requests to follow
+// the recipient have since been approved, rejected or withdrawn.
+// Notifications with no actors left, or about chirps the recipient can't see,
+// aren't returned or counted.
+func (db *DB) GetNotifications(recipientID int, beforeSeq int, limit int, unreadOnly bool) ([]Notification, int) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	now := time.Now()
+	hidden := db.hiddenFrom(recipientID)
+	var visible []Notification
+	unread := 0
+	for _, id := range db.data.NotificationIndex[recipientID] {
+		n := db.data.Notifications[id]
+		if n.ChirpID != 0 {
+			chirp, ok := db.data.Chirps[n.ChirpID]
+			if !ok || hidden(chirp) {
+				continue
+			}
+		}
+		var actors []int
+		for _, actorID := range n.ActorIDs {
+			actor, ok := db.data.Users[actorID]
+			if !ok || actor.Deactivated() || db.blocked(recipientID, actorID) || db.muted(recipientID, actorID, now) {
+				continue
+			}
+			if _, pending := db.data.FollowRequests[recipientID][actorID]; n.Type == NotificationFollowRequest && !pending {
+				continue
+			}
+			actors = append(actors, actorID)
+		}
+		if len(actors) == 0 {
+			continue
+		}
+		n.ActorIDs = actors
+		if n.ReadAt == nil {
+			unread++
+		} else if unreadOnly {
+			continue
+		}
+		if beforeSeq == 0 || n.Seq < beforeSeq {
+			visible = append(visible, n)
+		}
+	}
+	sortSlice(visible, Desc, func(n Notification) int { return n.Seq })
+	if len(visible) > limit {
+		visible = visible[:limit]
+	}
+	return visible, unread
+}
+
+// MarkNotificationsRead marks recipientID's notifications with the given IDs
+// as read, or all of them if ids is nil, returning how many were unread. It
+// returns ErrDoesNotExist if any of ids isn't one of the recipient's.
+func (db *DB) MarkNotificationsRead(recipientID int, ids []int) (int, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return 0, err
+	}
+	if ids == nil {
+		ids = db.data.NotificationIndex[recipientID]
+	}
+	for _, id := range ids {
+		if n, ok := db.data.Notifications[id]; !ok || n.RecipientID != recipientID {
+			return 0, ErrDoesNotExist
+		}
+	}
+	now := time.Now().UTC()
+	marked := 0
+	for _, id := range ids {
+		n := db.data.Notifications[id]
+		if n.ReadAt != nil {
+			continue
+		}
+		n.ReadAt = &now
+		db.data.Notifications[id] = n
+		marked++
+	}
+	if marked == 0 {
+		return 0, nil
+	}
+	return marked, db.writeDB()
+}
+
+// removeNotificationActor takes actorID out of n, deleting n if nobody is
+// left in it.
+func (db *DB) removeNotificationActor(n Notification, actorID int) {
+	n.ActorIDs = slices.DeleteFunc(slices.Clone(n.ActorIDs), func(id int) bool { return id == actorID })
+	if len(n.ActorIDs) == 0 {
+		db.removeNotification(n)
+		return
+	}
+	db.data.Notifications[n.ID] = n
+}
+
+func (db *DB) removeNotification(n Notification) {
+	delete(db.data.Notifications, n.ID)
+	removeFromIndex(db.data.NotificationIndex, n.RecipientID, n.ID)
+}
@@ -0,0 +1,196 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+// mustNotify records a notification and fails unless one was needed.
+func mustNotify(t *testing.T, db *DB, recipientID int, nt NotificationType, actorID int, chirpID int) Notification {
+	t.Helper()
+	n, ok, err := db.Notify(recipientID, nt, actorID, chirpID)
+	if err != nil || !ok {
+		t.Fatalf("Notify(%d, %s, %d, %d): ok %v, err %v", recipientID, nt, actorID, chirpID, ok, err)
+	}
+	return n
+}
+
+func assertNotNotified(t *testing.T, db *DB, recipientID int, nt NotificationType, actorID int, chirpID int) {
+	t.Helper()
+	if _, ok, err := db.Notify(recipientID, nt, actorID, chirpID); err != nil || ok {
+		t.Errorf("Notify(%d, %s, %d, %d): ok %v, err %v, want no notification", recipientID, nt, actorID, chirpID, ok, err)
+	}
+}
+
+func TestNotifyGroupsUnreadFollows(t *testing.T) {
+	db := newTestDB(t)
+	user := mustCreateUser(t, db, "user")
+	ann := mustCreateUser(t, db, "ann")
+	bob := mustCreateUser(t, db, "bob")
+	cat := mustCreateUser(t, db, "cat")
+
+	first := mustNotify(t, db, user.ID, NotificationFollow, ann.ID, 0)
+	mention := mustNotify(t, db, user.ID, NotificationMention, cat.ID, mustPost(t, db, cat).ID)
+	grouped := mustNotify(t, db, user.ID, NotificationFollow, bob.ID, 0)
+	if grouped.ID != first.ID {
+		t.Fatalf("second follow created notification %d, want it added to %d", grouped.ID, first.ID)
+	}
+	if !reflect.DeepEqual(grouped.ActorIDs, []int{bob.ID, ann.ID}) {
+		t.Errorf("grouped actors = %v, want %v", grouped.ActorIDs, []int{bob.ID, ann.ID})
+	}
+	if grouped.Seq <= mention.Seq {
+		t.Errorf("grouped notification has seq %d, want it after the mention's %d", grouped.Seq, mention.Seq)
+	}
+	assertNotNotified(t, db, user.ID, NotificationFollow, ann.ID, 0)
+
+	// Once read, a group is left alone and new follows start another
+	if _, err := db.MarkNotificationsRead(user.ID, []int{first.ID}); err != nil {
+		t.Fatalf("MarkNotificationsRead: %s", err)
+	}
+	next := mustNotify(t, db, user.ID, NotificationFollow, cat.ID, 0)
+	if next.ID == first.ID || !reflect.DeepEqual(next.ActorIDs, []int{cat.ID}) {
+		t.Errorf("follow after reading = %+v, want a new notification for cat alone", next)
+	}
+	// Follow requests are grouped apart from follows
+	if request := mustNotify(t, db, user.ID, NotificationFollowRequest, ann.ID, 0); request.ID == next.ID {
+		t.Error("a follow request joined a follow notification")
+	}
+}
+
+func TestNotifyMentions(t *testing.T) {
+	db := newTestDB(t)
+	user := mustCreateUser(t, db, "user")
+	author := mustCreateUser(t, db, "author")
+	blocked := mustCreateUser(t, db, "blocked")
+	if err := db.BlockUser(user.ID, blocked.ID); err != nil {
+		t.Fatalf("BlockUser: %s", err)
+	}
+	chirp := mustPost(t, db, author)
+	other := mustPost(t, db, author)
+
+	n := mustNotify(t, db, user.ID, NotificationMention, author.ID, chirp.ID)
+	// An edit that keeps the mention doesn't notify again
+	assertNotNotified(t, db, user.ID, NotificationMention, author.ID, chirp.ID)
+	if m := mustNotify(t, db, user.ID, NotificationMention, author.ID, other.ID); m.ID == n.ID {
+		t.Error("a mention in another chirp was grouped with the first")
+	}
+	assertNotNotified(t, db, author.ID, NotificationMention, author.ID, chirp.ID)
+	assertNotNotified(t, db, user.ID, NotificationMention, blocked.ID, mustPost(t, db, blocked).ID)
+
+	if _, _, err := db.Notify(user.ID, NotificationMention, 999, chirp.ID); err != ErrDoesNotExist {
+		t.Errorf("Notify by an unknown actor: got error %v, want ErrDoesNotExist", err)
+	}
+}
+
+func TestWithdrawNotification(t *testing.T) {
+	db := newTestDB(t)
+	user := mustCreateUser(t, db, "user")
+	ann := mustCreateUser(t, db, "ann")
+	bob := mustCreateUser(t, db, "bob")
+	mustNotify(t, db, user.ID, NotificationFollow, ann.ID, 0)
+	n := mustNotify(t, db, user.ID, NotificationFollow, bob.ID, 0)
+
+	if err := db.WithdrawNotification(user.ID, NotificationFollow, bob.ID); err != nil {
+		t.Fatalf("WithdrawNotification: %s", err)
+	}
+	got, _ := db.GetNotifications(user.ID, 0, 10, false)
+	if len(got) != 1 || got[0].ID != n.ID || !reflect.DeepEqual(got[0].ActorIDs, []int{ann.ID}) {
+		t.Errorf("after bob withdrew, notifications = %+v, want %d with only ann", got, n.ID)
+	}
+	if err := db.WithdrawNotification(user.ID, NotificationFollow, ann.ID); err != nil {
+		t.Fatalf("WithdrawNotification: %s", err)
+	}
+	if got, unread := db.GetNotifications(user.ID, 0, 10, false); len(got) != 0 || unread != 0 {
+		t.Errorf("after everyone withdrew, got %+v and %d unread, want none", got, unread)
+	}
+}
+
+func TestGetNotifications(t *testing.T) {
+	db := newTestDB(t)
+	user := mustCreateUser(t, db, "user")
+	ann := mustCreateUser(t, db, "ann")
+	bob := mustCreateUser(t, db, "bob")
+	suspended := mustCreateUser(t, db, "suspended")
+
+	mustNotify(t, db, user.ID, NotificationFollow, suspended.ID, 0)
+	follow := mustNotify(t, db, user.ID, NotificationFollow, ann.ID, 0)
+	var mentions []Notification
+	for i := 0; i < 3; i++ {
+		mentions = append(mentions, mustNotify(t, db, user.ID, NotificationMention, bob.ID, mustPost(t, db, bob).ID))
+	}
+	gone := mustNotify(t, db, user.ID, NotificationMention, suspended.ID, mustPost(t, db, suspended).ID)
+	if _, err := db.SuspendUser(suspended.ID, user.ID, "spam"); err != nil {
+		t.Fatalf("SuspendUser: %s", err)
+	}
+	if err := db.DeleteChirp(mentions[0].ChirpID); err != nil {
+		t.Fatalf("DeleteChirp: %s", err)
+	}
+	if _, err := db.MarkNotificationsRead(user.ID, []int{mentions[1].ID}); err != nil {
+		t.Fatalf("MarkNotificationsRead: %s", err)
+	}
+
+	got, unread := db.GetNotifications(user.ID, 0, 10, false)
+	assertNotificationIDs(t, "GetNotifications", got, mentions[2], mentions[1], follow)
+	if unread != 2 {
+		t.Errorf("unread = %d, want 2", unread)
+	}
+	for _, n := range got {
+		if n.ID == follow.ID && !reflect.DeepEqual(n.ActorIDs, []int{ann.ID}) {
+			t.Errorf("follow actors = %v, want the suspended user left out", n.ActorIDs)
+		}
+		if n.ID == gone.ID {
+			t.Error("notification by a suspended user alone was returned")
+		}
+	}
+
+	page, _ := db.GetNotifications(user.ID, 0, 2, false)
+	assertNotificationIDs(t, "first page", page, mentions[2], mentions[1])
+	page, _ = db.GetNotifications(user.ID, page[1].Seq, 2, false)
+	assertNotificationIDs(t, "second page", page, follow)
+
+	unreadOnly, _ := db.GetNotifications(user.ID, 0, 10, true)
+	assertNotificationIDs(t, "unread only", unreadOnly, mentions[2], follow)
+}
+
+func TestMarkNotificationsRead(t *testing.T) {
+	db := newTestDB(t)
+	user := mustCreateUser(t, db, "user")
+	other := mustCreateUser(t, db, "other")
+	ann := mustCreateUser(t, db, "ann")
+	mine := mustNotify(t, db, user.ID, NotificationMention, ann.ID, mustPost(t, db, ann).ID)
+	mustNotify(t, db, user.ID, NotificationFollow, ann.ID, 0)
+	theirs := mustNotify(t, db, other.ID, NotificationFollow, ann.ID, 0)
+
+	if _, err := db.MarkNotificationsRead(user.ID, []int{mine.ID, theirs.ID}); err != ErrDoesNotExist {
+		t.Fatalf("marking another user's notification: got error %v, want ErrDoesNotExist", err)
+	}
+	if _, unread := db.GetNotifications(user.ID, 0, 10, false); unread != 2 {
+		t.Errorf("a refused request marked notifications read: %d unread, want 2", unread)
+	}
+	if marked, err := db.MarkNotificationsRead(user.ID, []int{mine.ID}); err != nil || marked != 1 {
+		t.Errorf("MarkNotificationsRead(mine) = %d, %v, want 1", marked, err)
+	}
+	if marked, err := db.MarkNotificationsRead(user.ID, nil); err != nil || marked != 1 {
+		t.Errorf("MarkNotificationsRead(all) = %d, %v, want 1", marked, err)
+	}
+	if marked, err := db.MarkNotificationsRead(user.ID, nil); err != nil || marked != 0 {
+		t.Errorf("MarkNotificationsRead again = %d, %v, want 0", marked, err)
+	}
+	if _, unread := db.GetNotifications(other.ID, 0, 10, false); unread != 1 {
+		t.Errorf("other user has %d unread, want 1", unread)
+	}
+}
+
+func assertNotificationIDs(t *testing.T, what string, got []Notification, want ...Notification) {
+	t.Helper()
+	var gotIDs, wantIDs []int
+	for _, n := range got {
+		gotIDs = append(gotIDs, n.ID)
+	}
+	for _, n := range want {
+		wantIDs = append(wantIDs, n.ID)
+	}
+	if !reflect.DeepEqual(gotIDs, wantIDs) {
+		t.Errorf("%s returned notifications %v, want %v", what, gotIDs, wantIDs)
+	}
+}
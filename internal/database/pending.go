@@ -0,0 +1,204 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+// ErrPendingChanged is returned when a pending chirp is published from a copy
+// that is no longer current, because its author edited, rescheduled or
+// unscheduled it in the meantime.
+var ErrPendingChanged = errors.New("pending chirp has changed")
+
+type PendingStatus string
+
+const (
+	PendingDraft     PendingStatus = "draft"
+	PendingScheduled PendingStatus = "scheduled"
+	// PendingFailed chirps were scheduled but couldn't be published, for
+	// example because they no longer pass moderation. They stay until the
+	// author fixes and reschedules them or deletes them.
+	PendingFailed PendingStatus = "failed"
+)
+
+// PendingChirp is a draft or scheduled chirp, visible only to its author until
+// it is published.
+type PendingChirp struct {
+	ID            int           `json:"id"`
+	AuthorID      int           `json:"author_id"`
+	Body          string        `json:"body"`
+	AttachmentIDs []int         `json:"attachment_ids,omitempty"`
+	Visibility    Visibility    `json:"visibility,omitempty"`
+	Status        PendingStatus `json:"status"`
+	PublishAt     time.Time     `json:"publish_at"`
+	FailureReason string        `json:"failure_reason,omitempty"`
+	CreatedAt     time.Time     `json:"created_at"`
+	UpdatedAt     time.Time     `json:"updated_at"`
+}
+
+func (db *DB) CreatePendingChirp(p PendingChirp) (PendingChirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return PendingChirp{}, err
+	}
+	now := time.Now().UTC()
+	p.ID = db.data.LastPendingID + 1
+	p.FailureReason = ""
+	p.CreatedAt = now
+	p.UpdatedAt = now
+	db.data.PendingChirps[p.ID] = p
+	db.data.LastPendingID = p.ID
+	err = db.writeDB()
+	if err != nil {
+		return PendingChirp{}, err
+	}
+	return p, nil
+}
+
+func (db *DB) GetPendingChirp(pendingID int) (PendingChirp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	p, ok := db.data.PendingChirps[pendingID]
+	if !ok {
+		return PendingChirp{}, ErrDoesNotExist
+	}
+	return p, nil
+}
+
+// GetPendingChirps returns authorID's pending chirps, optionally only those
+// with the given status, ordered by ID.
+func (db *DB) GetPendingChirps(authorID int, status PendingStatus) []PendingChirp {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	pending := make([]PendingChirp, 0)
+	for _, p := range db.data.PendingChirps {
+		if p.AuthorID == authorID && (status == "" || p.Status == status) {
+			pending = append(pending, p)
+		}
+	}
+	sortSlice(pending, Asc, func(p PendingChirp) int { return p.ID })
+	return pending
+}
+
+// GetDuePendingChirps returns scheduled chirps whose publish time has passed,
+// oldest first.
+func (db *DB) GetDuePendingChirps(now time.Time) []PendingChirp {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	due := make([]PendingChirp, 0)
+	for _, p := range db.data.PendingChirps {
+		if p.Status == PendingScheduled && !p.PublishAt.After(now) {
+			due = append(due, p)
+		}
+	}
+	sortSlice(due, Asc, func(p PendingChirp) int64 { return p.PublishAt.UnixNano() })
+	return due
+}
+
+// NextPublishTime returns the earliest publish time of any scheduled chirp.
+func (db *DB) NextPublishTime() (time.Time, bool) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	var next time.Time
+	for _, p := range db.data.PendingChirps {
+		if p.Status == PendingScheduled && (next.IsZero() || p.PublishAt.Before(next)) {
+			next = p.PublishAt
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// UpdatePendingChirp replaces the body, attachments, status and publish time
+// of a pending chirp, clearing any earlier failure.
+func (db *DB) UpdatePendingChirp(pendingID int, update PendingChirp) (PendingChirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return PendingChirp{}, err
+	}
+	p, ok := db.data.PendingChirps[pendingID]
+	if !ok {
+		return PendingChirp{}, ErrDoesNotExist
+	}
+	p.Body = update.Body
+	p.AttachmentIDs = update.AttachmentIDs
+	p.Visibility = update.Visibility
+	p.Status = update.Status
+	p.PublishAt = update.PublishAt
+	p.FailureReason = ""
+	p.UpdatedAt = time.Now().UTC()
+	db.data.PendingChirps[pendingID] = p
+	err = db.writeDB()
+	if err != nil {
+		return PendingChirp{}, err
+	}
+	return p, nil
+}
+
+func (db *DB) FailPendingChirp(pendingID int, reason string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	p, ok := db.data.PendingChirps[pendingID]
+	if !ok {
+		return ErrDoesNotExist
+	}
+	p.Status = PendingFailed
+	p.FailureReason = reason
+	p.UpdatedAt = time.Now().UTC()
+	db.data.PendingChirps[pendingID] = p
+	return db.writeDB()
+}
+
+func (db *DB) DeletePendingChirp(pendingID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	delete(db.data.PendingChirps, pendingID)
+	return db.writeDB()
+}
+
+// PublishPendingChirp creates chirp, built from the pending chirp p, and
+// removes p in a single write, so a chirp is never published twice or lost if
+// we crash part way through. It returns ErrPendingChanged if p has changed
+// since it was read, or, if dueBy isn't zero, isn't scheduled for dueBy or
+// earlier.
+func (db *DB) PublishPendingChirp(p PendingChirp, chirp Chirp, dueBy time.Time) (Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return Chirp{}, err
+	}
+	current, ok := db.data.PendingChirps[p.ID]
+	if !ok {
+		return Chirp{}, ErrDoesNotExist
+	}
+	if !current.UpdatedAt.Equal(p.UpdatedAt) || current.Status != p.Status || current.Body != p.Body ||
+		current.Visibility != p.Visibility || !slices.Equal(current.AttachmentIDs, p.AttachmentIDs) {
+		return Chirp{}, ErrPendingChanged
+	}
+	if !dueBy.IsZero() && (current.Status != PendingScheduled || current.PublishAt.After(dueBy)) {
+		return Chirp{}, ErrPendingChanged
+	}
+	newChirp, err := db.insertChirp(chirp)
+	if err != nil {
+		return Chirp{}, err
+	}
+	delete(db.data.PendingChirps, p.ID)
+	err = db.writeDB()
+	if err != nil {
+		return Chirp{}, err
+	}
+	return newChirp, nil
+}
@@ -0,0 +1,67 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPublishPendingChirpRechecksSnapshot(t *testing.T) {
+	db := newTestDB(t)
+	author := mustCreateUser(t, db, "alice")
+	now := time.Now().UTC()
+	p, err := db.CreatePendingChirp(PendingChirp{
+		AuthorID:  author.ID,
+		Body:      "old",
+		Status:    PendingScheduled,
+		PublishAt: now.Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("CreatePendingChirp: %s", err)
+	}
+	due := db.GetDuePendingChirps(now)
+	if len(due) != 1 {
+		t.Fatalf("got %d due chirps, want 1", len(due))
+	}
+	snapshot := due[0]
+
+	// The author pushes it back an hour with a new body before the scheduler
+	// gets to it
+	update := p
+	update.Body = "new"
+	update.PublishAt = now.Add(time.Hour)
+	_, err = db.UpdatePendingChirp(p.ID, update)
+	if err != nil {
+		t.Fatalf("UpdatePendingChirp: %s", err)
+	}
+
+	chirp := Chirp{AuthorID: author.ID, Body: snapshot.Body}
+	_, err = db.PublishPendingChirp(snapshot, chirp, now)
+	if err != ErrPendingChanged {
+		t.Fatalf("PublishPendingChirp from stale snapshot: got %v, want ErrPendingChanged", err)
+	}
+	if chirps := db.GetChirps(0, 0, Asc); len(chirps) != 0 {
+		t.Fatalf("stale chirp was published: %+v", chirps)
+	}
+
+	current, err := db.GetPendingChirp(p.ID)
+	if err != nil {
+		t.Fatalf("GetPendingChirp: %s", err)
+	}
+	_, err = db.PublishPendingChirp(current, chirp, now)
+	if err != ErrPendingChanged {
+		t.Fatalf("PublishPendingChirp before it's due: got %v, want ErrPendingChanged", err)
+	}
+
+	// Publishing on request doesn't wait for the scheduled time
+	chirp.Body = current.Body
+	published, err := db.PublishPendingChirp(current, chirp, time.Time{})
+	if err != nil {
+		t.Fatalf("PublishPendingChirp: %s", err)
+	}
+	if published.Body != "new" {
+		t.Errorf("published body = %q, want %q", published.Body, "new")
+	}
+	if _, err := db.GetPendingChirp(p.ID); err != ErrDoesNotExist {
+		t.Errorf("pending chirp still exists after publishing: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrPollClosed    = errors.New("poll is closed")
+	ErrInvalidOption = errors.New("invalid poll option")
+)
+
+type Poll struct {
+	Options  []string  `json:"options"`
+	ClosesAt time.Time `json:"closes_at"`
+}
+
+// PollResults are the tallies for each option of a poll, in order, along
+// with the option the requesting user voted for, or -1 if they haven't.
+type PollResults struct {
+	Counts   []int
+	Total    int
+	UserVote int
+}
+
+// VoteInPoll records userID's vote for option, replacing any earlier vote,
+// as long as the poll hasn't closed.
+func (db *DB) VoteInPoll(chirpID int, userID int, option int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	chirp, ok := db.data.Chirps[chirpID]
+	if !ok || chirp.Poll == nil || db.hiddenFrom(userID)(chirp) {
+		return ErrDoesNotExist
+	}
+	if !time.Now().Before(chirp.Poll.ClosesAt) {
+		return ErrPollClosed
+	}
+	if option < 0 || option >= len(chirp.Poll.Options) {
+		return ErrInvalidOption
+	}
+	votes, ok := db.data.PollVotes[chirpID]
+	if !ok {
+		votes = make(map[int]int)
+		db.data.PollVotes[chirpID] = votes
+	}
+	votes[userID] = option
+	return db.writeDB()
+}
+
+// GetPollResults tallies the votes in a chirp's poll for userID.
+func (db *DB) GetPollResults(chirpID int, userID int) (PollResults, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	chirp, ok := db.data.Chirps[chirpID]
+	if !ok || chirp.Poll == nil {
+		return PollResults{}, ErrDoesNotExist
+	}
+	results := PollResults{
+		Counts:   make([]int, len(chirp.Poll.Options)),
+		UserVote: -1,
+	}
+	for voterID, option := range db.data.PollVotes[chirpID] {
+		results.Counts[option]++
+		results.Total++
+		if voterID == userID {
+			results.UserVote = option
+		}
+	}
+	return results, nil
+}
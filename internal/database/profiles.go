@@ -0,0 +1,172 @@
+package database
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	// HandleReservationPeriod is how long a handle stays reserved for its
+	// previous owner after they change away from it.
+	HandleReservationPeriod = 30 * 24 * time.Hour
+)
+
+type HandleReservation struct {
+	UserID int       `json:"user_id"`
+	Until  time.Time `json:"until"`
+}
+
+type UserStats struct {
+	Chirps    int
+	Followers int
+	Following int
+}
+
+func handleKey(handle string) string {
+	return strings.ToLower(handle)
+}
+
+// checkHandleAvailable reports whether userID may claim handle: it must not
+// belong to, or be reserved for, anyone else.
+func (db *DB) checkHandleAvailable(handle string, userID int) error {
+	key := handleKey(handle)
+	if ownerID, ok := db.data.UserHandleIndex[key]; ok && ownerID != userID {
+		return ErrAlreadyExists
+	}
+	res, ok := db.data.ReservedHandles[key]
+	if ok && res.UserID != userID && res.Until.After(time.Now().UTC()) {
+		return ErrHandleReserved
+	}
+	return nil
+}
+
+func (db *DB) HandleAvailable(handle string) bool {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	return db.checkHandleAvailable(handle, 0) == nil
+}
+
+func (db *DB) GetUserByHandle(handle string) (User, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	userID, ok := db.data.UserHandleIndex[handleKey(handle)]
+	if !ok {
+		return User{}, ErrDoesNotExist
+	}
+	user, ok := db.data.Users[userID]
+	if !ok {
+		return User{}, ErrDoesNotExist
+	}
+	return user.User, nil
+}
+
+// ChangeHandle gives userID a new handle. The old handle is reserved for the
+// user for HandleReservationPeriod so nobody can impersonate them by claiming
+// it straight away; they are free to change back to it in the meantime.
+func (db *DB) ChangeHandle(userID int, handle string) (User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+	user, ok := db.data.Users[userID]
+	if !ok {
+		return User{}, ErrDoesNotExist
+	}
+	err = db.checkHandleAvailable(handle, userID)
+	if err != nil {
+		return User{}, err
+	}
+	now := time.Now().UTC()
+	for key, res := range db.data.ReservedHandles {
+		if !res.Until.After(now) {
+			delete(db.data.ReservedHandles, key)
+		}
+	}
+	oldKey, newKey := handleKey(user.Handle), handleKey(handle)
+	if user.Handle != "" && oldKey != newKey {
+		delete(db.data.UserHandleIndex, oldKey)
+		db.data.ReservedHandles[oldKey] = HandleReservation{
+			UserID: userID,
+			Until:  now.Add(HandleReservationPeriod),
+		}
+	}
+	delete(db.data.ReservedHandles, newKey)
+	user.Handle = handle
+	db.data.Users[userID] = user
+	db.data.UserHandleIndex[newKey] = userID
+	err = db.writeDB()
+	if err != nil {
+		return User{}, err
+	}
+	return user.User, nil
+}
+
+func (db *DB) UpdateProfile(userID int, displayName string, bio string, avatar string) (User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+	user, ok := db.data.Users[userID]
+	if !ok {
+		return User{}, ErrDoesNotExist
+	}
+	user.DisplayName = displayName
+	user.Bio = bio
+	user.Avatar = avatar
+	db.data.Users[userID] = user
+	err = db.writeDB()
+	if err != nil {
+		return User{}, err
+	}
+	return user.User, nil
+}
+
+func (db *DB) SetAdmin(userID int, admin bool) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	user, ok := db.data.Users[userID]
+	if !ok {
+		return ErrDoesNotExist
+	}
+	user.Admin = admin
+	db.data.Users[userID] = user
+	return db.writeDB()
+}
+
+// SetDMsFromFollowingOnly sets whether only people userID follows can message
+// them.
+func (db *DB) SetDMsFromFollowingOnly(userID int, followingOnly bool) (User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+	user, ok := db.data.Users[userID]
+	if !ok {
+		return User{}, ErrDoesNotExist
+	}
+	user.DMsFromFollowingOnly = followingOnly
+	db.data.Users[userID] = user
+	return user.User, db.writeDB()
+}
+
+// GetUserStats counts a user's chirps, and the followers and followees that
+// GetFollowers and GetFollowing list.
+func (db *DB) GetUserStats(userID int) UserStats {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	return UserStats{
+		Chirps:    len(db.data.AuthorChirpIndex[userID]),
+		Followers: db.countUsersInSet(db.data.FollowerIndex[userID]),
+		Following: db.countUsersInSet(db.data.Follows[userID]),
+	}
+}
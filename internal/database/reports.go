@@ -0,0 +1,287 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrReportClaimed  = errors.New("report is claimed by another moderator")
+	ErrReportResolved = errors.New("report is already resolved")
+	ErrInvalidAction  = errors.New("action does not apply to this report")
+)
+
+type ReportReason string
+
+const (
+	ReasonSpam       ReportReason = "spam"
+	ReasonHarassment ReportReason = "harassment"
+	ReasonHate       ReportReason = "hate"
+	ReasonViolence   ReportReason = "violence"
+	ReasonOther      ReportReason = "other"
+	// ReasonAutomated reports are filed by the moderation pipeline for
+	// flagged chirps rather than by a user.
+	ReasonAutomated ReportReason = "automated"
+)
+
+// ReportReasons are the reasons users can give when reporting.
+var ReportReasons = []ReportReason{ReasonSpam, ReasonHarassment, ReasonHate, ReasonViolence, ReasonOther}
+
+type ReportStatus string
+
+const (
+	ReportOpen     ReportStatus = "open"
+	ReportClaimed  ReportStatus = "claimed"
+	ReportResolved ReportStatus = "resolved"
+)
+
+type ModerationAction string
+
+const (
+	ActionClaim       ModerationAction = "claim"
+	ActionDismiss     ModerationAction = "dismiss"
+	ActionHideChirp   ModerationAction = "hide_chirp"
+	ActionSuspendUser ModerationAction = "suspend_user"
+	// ActionUnsuspendUser only appears in the audit log.
+	ActionUnsuspendUser ModerationAction = "unsuspend_user"
+)
+
+// Report is a complaint about a chirp or a user. For chirp reports UserID is
+// the chirp's author. ReporterID is 0 for automated reports, and for resolved
+// reports whose reporter has since deleted their account.
+type Report struct {
+	ID         int              `json:"id"`
+	ReporterID int              `json:"reporter_id"`
+	ChirpID    int              `json:"chirp_id,omitempty"`
+	UserID     int              `json:"user_id"`
+	Reason     ReportReason     `json:"reason"`
+	Details    string           `json:"details,omitempty"`
+	Status     ReportStatus     `json:"status"`
+	ClaimedBy  int              `json:"claimed_by,omitempty"`
+	Resolution ModerationAction `json:"resolution,omitempty"`
+	CreatedAt  time.Time        `json:"created_at"`
+	ResolvedAt *time.Time       `json:"resolved_at,omitempty"`
+}
+
+// AuditEntry records one thing a moderator did. ReportID is 0 for actions
+// taken outside of a report.
+type AuditEntry struct {
+	ID          int              `json:"id"`
+	ModeratorID int              `json:"moderator_id"`
+	Action      ModerationAction `json:"action"`
+	ReportID    int              `json:"report_id,omitempty"`
+	ChirpID     int              `json:"chirp_id,omitempty"`
+	UserID      int              `json:"user_id,omitempty"`
+	Note        string           `json:"note,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+}
+
+// ReportChirp files a report by reporterID against a chirp. A reporter can
+// only have one unresolved report per chirp.
+func (db *DB) ReportChirp(reporterID int, chirpID int, reason ReportReason, details string) (Report, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return Report{}, err
+	}
+	chirp, ok := db.data.Chirps[chirpID]
+	if !ok || db.hiddenFrom(reporterID)(chirp) {
+		return Report{}, ErrDoesNotExist
+	}
+	return db.fileReport(Report{
+		ReporterID: reporterID,
+		ChirpID:    chirpID,
+		UserID:     chirp.AuthorID,
+		Reason:     reason,
+		Details:    details,
+	})
+}
+
+// ReportUser files a report by reporterID against a user. A reporter can only
+// have one unresolved report per user.
+func (db *DB) ReportUser(reporterID int, userID int, reason ReportReason, details string) (Report, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return Report{}, err
+	}
+	if _, ok := db.data.Users[userID]; !ok {
+		return Report{}, ErrDoesNotExist
+	}
+	return db.fileReport(Report{
+		ReporterID: reporterID,
+		UserID:     userID,
+		Reason:     reason,
+		Details:    details,
+	})
+}
+
+func (db *DB) fileReport(report Report) (Report, error) {
+	for _, r := range db.data.Reports {
+		if r.Status != ReportResolved && r.ReporterID == report.ReporterID &&
+			r.ChirpID == report.ChirpID && r.UserID == report.UserID {
+			return Report{}, ErrAlreadyExists
+		}
+	}
+	db.addReport(report)
+	return db.data.Reports[db.data.LastReportID], db.writeDB()
+}
+
+func (db *DB) addReport(report Report) {
+	report.ID = db.data.LastReportID + 1
+	report.Status = ReportOpen
+	report.CreatedAt = time.Now().UTC()
+	db.data.Reports[report.ID] = report
+	db.data.LastReportID = report.ID
+}
+
+// reportFlags queues a chirp flagged by the moderation pipeline for review,
+// unless it's already waiting.
+func (db *DB) reportFlags(chirp Chirp) {
+	if len(chirp.Flags) == 0 {
+		return
+	}
+	for _, r := range db.data.Reports {
+		if r.ReporterID == 0 && r.ChirpID == chirp.ID && r.Status != ReportResolved {
+			return
+		}
+	}
+	db.addReport(Report{
+		ChirpID: chirp.ID,
+		UserID:  chirp.AuthorID,
+		Reason:  ReasonAutomated,
+		Details: strings.Join(chirp.Flags, "; "),
+	})
+}
+
+// GetReports returns the reports with any of statuses, oldest first.
+func (db *DB) GetReports(statuses ...ReportStatus) []Report {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	reports := make([]Report, 0)
+	for _, r := range db.data.Reports {
+		for _, s := range statuses {
+			if r.Status == s {
+				reports = append(reports, r)
+				break
+			}
+		}
+	}
+	sortSlice(reports, Asc, func(r Report) int { return r.ID })
+	return reports
+}
+
+// ClaimReport assigns an unresolved report to moderatorID so others know it's
+// being handled. Claiming a report you already hold is a no-op.
+func (db *DB) ClaimReport(reportID int, moderatorID int) (Report, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return Report{}, err
+	}
+	report, ok := db.data.Reports[reportID]
+	if !ok {
+		return Report{}, ErrDoesNotExist
+	}
+	switch report.Status {
+	case ReportResolved:
+		return Report{}, ErrReportResolved
+	case ReportClaimed:
+		if report.ClaimedBy != moderatorID {
+			return Report{}, ErrReportClaimed
+		}
+		return report, nil
+	}
+	report.Status = ReportClaimed
+	report.ClaimedBy = moderatorID
+	db.data.Reports[reportID] = report
+	db.audit(moderatorID, ActionClaim, report, "")
+	return report, db.writeDB()
+}
+
+// ResolveReport closes a report by taking action. Hiding a chirp or
+// suspending a user also resolves the other unresolved reports about it.
+// Reports claimed by someone else can't be resolved.
+func (db *DB) ResolveReport(reportID int, moderatorID int, action ModerationAction, note string) (Report, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return Report{}, err
+	}
+	report, ok := db.data.Reports[reportID]
+	if !ok {
+		return Report{}, ErrDoesNotExist
+	}
+	if report.Status == ReportResolved {
+		return Report{}, ErrReportResolved
+	}
+	if report.Status == ReportClaimed && report.ClaimedBy != moderatorID {
+		return Report{}, ErrReportClaimed
+	}
+
+	covers := func(r Report) bool { return r.ID == reportID }
+	switch action {
+	case ActionDismiss:
+	case ActionHideChirp:
+		chirp, ok := db.data.Chirps[report.ChirpID]
+		if !ok {
+			return Report{}, ErrInvalidAction
+		}
+		chirp.Hidden = true
+		db.data.Chirps[chirp.ID] = chirp
+		covers = func(r Report) bool { return r.ChirpID == chirp.ID }
+	case ActionSuspendUser:
+		if _, ok := db.data.Users[report.UserID]; !ok {
+			return Report{}, ErrInvalidAction
+		}
+		db.suspend(report.UserID)
+		covers = func(r Report) bool { return r.UserID == report.UserID }
+	default:
+		return Report{}, ErrInvalidAction
+	}
+
+	now := time.Now().UTC()
+	for id, r := range db.data.Reports {
+		if r.Status == ReportResolved || !covers(r) {
+			continue
+		}
+		if r.Status == ReportClaimed && r.ClaimedBy != moderatorID && id != reportID {
+			continue
+		}
+		r.Status = ReportResolved
+		r.ClaimedBy = moderatorID
+		r.Resolution = action
+		r.ResolvedAt = &now
+		db.data.Reports[id] = r
+	}
+	report = db.data.Reports[reportID]
+	db.audit(moderatorID, action, report, note)
+	return report, db.writeDB()
+}
+
+// GetAuditLog returns every moderator action, oldest first.
+func (db *DB) GetAuditLog() []AuditEntry {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	entries := make([]AuditEntry, len(db.data.AuditLog))
+	copy(entries, db.data.AuditLog)
+	return entries
+}
+
+func (db *DB) audit(moderatorID int, action ModerationAction, report Report, note string) {
+	db.data.AuditLog = append(db.data.AuditLog, AuditEntry{
+		ID:          len(db.data.AuditLog) + 1,
+		ModeratorID: moderatorID,
+		Action:      action,
+		ReportID:    report.ID,
+		ChirpID:     report.ChirpID,
+		UserID:      report.UserID,
+		Note:        note,
+		CreatedAt:   time.Now().UTC(),
+	})
+}
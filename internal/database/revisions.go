@@ -0,0 +1,62 @@
+package database
+
+import "time"
+
+// ChirpRevision is a previous version of an edited chirp. WrittenAt is when
+// this version was posted or last edited.
+type ChirpRevision struct {
+	Body      string    `json:"body"`
+	Entities  []Entity  `json:"entities,omitempty"`
+	WrittenAt time.Time `json:"written_at"`
+}
+
+// EditChirp replaces the body, entities and flags of a chirp with those of
+// edit, keeping the current version in the chirp's history.
+func (db *DB) EditChirp(chirpID int, edit Chirp) (Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp, ok := db.data.Chirps[chirpID]
+	if !ok {
+		return Chirp{}, ErrDoesNotExist
+	}
+	writtenAt := chirp.CreatedAt
+	if !chirp.EditedAt.IsZero() {
+		writtenAt = chirp.EditedAt
+	}
+	db.data.ChirpRevisions[chirpID] = append(db.data.ChirpRevisions[chirpID], ChirpRevision{
+		Body:      chirp.Body,
+		Entities:  chirp.Entities,
+		WrittenAt: writtenAt,
+	})
+	db.unindexChirp(chirp)
+	chirp.Body = edit.Body
+	chirp.Entities = edit.Entities
+	chirp.Flags = edit.Flags
+	chirp.Visibility = edit.Visibility
+	chirp.EditedAt = time.Now().UTC()
+	db.data.Chirps[chirpID] = chirp
+	db.indexChirp(chirp)
+	db.reportFlags(chirp)
+	err = db.writeDB()
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// GetChirpHistory returns the previous versions of a chirp, oldest first, if
+// viewerID may see it.
+func (db *DB) GetChirpHistory(viewerID int, chirpID int) ([]ChirpRevision, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	if chirp, ok := db.data.Chirps[chirpID]; !ok || db.hiddenFrom(viewerID)(chirp) {
+		return nil, ErrDoesNotExist
+	}
+	revisions := make([]ChirpRevision, len(db.data.ChirpRevisions[chirpID]))
+	copy(revisions, db.data.ChirpRevisions[chirpID])
+	return revisions, nil
+}
@@ -0,0 +1,206 @@
+package database
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/thomasem/chirpy/internal/search"
+)
+
+type SearchSort int
+
+const (
+	SortRelevance SearchSort = iota
+	SortRecent
+)
+
+// SearchQuery is a search.Query with authors resolved to user IDs.
+type SearchQuery struct {
+	Terms     []string
+	Phrases   [][]string
+	AuthorIDs []int
+	Hashtags  []string
+	Since     time.Time
+	Until     time.Time
+	Sort      SearchSort
+}
+
+// SearchChirps returns up to limit chirps matching q that viewerID may see,
+// skipping the first offset matches.
+func (db *DB) SearchChirps(viewerID int, q SearchQuery, offset int, limit int) []Chirp {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	hidden := db.hiddenFromListings(viewerID)
+
+	terms := append([]string{}, q.Terms...)
+	for _, phrase := range q.Phrases {
+		terms = append(terms, phrase...)
+	}
+	var matches []int
+	for _, id := range db.searchCandidates(terms, q) {
+		if chirp := db.data.Chirps[id]; !hidden(chirp) && db.matchesSearch(chirp, q) {
+			matches = append(matches, id)
+		}
+	}
+
+	if q.Sort == SortRelevance && len(terms) > 0 {
+		scores := make(map[int]float64, len(matches))
+		for _, id := range matches {
+			scores[id] = db.searchScore(id, terms)
+		}
+		sort.SliceStable(matches, func(i, j int) bool {
+			if scores[matches[i]] != scores[matches[j]] {
+				return scores[matches[i]] > scores[matches[j]]
+			}
+			return matches[i] > matches[j]
+		})
+	} else {
+		sort.Sort(sort.Reverse(sort.IntSlice(matches)))
+	}
+
+	if offset >= len(matches) {
+		return []Chirp{}
+	}
+	matches = matches[offset:min(offset+limit, len(matches))]
+	chirps := make([]Chirp, 0, len(matches))
+	for _, id := range matches {
+		chirps = append(chirps, db.data.Chirps[id])
+	}
+	return chirps
+}
+
+// searchCandidates narrows the chirps worth checking using the most selective
+// index available: the posting lists of the terms, then hashtags, then
+// authors, falling back to every chirp.
+func (db *DB) searchCandidates(terms []string, q SearchQuery) []int {
+	switch {
+	case len(terms) > 0:
+		var ids []int
+		for i, term := range terms {
+			postings := db.data.SearchIndex[term]
+			if i == 0 {
+				for id := range postings {
+					ids = append(ids, id)
+				}
+				continue
+			}
+			kept := ids[:0]
+			for _, id := range ids {
+				if _, ok := postings[id]; ok {
+					kept = append(kept, id)
+				}
+			}
+			ids = kept
+		}
+		return ids
+	case len(q.Hashtags) > 0:
+		return db.data.HashtagIndex[q.Hashtags[0]]
+	case len(q.AuthorIDs) > 0:
+		var ids []int
+		for i, authorID := range q.AuthorIDs {
+			// The same author may be named more than once
+			if !containsInt(q.AuthorIDs[:i], authorID) {
+				ids = append(ids, db.data.AuthorChirpIndex[authorID]...)
+			}
+		}
+		return ids
+	}
+	ids := make([]int, 0, len(db.data.Chirps))
+	for id := range db.data.Chirps {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (db *DB) matchesSearch(chirp Chirp, q SearchQuery) bool {
+	if len(q.AuthorIDs) > 0 && !containsInt(q.AuthorIDs, chirp.AuthorID) {
+		return false
+	}
+	if !q.Since.IsZero() && chirp.CreatedAt.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !chirp.CreatedAt.Before(q.Until) {
+		return false
+	}
+	for _, tag := range q.Hashtags {
+		if !hasHashtag(chirp, tag) {
+			return false
+		}
+	}
+	for _, phrase := range q.Phrases {
+		if !db.containsPhrase(chirp.ID, phrase) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsPhrase checks the positions recorded in the index for the terms of
+// phrase appearing consecutively in the chirp.
+func (db *DB) containsPhrase(chirpID int, phrase []string) bool {
+	for _, start := range db.data.SearchIndex[phrase[0]][chirpID] {
+		found := true
+		for i, term := range phrase[1:] {
+			if !containsInt(db.data.SearchIndex[term][chirpID], start+i+1) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// searchScore is a plain TF-IDF score of the chirp for terms.
+func (db *DB) searchScore(chirpID int, terms []string) float64 {
+	total := float64(len(db.data.Chirps))
+	var score float64
+	for _, term := range terms {
+		postings := db.data.SearchIndex[term]
+		tf := float64(len(postings[chirpID]))
+		idf := math.Log(1 + total/float64(len(postings)))
+		score += tf * idf
+	}
+	return score
+}
+
+func (db *DB) indexChirpText(chirp Chirp) {
+	for pos, term := range search.Tokenize(chirp.Body) {
+		postings, ok := db.data.SearchIndex[term]
+		if !ok {
+			postings = make(map[int][]int)
+			db.data.SearchIndex[term] = postings
+		}
+		postings[chirp.ID] = append(postings[chirp.ID], pos)
+	}
+}
+
+func (db *DB) unindexChirpText(chirp Chirp) {
+	for _, term := range search.Tokenize(chirp.Body) {
+		delete(db.data.SearchIndex[term], chirp.ID)
+		if len(db.data.SearchIndex[term]) == 0 {
+			delete(db.data.SearchIndex, term)
+		}
+	}
+}
+
+func hasHashtag(chirp Chirp, tag string) bool {
+	for _, e := range chirp.Entities {
+		if e.Type == EntityHashtag && e.Text == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package database
+
+import "testing"
+
+// searchFixture posts chirps by ann and bob, oldest first.
+func searchFixture(t *testing.T) (*DB, User, User, []Chirp) {
+	t.Helper()
+	db := newTestDB(t)
+	ann := mustCreateUser(t, db, "ann")
+	bob := mustCreateUser(t, db, "bob")
+	posts := []struct {
+		author User
+		body   string
+		tag    string
+	}{
+		{ann, "the web server is up", ""},
+		{bob, "server web is down", ""},
+		{ann, "web web web", "go"},
+		{bob, "a server for the web", "go"},
+		{ann, "lunch", ""},
+	}
+	var chirps []Chirp
+	for _, p := range posts {
+		chirp := Chirp{AuthorID: p.author.ID, Body: p.body}
+		if p.tag != "" {
+			chirp.Entities = []Entity{{Type: EntityHashtag, Text: p.tag}}
+		}
+		created, err := db.CreateChirp(chirp)
+		if err != nil {
+			t.Fatalf("CreateChirp: %s", err)
+		}
+		chirps = append(chirps, created)
+	}
+	return db, ann, bob, chirps
+}
+
+func TestSearchChirps(t *testing.T) {
+	db, ann, bob, c := searchFixture(t)
+	tests := []struct {
+		name string
+		q    SearchQuery
+		want []Chirp
+	}{
+		{"every term must match", SearchQuery{Terms: []string{"web", "server"}}, []Chirp{c[3], c[1], c[0]}},
+		{"unknown term", SearchQuery{Terms: []string{"web", "nothing"}}, nil},
+		{"phrase in order", SearchQuery{Phrases: [][]string{{"web", "server"}}}, []Chirp{c[0]}},
+		{"author", SearchQuery{AuthorIDs: []int{bob.ID}}, []Chirp{c[3], c[1]}},
+		{"author named twice", SearchQuery{AuthorIDs: []int{ann.ID, bob.ID, ann.ID}}, []Chirp{c[4], c[3], c[2], c[1], c[0]}},
+		{"terms and author", SearchQuery{Terms: []string{"web"}, AuthorIDs: []int{ann.ID}}, []Chirp{c[2], c[0]}},
+		{"hashtag", SearchQuery{Hashtags: []string{"go"}}, []Chirp{c[3], c[2]}},
+		{"hashtag and term", SearchQuery{Terms: []string{"server"}, Hashtags: []string{"go"}}, []Chirp{c[3]}},
+		{"since is inclusive", SearchQuery{Since: c[3].CreatedAt}, []Chirp{c[4], c[3]}},
+		{"until is exclusive", SearchQuery{Until: c[1].CreatedAt}, []Chirp{c[0]}},
+		{"date range", SearchQuery{Since: c[1].CreatedAt, Until: c[3].CreatedAt}, []Chirp{c[2], c[1]}},
+		{"no filters", SearchQuery{}, []Chirp{c[4], c[3], c[2], c[1], c[0]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.q.Sort = SortRecent
+			assertIDs(t, "SearchChirps", db.SearchChirps(0, tt.q, 0, 10), tt.want...)
+		})
+	}
+}
+
+func TestSearchChirpsByRelevance(t *testing.T) {
+	db, _, _, c := searchFixture(t)
+	// The chirp saying "web" three times ranks first, then the rest newest
+	// first, as they each say it once
+	got := db.SearchChirps(0, SearchQuery{Terms: []string{"web"}, Sort: SortRelevance}, 0, 10)
+	assertIDs(t, "SearchChirps by relevance", got, c[2], c[3], c[1], c[0])
+}
+
+func TestSearchChirpsPages(t *testing.T) {
+	db, _, _, c := searchFixture(t)
+	q := SearchQuery{Sort: SortRecent}
+	assertIDs(t, "first page", db.SearchChirps(0, q, 0, 2), c[4], c[3])
+	assertIDs(t, "second page", db.SearchChirps(0, q, 2, 2), c[2], c[1])
+	assertIDs(t, "last page", db.SearchChirps(0, q, 4, 2), c[0])
+	assertIDs(t, "past the end", db.SearchChirps(0, q, 5, 2))
+}
+
+func TestSearchIndexFollowsEditsAndDeletes(t *testing.T) {
+	db, _, _, c := searchFixture(t)
+	edit := c[4]
+	edit.Body = "dinner on the web"
+	if _, err := db.EditChirp(c[4].ID, edit); err != nil {
+		t.Fatalf("EditChirp: %s", err)
+	}
+	if err := db.DeleteChirp(c[0].ID); err != nil {
+		t.Fatalf("DeleteChirp: %s", err)
+	}
+	q := func(terms ...string) SearchQuery { return SearchQuery{Terms: terms, Sort: SortRecent} }
+	assertIDs(t, "old body", db.SearchChirps(0, q("lunch"), 0, 10))
+	assertIDs(t, "new body", db.SearchChirps(0, q("dinner"), 0, 10), c[4])
+	assertIDs(t, "after delete", db.SearchChirps(0, q("web", "server"), 0, 10), c[3], c[1])
+}
@@ -0,0 +1,205 @@
+package database
+
+import (
+	"slices"
+	"time"
+)
+
+type SubscriptionStatus string
+
+const (
+	SubscriptionActive SubscriptionStatus = "active"
+	// SubscriptionPastDue means a renewal payment failed. The user keeps
+	// Chirpy Red until the period they paid for ends.
+	SubscriptionPastDue  SubscriptionStatus = "past_due"
+	SubscriptionCanceled SubscriptionStatus = "canceled"
+	SubscriptionRefunded SubscriptionStatus = "refunded"
+	SubscriptionExpired  SubscriptionStatus = "expired"
+)
+
+type BillingEventType string
+
+const (
+	BillingUpgraded      BillingEventType = "upgraded"
+	BillingRenewed       BillingEventType = "renewed"
+	BillingPaymentFailed BillingEventType = "payment_failed"
+	BillingDowngraded    BillingEventType = "downgraded"
+	BillingRefunded      BillingEventType = "refunded"
+	// BillingExpired is recorded by ExpireSubscriptions rather than sent by
+	// the payment provider.
+	BillingExpired BillingEventType = "expired"
+)
+
+type Subscription struct {
+	Plan             string             `json:"plan"`
+	Status           SubscriptionStatus `json:"status"`
+	StartedAt        time.Time          `json:"started_at"`
+	CurrentPeriodEnd time.Time          `json:"current_period_end"`
+	UpdatedAt        time.Time          `json:"updated_at"`
+}
+
+// Entitled reports whether the subscription grants Chirpy Red at now.
+func (s Subscription) Entitled(now time.Time) bool {
+	if s.Status != SubscriptionActive && s.Status != SubscriptionPastDue {
+		return false
+	}
+	return now.Before(s.CurrentPeriodEnd)
+}
+
+// HasChirpyRed reports whether the user has Chirpy Red at now. Users who
+// upgraded before subscriptions were tracked keep it until their first
+// billing event.
+func (u User) HasChirpyRed(now time.Time) bool {
+	if u.Subscription == nil {
+		return u.ChirpyRed
+	}
+	return u.Subscription.Entitled(now)
+}
+
+// BillingEvent is a change to a user's subscription reported by the payment
+// provider. A zero PeriodEnd on an upgrade or renewal extends the
+// subscription by the period passed to ApplyBillingEvent.
+type BillingEvent struct {
+	Type      BillingEventType
+	EventID   string
+	Plan      string
+	PeriodEnd time.Time
+}
+
+// SubscriptionChange is an entry in a user's subscription history.
+type SubscriptionChange struct {
+	Event     BillingEventType   `json:"event"`
+	EventID   string             `json:"event_id,omitempty"`
+	Plan      string             `json:"plan"`
+	Status    SubscriptionStatus `json:"status"`
+	PeriodEnd time.Time          `json:"period_end"`
+	At        time.Time          `json:"at"`
+}
+
+// ApplyBillingEvent updates userID's subscription for ev, returning false
+// when the event doesn't change anything, such as a failed payment for a
+// user who isn't subscribed, or an event that was already applied.
+func (db *DB) ApplyBillingEvent(userID int, ev BillingEvent, period time.Duration) (bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return false, err
+	}
+	user, ok := db.data.Users[userID]
+	if !ok {
+		return false, ErrDoesNotExist
+	}
+	if ev.EventID != "" && slices.ContainsFunc(db.data.SubscriptionHistory[userID], func(c SubscriptionChange) bool {
+		return c.EventID == ev.EventID
+	}) {
+		return false, nil
+	}
+	now := time.Now().UTC()
+	var sub Subscription
+	if user.Subscription != nil {
+		sub = *user.Subscription
+	} else if user.ChirpyRed {
+		// Upgraded before subscriptions were tracked, so the period is unknown.
+		sub = Subscription{Status: SubscriptionActive, StartedAt: now, CurrentPeriodEnd: now.Add(period)}
+	}
+	entitled := sub.Entitled(now)
+	switch ev.Type {
+	case BillingUpgraded, BillingRenewed:
+		if !entitled {
+			sub.StartedAt = now
+		}
+		end := ev.PeriodEnd
+		if end.IsZero() {
+			end = now
+			if entitled && ev.Type == BillingRenewed {
+				end = sub.CurrentPeriodEnd
+			}
+			end = end.Add(period)
+		}
+		sub.Status = SubscriptionActive
+		sub.CurrentPeriodEnd = end.UTC()
+		if ev.Plan != "" {
+			sub.Plan = ev.Plan
+		}
+	case BillingPaymentFailed:
+		if sub.Status != SubscriptionActive {
+			return false, nil
+		}
+		sub.Status = SubscriptionPastDue
+	case BillingDowngraded:
+		if !entitled {
+			return false, nil
+		}
+		sub.Status = SubscriptionCanceled
+	case BillingRefunded:
+		if sub.Status == "" || sub.Status == SubscriptionRefunded {
+			return false, nil
+		}
+		sub.Status = SubscriptionRefunded
+	default:
+		return false, nil
+	}
+	db.setSubscription(user, sub, ev.Type, ev.EventID, now)
+	return true, db.writeDB()
+}
+
+// ExpireSubscriptions marks subscriptions whose period has ended as expired,
+// returning the IDs of the users who lost Chirpy Red.
+func (db *DB) ExpireSubscriptions(now time.Time) ([]int, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	var expired []int
+	for id, user := range db.data.Users {
+		sub := user.Subscription
+		if sub == nil || sub.Entitled(now) {
+			continue
+		}
+		if sub.Status != SubscriptionActive && sub.Status != SubscriptionPastDue {
+			continue
+		}
+		db.setSubscription(user, Subscription{
+			Plan:             sub.Plan,
+			Status:           SubscriptionExpired,
+			StartedAt:        sub.StartedAt,
+			CurrentPeriodEnd: sub.CurrentPeriodEnd,
+		}, BillingExpired, "", now.UTC())
+		expired = append(expired, id)
+	}
+	if len(expired) == 0 {
+		return nil, nil
+	}
+	sortSlice(expired, Asc, func(id int) int { return id })
+	return expired, db.writeDB()
+}
+
+// GetSubscriptionHistory returns the changes to userID's subscription, oldest
+// first.
+func (db *DB) GetSubscriptionHistory(userID int) []SubscriptionChange {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	history := make([]SubscriptionChange, len(db.data.SubscriptionHistory[userID]))
+	copy(history, db.data.SubscriptionHistory[userID])
+	return history
+}
+
+// setSubscription stores sub on user, keeps ChirpyRed in step with it and
+// records the change in the user's history.
+func (db *DB) setSubscription(user AuthUser, sub Subscription, event BillingEventType, eventID string, now time.Time) {
+	sub.UpdatedAt = now
+	user.Subscription = &sub
+	user.ChirpyRed = sub.Entitled(now)
+	db.data.Users[user.ID] = user
+	db.data.SubscriptionHistory[user.ID] = append(db.data.SubscriptionHistory[user.ID], SubscriptionChange{
+		Event:     event,
+		EventID:   eventID,
+		Plan:      sub.Plan,
+		Status:    sub.Status,
+		PeriodEnd: sub.CurrentPeriodEnd,
+		At:        now,
+	})
+}
@@ -0,0 +1,51 @@
+package database
+
+// Visibility is who a chirp's author shares it with.
+type Visibility string
+
+const (
+	// VisibilityPublic chirps are shown to everyone, everywhere.
+	VisibilityPublic Visibility = "public"
+	// VisibilityFollowers chirps are only shown to the author's followers.
+	VisibilityFollowers Visibility = "followers"
+	// VisibilityUnlisted chirps are shown to anyone who looks at them, on
+	// the author's profile or in timelines, but are left out of the global
+	// listing, hashtags, mentions, search and unfiltered streams.
+	VisibilityUnlisted Visibility = "unlisted"
+)
+
+func (v Visibility) Valid() bool {
+	return v == VisibilityPublic || v == VisibilityFollowers || v == VisibilityUnlisted
+}
+
+// EffectiveVisibility is the visibility c was posted with. Chirps from before
+// visibility existed are public.
+func (c Chirp) EffectiveVisibility() Visibility {
+	if c.Visibility == "" {
+		return VisibilityPublic
+	}
+	return c.Visibility
+}
+
+// inAudience reports whether viewerID, or an anonymous viewer if it's 0, is
+// someone c's author shares it with. Chirps that are followers-only, or by
+// protected accounts, are shared with the author's followers.
+func (db *DB) inAudience(viewerID int, c Chirp) bool {
+	if c.AuthorID == viewerID {
+		return true
+	}
+	if c.EffectiveVisibility() != VisibilityFollowers && !db.data.Users[c.AuthorID].Protected {
+		return true
+	}
+	_, follows := db.data.Follows[viewerID][c.AuthorID]
+	return viewerID != 0 && follows
+}
+
+// hiddenFromListings is hiddenFrom for listings that aren't of a particular
+// author's chirps, which leave out unlisted chirps too.
+func (db *DB) hiddenFromListings(viewerID int) func(Chirp) bool {
+	hidden := db.hiddenFrom(viewerID)
+	return func(c Chirp) bool {
+		return hidden(c) || c.EffectiveVisibility() == VisibilityUnlisted
+	}
+}
@@ -0,0 +1,234 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// visibilityFixture has chirps of each visibility by author, a public chirp by
+// a protected account, and users with different relationships to them. Every
+// chirp says "word", is tagged #tag and mentions stranger.
+type visibilityFixture struct {
+	db                                    *DB
+	author, locked, follower, stranger    User
+	public, followers, unlisted, lockedUp Chirp
+}
+
+func newVisibilityFixture(t *testing.T) visibilityFixture {
+	t.Helper()
+	db := newTestDB(t)
+	f := visibilityFixture{
+		db:       db,
+		author:   mustCreateUser(t, db, "author"),
+		locked:   mustCreateUser(t, db, "locked"),
+		follower: mustCreateUser(t, db, "follower"),
+		stranger: mustCreateUser(t, db, "stranger"),
+	}
+	if _, _, err := db.SetProtected(f.locked.ID, true); err != nil {
+		t.Fatalf("SetProtected: %s", err)
+	}
+	mustFollow(t, db, f.follower.ID, f.author.ID, Followed)
+	mustFollow(t, db, f.follower.ID, f.locked.ID, FollowRequested)
+	if err := db.ApproveFollowRequest(f.locked.ID, f.follower.ID); err != nil {
+		t.Fatalf("ApproveFollowRequest: %s", err)
+	}
+
+	f.public = f.mustChirp(t, f.author, VisibilityPublic)
+	f.followers = f.mustChirp(t, f.author, VisibilityFollowers)
+	f.unlisted = f.mustChirp(t, f.author, VisibilityUnlisted)
+	f.lockedUp = f.mustChirp(t, f.locked, VisibilityPublic)
+	return f
+}
+
+func (f visibilityFixture) mustChirp(t *testing.T, author User, v Visibility) Chirp {
+	t.Helper()
+	chirp, err := f.db.CreateChirp(Chirp{
+		AuthorID: author.ID,
+		Body:     "word #tag @stranger",
+		Entities: []Entity{
+			{Type: EntityHashtag, Text: "tag", Start: 5, End: 9},
+			{Type: EntityMention, Text: "stranger", UserID: f.stranger.ID, Start: 10, End: 19},
+		},
+		Visibility: v,
+		CreatedAt:  time.Now().UTC(),
+	})
+	if err != nil {
+		t.Fatalf("CreateChirp: %s", err)
+	}
+	return chirp
+}
+
+func mustFollow(t *testing.T, db *DB, followerID int, followeeID int, want FollowResult) {
+	t.Helper()
+	got, err := db.FollowUser(followerID, followeeID)
+	if err != nil {
+		t.Fatalf("FollowUser(%d, %d): %s", followerID, followeeID, err)
+	}
+	if got != want {
+		t.Fatalf("FollowUser(%d, %d) = %v, want %v", followerID, followeeID, got, want)
+	}
+}
+
+func chirpIDs(chirps []Chirp) []int {
+	ids := make([]int, len(chirps))
+	for i, c := range chirps {
+		ids[i] = c.ID
+	}
+	return ids
+}
+
+func assertIDs(t *testing.T, what string, chirps []Chirp, want ...Chirp) {
+	t.Helper()
+	if got, want := chirpIDs(chirps), chirpIDs(want); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("%s returned chirps %v, want %v", what, got, want)
+	}
+}
+
+func TestListingsRespectVisibility(t *testing.T) {
+	f := newVisibilityFixture(t)
+	tests := []struct {
+		name   string
+		viewer int
+		want   []Chirp
+	}{
+		{"anonymous", 0, []Chirp{f.public}},
+		{"stranger", f.stranger.ID, []Chirp{f.public}},
+		{"follower", f.follower.ID, []Chirp{f.lockedUp, f.followers, f.public}},
+		{"author", f.author.ID, []Chirp{f.followers, f.public}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			search := f.db.SearchChirps(tt.viewer, SearchQuery{Terms: []string{"word"}, Sort: SortRecent}, 0, 10)
+			assertIDs(t, "SearchChirps", search, tt.want...)
+			assertIDs(t, "GetChirpsByHashtag", f.db.GetChirpsByHashtag(tt.viewer, "tag", 0, 10), tt.want...)
+			mentions, err := f.db.GetChirpsMentioning(tt.viewer, f.stranger.ID, 0, 10)
+			if err != nil {
+				t.Fatalf("GetChirpsMentioning: %s", err)
+			}
+			assertIDs(t, "GetChirpsMentioning", mentions, tt.want...)
+		})
+	}
+}
+
+func TestTimelineRespectsVisibility(t *testing.T) {
+	f := newVisibilityFixture(t)
+	// Unlisted chirps are left out of listings but not timelines
+	assertIDs(t, "follower's GetTimeline", f.db.GetTimeline(f.follower.ID, 0, 10), f.lockedUp, f.unlisted, f.followers, f.public)
+
+	requester := mustCreateUser(t, f.db, "requester")
+	mustFollow(t, f.db, requester.ID, f.locked.ID, FollowRequested)
+	assertIDs(t, "requester's GetTimeline", f.db.GetTimeline(requester.ID, 0, 10))
+	if err := f.db.ApproveFollowRequest(f.locked.ID, requester.ID); err != nil {
+		t.Fatalf("ApproveFollowRequest: %s", err)
+	}
+	assertIDs(t, "approved requester's GetTimeline", f.db.GetTimeline(requester.ID, 0, 10), f.lockedUp)
+}
+
+func TestPinnedChirpsRespectVisibility(t *testing.T) {
+	f := newVisibilityFixture(t)
+	for _, c := range []Chirp{f.public, f.followers, f.unlisted} {
+		if err := f.db.PinChirp(f.author.ID, c.ID, 3); err != nil {
+			t.Fatalf("PinChirp: %s", err)
+		}
+	}
+	if err := f.db.PinChirp(f.locked.ID, f.lockedUp.ID, 3); err != nil {
+		t.Fatalf("PinChirp: %s", err)
+	}
+	assertIDs(t, "stranger's GetPinnedChirps", f.db.GetPinnedChirps(f.stranger.ID, f.author.ID), f.unlisted, f.public)
+	assertIDs(t, "follower's GetPinnedChirps", f.db.GetPinnedChirps(f.follower.ID, f.author.ID), f.unlisted, f.followers, f.public)
+	assertIDs(t, "stranger's GetPinnedChirps of protected account", f.db.GetPinnedChirps(f.stranger.ID, f.locked.ID))
+	assertIDs(t, "follower's GetPinnedChirps of protected account", f.db.GetPinnedChirps(f.follower.ID, f.locked.ID), f.lockedUp)
+}
+
+func TestChirpHistoryRespectsVisibility(t *testing.T) {
+	f := newVisibilityFixture(t)
+	edit := f.followers
+	edit.Body = "edited"
+	if _, err := f.db.EditChirp(f.followers.ID, edit); err != nil {
+		t.Fatalf("EditChirp: %s", err)
+	}
+	if _, err := f.db.GetChirpHistory(f.stranger.ID, f.followers.ID); err != ErrDoesNotExist {
+		t.Errorf("stranger's GetChirpHistory: got error %v, want ErrDoesNotExist", err)
+	}
+	if _, err := f.db.GetChirpHistory(0, f.lockedUp.ID); err != ErrDoesNotExist {
+		t.Errorf("anonymous GetChirpHistory of protected account: got error %v, want ErrDoesNotExist", err)
+	}
+	revisions, err := f.db.GetChirpHistory(f.follower.ID, f.followers.ID)
+	if err != nil || len(revisions) != 1 || revisions[0].Body != "word #tag @stranger" {
+		t.Errorf("follower's GetChirpHistory = %+v, %v; want the original version", revisions, err)
+	}
+}
+
+func TestPollVotesRespectVisibility(t *testing.T) {
+	f := newVisibilityFixture(t)
+	poll, err := f.db.CreateChirp(Chirp{
+		AuthorID:   f.author.ID,
+		Body:       "pick one",
+		Poll:       &Poll{Options: []string{"a", "b"}, ClosesAt: time.Now().Add(time.Hour)},
+		Visibility: VisibilityFollowers,
+		CreatedAt:  time.Now().UTC(),
+	})
+	if err != nil {
+		t.Fatalf("CreateChirp: %s", err)
+	}
+	if err := f.db.VoteInPoll(poll.ID, f.stranger.ID, 0); err != ErrDoesNotExist {
+		t.Errorf("stranger's vote: got error %v, want ErrDoesNotExist", err)
+	}
+	if err := f.db.VoteInPoll(poll.ID, f.follower.ID, 1); err != nil {
+		t.Errorf("follower's vote: %s", err)
+	}
+	results, err := f.db.GetPollResults(poll.ID, f.follower.ID)
+	if err != nil || results.Total != 1 || results.UserVote != 1 {
+		t.Errorf("GetPollResults = %+v, %v; want only the follower's vote", results, err)
+	}
+}
+
+func TestNotificationsRespectVisibility(t *testing.T) {
+	f := newVisibilityFixture(t)
+	if _, ok, err := f.db.Notify(f.stranger.ID, NotificationMention, f.author.ID, f.followers.ID); err != nil || ok {
+		t.Errorf("Notify of followers-only mention to stranger = %v, %v; want no notification", ok, err)
+	}
+	if _, ok, err := f.db.Notify(f.stranger.ID, NotificationMention, f.locked.ID, f.lockedUp.ID); err != nil || ok {
+		t.Errorf("Notify of protected account's mention to stranger = %v, %v; want no notification", ok, err)
+	}
+	if _, ok, err := f.db.Notify(f.stranger.ID, NotificationMention, f.author.ID, f.unlisted.ID); err != nil || !ok {
+		t.Errorf("Notify of unlisted mention to stranger = %v, %v; want a notification", ok, err)
+	}
+	if _, ok, err := f.db.Notify(f.follower.ID, NotificationMention, f.author.ID, f.followers.ID); err != nil || !ok {
+		t.Fatalf("Notify of followers-only mention to follower = %v, %v; want a notification", ok, err)
+	}
+	if notifications, unread := f.db.GetNotifications(f.follower.ID, 0, 10, false); len(notifications) != 1 || unread != 1 {
+		t.Fatalf("follower's GetNotifications returned %d, %d unread; want 1", len(notifications), unread)
+	}
+
+	// Once they stop following, the chirp's notification goes too
+	if _, err := f.db.UnfollowUser(f.follower.ID, f.author.ID); err != nil {
+		t.Fatalf("UnfollowUser: %s", err)
+	}
+	if notifications, unread := f.db.GetNotifications(f.follower.ID, 0, 10, false); len(notifications) != 0 || unread != 0 {
+		t.Errorf("former follower's GetNotifications returned %d, %d unread; want none", len(notifications), unread)
+	}
+}
+
+func TestFollowRequestNotificationsLastWhilePending(t *testing.T) {
+	f := newVisibilityFixture(t)
+	requester := mustCreateUser(t, f.db, "requester")
+	mustFollow(t, f.db, requester.ID, f.locked.ID, FollowRequested)
+	mustFollow(t, f.db, requester.ID, f.locked.ID, AlreadyRequested)
+	if _, ok, err := f.db.Notify(f.locked.ID, NotificationFollowRequest, requester.ID, 0); err != nil || !ok {
+		t.Fatalf("Notify of follow request = %v, %v; want a notification", ok, err)
+	}
+	if notifications, _ := f.db.GetNotifications(f.locked.ID, 0, 10, false); len(notifications) != 1 {
+		t.Fatalf("GetNotifications returned %d notifications, want 1", len(notifications))
+	}
+	if err := f.db.RejectFollowRequest(f.locked.ID, requester.ID); err != nil {
+		t.Fatalf("RejectFollowRequest: %s", err)
+	}
+	if notifications, unread := f.db.GetNotifications(f.locked.ID, 0, 10, false); len(notifications) != 0 || unread != 0 {
+		t.Errorf("GetNotifications after rejecting returned %d, %d unread; want none", len(notifications), unread)
+	}
+	if f.db.IsFollowing(requester.ID, f.locked.ID) {
+		t.Error("rejected requester is following")
+	}
+}
@@ -0,0 +1,126 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type WebhookOutcome string
+
+const (
+	WebhookPending   WebhookOutcome = "pending"
+	WebhookProcessed WebhookOutcome = "processed"
+	// WebhookIgnored events were valid but of a type we don't act on.
+	WebhookIgnored WebhookOutcome = "ignored"
+	WebhookFailed  WebhookOutcome = "failed"
+)
+
+// WebhookEvent is an event received from a webhook sender, keyed by the
+// sender's event ID, with the outcome of the last attempt to apply it.
+type WebhookEvent struct {
+	ID         string          `json:"id"`
+	Source     string          `json:"source"`
+	Type       string          `json:"type"`
+	Payload    json.RawMessage `json:"payload"`
+	ReceivedAt time.Time       `json:"received_at"`
+	Attempts   int             `json:"attempts"`
+	Outcome    WebhookOutcome  `json:"outcome"`
+	// StartedAt is when the latest attempt to apply the event began
+	StartedAt   time.Time  `json:"started_at"`
+	Error       string     `json:"error,omitempty"`
+	ProcessedAt *time.Time `json:"processed_at,omitempty"`
+}
+
+// BeginWebhookEvent logs a delivery of event at now and reports whether it
+// should be applied: true for new events, for retries of ones that failed,
+// and for ones whose last attempt began more than lease ago without
+// finishing, such as when we crashed part way through; false for duplicates
+// of events that were applied or are being applied right now.
+func (db *DB) BeginWebhookEvent(event WebhookEvent, now time.Time, lease time.Duration) (WebhookEvent, bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return WebhookEvent{}, false, err
+	}
+	existing, ok := db.data.WebhookEvents[event.ID]
+	if ok {
+		abandoned := existing.Outcome == WebhookPending && !now.Before(existing.StartedAt.Add(lease))
+		if existing.Outcome != WebhookFailed && !abandoned {
+			return existing, false, nil
+		}
+	} else {
+		event.ReceivedAt = now.UTC()
+		existing = event
+	}
+	existing.Attempts++
+	existing.Outcome = WebhookPending
+	existing.StartedAt = now.UTC()
+	db.data.WebhookEvents[event.ID] = existing
+	return existing, true, db.writeDB()
+}
+
+// ReplayWebhookEvent marks a logged event as being applied again, whatever
+// happened last time.
+func (db *DB) ReplayWebhookEvent(eventID string) (WebhookEvent, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+	event, ok := db.data.WebhookEvents[eventID]
+	if !ok {
+		return WebhookEvent{}, ErrDoesNotExist
+	}
+	event.Attempts++
+	event.Outcome = WebhookPending
+	event.StartedAt = time.Now().UTC()
+	db.data.WebhookEvents[eventID] = event
+	return event, db.writeDB()
+}
+
+// FinishWebhookEvent records the outcome of applying an event.
+func (db *DB) FinishWebhookEvent(eventID string, outcome WebhookOutcome, reason string) (WebhookEvent, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	err := db.loadDB()
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+	event, ok := db.data.WebhookEvents[eventID]
+	if !ok {
+		return WebhookEvent{}, ErrDoesNotExist
+	}
+	now := time.Now().UTC()
+	event.Outcome = outcome
+	event.Error = reason
+	event.ProcessedAt = &now
+	db.data.WebhookEvents[eventID] = event
+	return event, db.writeDB()
+}
+
+func (db *DB) GetWebhookEvent(eventID string) (WebhookEvent, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	event, ok := db.data.WebhookEvents[eventID]
+	if !ok {
+		return WebhookEvent{}, ErrDoesNotExist
+	}
+	return event, nil
+}
+
+// GetWebhookEvents returns the logged events, newest first, optionally only
+// those with outcome.
+func (db *DB) GetWebhookEvents(outcome WebhookOutcome) []WebhookEvent {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	events := make([]WebhookEvent, 0, len(db.data.WebhookEvents))
+	for _, e := range db.data.WebhookEvents {
+		if outcome == "" || e.Outcome == outcome {
+			events = append(events, e)
+		}
+	}
+	sortSlice(events, Desc, func(e WebhookEvent) int64 { return e.ReceivedAt.UnixNano() })
+	return events
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBeginWebhookEventLease(t *testing.T) {
+	db := newTestDB(t)
+	lease := time.Minute
+	start := time.Now()
+	event := WebhookEvent{ID: "evt_1", Source: "polka", Type: "user.upgraded"}
+
+	_, apply, err := db.BeginWebhookEvent(event, start, lease)
+	if err != nil || !apply {
+		t.Fatalf("first delivery: apply = %v, err = %v, want true", apply, err)
+	}
+	_, apply, err = db.BeginWebhookEvent(event, start.Add(lease/2), lease)
+	if err != nil || apply {
+		t.Fatalf("redelivery while being applied: apply = %v, err = %v, want false", apply, err)
+	}
+	// The first attempt never finished, so once its lease is up a
+	// redelivery applies it again
+	got, apply, err := db.BeginWebhookEvent(event, start.Add(lease), lease)
+	if err != nil || !apply {
+		t.Fatalf("redelivery after lease: apply = %v, err = %v, want true", apply, err)
+	}
+	if got.Attempts != 2 {
+		t.Errorf("attempts = %d, want 2", got.Attempts)
+	}
+
+	_, err = db.FinishWebhookEvent(event.ID, WebhookProcessed, "")
+	if err != nil {
+		t.Fatalf("FinishWebhookEvent: %s", err)
+	}
+	_, apply, err = db.BeginWebhookEvent(event, start.Add(time.Hour), lease)
+	if err != nil || apply {
+		t.Fatalf("redelivery of processed event: apply = %v, err = %v, want false", apply, err)
+	}
+}
+
+func TestApplyBillingEventOnce(t *testing.T) {
+	db := newTestDB(t)
+	user := mustCreateUser(t, db, "alice")
+	period := 30 * 24 * time.Hour
+	ev := BillingEvent{Type: BillingRenewed, EventID: "evt_1"}
+
+	applied, err := db.ApplyBillingEvent(user.ID, ev, period)
+	if err != nil || !applied {
+		t.Fatalf("first apply: applied = %v, err = %v, want true", applied, err)
+	}
+	first, err := db.GetUser(user.ID)
+	if err != nil {
+		t.Fatalf("GetUser: %s", err)
+	}
+	// A retry after a lost attempt must not extend the period again
+	applied, err = db.ApplyBillingEvent(user.ID, ev, period)
+	if err != nil || applied {
+		t.Fatalf("second apply: applied = %v, err = %v, want false", applied, err)
+	}
+	second, err := db.GetUser(user.ID)
+	if err != nil {
+		t.Fatalf("GetUser: %s", err)
+	}
+	if !second.Subscription.CurrentPeriodEnd.Equal(first.Subscription.CurrentPeriodEnd) {
+		t.Errorf("period end moved from %s to %s", first.Subscription.CurrentPeriodEnd, second.Subscription.CurrentPeriodEnd)
+	}
+}
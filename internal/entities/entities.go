@@ -0,0 +1,112 @@
+package entities
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type Type string
+
+const (
+	Mention Type = "mention"
+	Hashtag Type = "hashtag"
+)
+
+// Entity is a mention or hashtag found in a chirp body. Start and End are byte
+// offsets into the body, RuneStart and RuneEnd are the same span counted in
+// runes; both include the leading '@' or '#'. Text is the entity without its
+// sigil, lower-cased for hashtags.
+type Entity struct {
+	Type      Type
+	Text      string
+	Start     int
+	End       int
+	RuneStart int
+	RuneEnd   int
+}
+
+// Parse extracts @mentions and #hashtags from body, in the order they appear.
+// A sigil only starts an entity at the beginning of the body or after a
+// character that can't be part of a word, so "bob@example.com" is not a
+// mention and "C#" is not a hashtag.
+func Parse(body string) []Entity {
+	var found []Entity
+	prev := ' '
+	runeIdx := 0
+	for i := 0; i < len(body); {
+		r, size := utf8.DecodeRuneInString(body[i:])
+		if (r == '@' || r == '#') && !isWordRune(prev) {
+			var end int
+			if r == '@' {
+				end = scanMention(body, i+size)
+			} else {
+				end = scanHashtag(body, i+size)
+			}
+			if end > i+size {
+				text := body[i+size : end]
+				e := Entity{
+					Type:      Mention,
+					Text:      text,
+					Start:     i,
+					End:       end,
+					RuneStart: runeIdx,
+					RuneEnd:   runeIdx + utf8.RuneCountInString(body[i:end]),
+				}
+				if r == '#' {
+					e.Type = Hashtag
+					e.Text = NormalizeHashtag(text)
+				}
+				found = append(found, e)
+				runeIdx = e.RuneEnd
+				prev, _ = utf8.DecodeLastRuneInString(body[:end])
+				i = end
+				continue
+			}
+		}
+		prev = r
+		runeIdx++
+		i += size
+	}
+	return found
+}
+
+// NormalizeHashtag returns the canonical, case-insensitive form of a hashtag,
+// with or without its leading '#'.
+func NormalizeHashtag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(tag, "#"))
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// scanMention returns the end of a mention starting at start. Mentions are
+// either handles or email addresses, so trailing punctuation such as the
+// period ending a sentence is not included.
+func scanMention(body string, start int) int {
+	end := start
+	for end < len(body) {
+		c := body[end]
+		if !(c < utf8.RuneSelf && (isWordRune(rune(c)) || strings.IndexByte(".+-@", c) >= 0)) {
+			break
+		}
+		end++
+	}
+	for end > start && strings.IndexByte(".+-@", body[end-1]) >= 0 {
+		end--
+	}
+	return end
+}
+
+func scanHashtag(body string, start int) int {
+	end := start
+	for end < len(body) {
+		r, size := utf8.DecodeRuneInString(body[end:])
+		if !isWordRune(r) && !unicode.Is(unicode.Mn, r) {
+			break
+		}
+		end += size
+	}
+	return end
+}
@@ -0,0 +1,79 @@
+package entities
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []Entity
+	}{
+		{"none", "hello world", nil},
+		{"mention", "hi @bob", []Entity{
+			{Type: Mention, Text: "bob", Start: 3, End: 7, RuneStart: 3, RuneEnd: 7},
+		}},
+		{"hashtag lower-cased", "#GoLang", []Entity{
+			{Type: Hashtag, Text: "golang", Start: 0, End: 7, RuneStart: 0, RuneEnd: 7},
+		}},
+		{"in order", "#a @b #c", []Entity{
+			{Type: Hashtag, Text: "a", Start: 0, End: 2, RuneStart: 0, RuneEnd: 2},
+			{Type: Mention, Text: "b", Start: 3, End: 5, RuneStart: 3, RuneEnd: 5},
+			{Type: Hashtag, Text: "c", Start: 6, End: 8, RuneStart: 6, RuneEnd: 8},
+		}},
+		{"email is not a mention", "mail bob@example.com", nil},
+		{"email mention", "cc @bob@example.com.", []Entity{
+			{Type: Mention, Text: "bob@example.com", Start: 3, End: 19, RuneStart: 3, RuneEnd: 19},
+		}},
+		{"mention trailing punctuation", "thanks @bob_smith-jr.", []Entity{
+			{Type: Mention, Text: "bob_smith-jr", Start: 7, End: 20, RuneStart: 7, RuneEnd: 20},
+		}},
+		{"mention possessive", "@bob's", []Entity{
+			{Type: Mention, Text: "bob", Start: 0, End: 4, RuneStart: 0, RuneEnd: 4},
+		}},
+		{"in parentheses", "(@bob)", []Entity{
+			{Type: Mention, Text: "bob", Start: 1, End: 5, RuneStart: 1, RuneEnd: 5},
+		}},
+		{"sigil after word is not an entity", "C# and F#", nil},
+		{"adjacent hashtags", "#one#two", []Entity{
+			{Type: Hashtag, Text: "one", Start: 0, End: 4, RuneStart: 0, RuneEnd: 4},
+		}},
+		{"bare sigils", "@ # @. #!", nil},
+		{"rune offsets after multibyte text", "héllo #Café", []Entity{
+			{Type: Hashtag, Text: "café", Start: 7, End: 13, RuneStart: 6, RuneEnd: 11},
+		}},
+		{"non-latin hashtag", "#日本", []Entity{
+			{Type: Hashtag, Text: "日本", Start: 0, End: 7, RuneStart: 0, RuneEnd: 3},
+		}},
+		{"hashtag with combining mark", "#cafe\u0301 ok", []Entity{
+			{Type: Hashtag, Text: "cafe\u0301", Start: 0, End: 7, RuneStart: 0, RuneEnd: 6},
+		}},
+		{"mentions stop at non-ascii", "@bobé", []Entity{
+			{Type: Mention, Text: "bob", Start: 0, End: 4, RuneStart: 0, RuneEnd: 4},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Parse(tt.body); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeHashtag(t *testing.T) {
+	tests := []struct{ tag, want string }{
+		{"go", "go"},
+		{"#Go", "go"},
+		{"GoLang", "golang"},
+		{"#Ünïcode", "ünïcode"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := NormalizeHashtag(tt.tag); got != tt.want {
+			t.Errorf("NormalizeHashtag(%q) = %q, want %q", tt.tag, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package events
+
+import (
+	"hash/fnv"
+	"log"
+	"sync"
+)
+
+const (
+	// asyncShards is how many goroutines each asynchronous subscriber gets.
+	// Events for one aggregate always go to the same one.
+	asyncShards = 4
+	shardBuffer = 64
+)
+
+// Event is something that happened, published once it has been committed.
+type Event interface {
+	// Name is the event's type, like "chirp.created".
+	Name() string
+	// Aggregate identifies what the event is about, like "chirp:12".
+	Aggregate() string
+}
+
+// Bus delivers published events to subscribers in the same process.
+//
+// Synchronous subscribers run in the publishing goroutine, in the order they
+// subscribed, before Publish returns. Asynchronous subscribers run in the
+// background; each sees the events of a given aggregate in the order they
+// were published, but events for different aggregates may be handled
+// concurrently and in any order.
+type Bus struct {
+	mu     sync.RWMutex
+	subs   []*subscriber
+	closed bool
+	// publishing counts the Publish calls that are delivering events, which
+	// Close waits for before it closes the shards they send to
+	publishing sync.WaitGroup
+
+	pendingMu sync.Mutex
+	pending   int
+	idle      *sync.Cond
+	workers   sync.WaitGroup
+}
+
+type subscriber struct {
+	// accepts reports whether the subscriber handles an event, so events it
+	// doesn't want are never queued for it
+	accepts func(Event) bool
+	handle  func(Event)
+	// shards is nil for synchronous subscribers
+	shards []chan Event
+}
+
+func New() *Bus {
+	b := &Bus{}
+	b.idle = sync.NewCond(&b.pendingMu)
+	return b
+}
+
+// Subscribe calls fn synchronously with every published event of type E. Use
+// Event as E to receive every event.
+func Subscribe[E Event](b *Bus, fn func(E)) {
+	b.add(&subscriber{accepts: is[E], handle: typed(fn)})
+}
+
+// SubscribeAsync calls fn in the background with every published event of
+// type E.
+func SubscribeAsync[E Event](b *Bus, fn func(E)) {
+	s := &subscriber{accepts: is[E], handle: typed(fn), shards: make([]chan Event, asyncShards)}
+	for i := range s.shards {
+		s.shards[i] = make(chan Event, shardBuffer)
+		b.workers.Add(1)
+		go b.work(s, s.shards[i])
+	}
+	b.add(s)
+}
+
+// typed adapts fn to handle events of type E passed as any event.
+func typed[E Event](fn func(E)) func(Event) {
+	return func(e Event) {
+		fn(e.(E))
+	}
+}
+
+func is[E Event](e Event) bool {
+	_, ok := e.(E)
+	return ok
+}
+
+func (b *Bus) add(s *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs = append(b.subs, s)
+}
+
+// Publish delivers events to subscribers, in order. It blocks if an
+// asynchronous subscriber has fallen far behind. Events published after the
+// bus is closed are dropped.
+//
+// The lock is only held while taking a snapshot of the subscribers, not while
+// delivering, so that a subscriber that publishes events of its own can't
+// deadlock with Close waiting for the lock.
+func (b *Bus) Publish(events ...Event) {
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		return
+	}
+	subs := b.subs
+	b.publishing.Add(1)
+	b.mu.RUnlock()
+	defer b.publishing.Done()
+	for _, e := range events {
+		for _, s := range subs {
+			if !s.accepts(e) {
+				continue
+			}
+			if s.shards == nil {
+				safely(s.handle, e)
+				continue
+			}
+			b.pendingMu.Lock()
+			b.pending++
+			b.pendingMu.Unlock()
+			s.shards[shard(e.Aggregate())] <- e
+		}
+	}
+}
+
+func (b *Bus) work(s *subscriber, queue <-chan Event) {
+	defer b.workers.Done()
+	for e := range queue {
+		safely(s.handle, e)
+		b.pendingMu.Lock()
+		b.pending--
+		if b.pending == 0 {
+			b.idle.Broadcast()
+		}
+		b.pendingMu.Unlock()
+	}
+}
+
+// Flush waits until asynchronous subscribers have handled every event
+// published so far.
+func (b *Bus) Flush() {
+	b.pendingMu.Lock()
+	defer b.pendingMu.Unlock()
+	for b.pending > 0 {
+		b.idle.Wait()
+	}
+}
+
+// Close stops accepting events and waits for asynchronous subscribers to
+// handle the ones already published.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.closed = true
+	subs := b.subs
+	b.mu.Unlock()
+	// Publishers already delivering may be waiting for room in a shard,
+	// which the workers keep making until the shards are closed
+	b.publishing.Wait()
+	for _, s := range subs {
+		for _, q := range s.shards {
+			close(q)
+		}
+	}
+	b.workers.Wait()
+}
+
+// safely calls handle, logging rather than propagating a panic so one broken
+// subscriber can't take down the publisher or the other subscribers.
+func safely(handle func(Event), e Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("events: subscriber panicked handling %s for %s: %v", e.Name(), e.Aggregate(), r)
+		}
+	}()
+	handle(e)
+}
+
+func shard(aggregate string) int {
+	h := fnv.New32a()
+	h.Write([]byte(aggregate))
+	return int(h.Sum32() % asyncShards)
+}
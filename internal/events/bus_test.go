@@ -0,0 +1,145 @@
+package events
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type itemAdded struct{ ID, Seq int }
+
+func (e itemAdded) Name() string      { return "item.added" }
+func (e itemAdded) Aggregate() string { return fmt.Sprintf("item:%d", e.ID) }
+
+type itemRelayed struct{ ID, Seq int }
+
+func (e itemRelayed) Name() string      { return "item.relayed" }
+func (e itemRelayed) Aggregate() string { return fmt.Sprintf("item:%d", e.ID) }
+
+type itemCounted struct{ ID int }
+
+func (e itemCounted) Name() string      { return "item.counted" }
+func (e itemCounted) Aggregate() string { return fmt.Sprintf("item:%d", e.ID) }
+
+func TestSubscribeRunsInOrderBeforePublishReturns(t *testing.T) {
+	b := New()
+	defer b.Close()
+	var got []string
+	Subscribe(b, func(e itemAdded) { got = append(got, fmt.Sprintf("first %d", e.Seq)) })
+	Subscribe(b, func(e itemAdded) { got = append(got, fmt.Sprintf("second %d", e.Seq)) })
+	Subscribe(b, func(e itemRelayed) { got = append(got, "relayed") })
+
+	b.Publish(itemAdded{ID: 1, Seq: 1}, itemAdded{ID: 1, Seq: 2})
+
+	want := []string{"first 1", "second 1", "first 2", "second 2"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("handled %v, want %v", got, want)
+	}
+}
+
+func TestSubscribeAsyncKeepsAggregateOrder(t *testing.T) {
+	b := New()
+	defer b.Close()
+	var mu sync.Mutex
+	got := map[int][]int{}
+	SubscribeAsync(b, func(e itemAdded) {
+		mu.Lock()
+		defer mu.Unlock()
+		got[e.ID] = append(got[e.ID], e.Seq)
+	})
+
+	for seq := 0; seq < 200; seq++ {
+		b.Publish(itemAdded{ID: seq % 5, Seq: seq})
+	}
+	b.Flush()
+
+	mu.Lock()
+	defer mu.Unlock()
+	for id := 0; id < 5; id++ {
+		if len(got[id]) != 40 {
+			t.Fatalf("item %d: handled %d events, want 40", id, len(got[id]))
+		}
+		for i, seq := range got[id] {
+			if want := i*5 + id; seq != want {
+				t.Fatalf("item %d: event %d has seq %d, want %d", id, i, seq, want)
+			}
+		}
+	}
+}
+
+func TestPanickingSubscriberDoesNotStopOthers(t *testing.T) {
+	b := New()
+	defer b.Close()
+	Subscribe(b, func(itemAdded) { panic("broken") })
+	handled := 0
+	Subscribe(b, func(itemAdded) { handled++ })
+
+	b.Publish(itemAdded{ID: 1})
+
+	if handled != 1 {
+		t.Errorf("handled %d events, want 1", handled)
+	}
+}
+
+func TestPublishAfterCloseIsDropped(t *testing.T) {
+	b := New()
+	handled := 0
+	Subscribe(b, func(itemAdded) { handled++ })
+	b.Close()
+
+	b.Publish(itemAdded{ID: 1})
+	b.Close()
+
+	if handled != 0 {
+		t.Errorf("handled %d events after Close, want 0", handled)
+	}
+}
+
+// A subscriber that publishes while another publisher is waiting for room in
+// its shard must still be able to, even once Close has started; otherwise its
+// shard never drains and Close never returns.
+func TestCloseWhileSubscribersPublish(t *testing.T) {
+	const relays = 3 * shardBuffer
+	b := New()
+	gate := make(chan struct{})
+	var mu sync.Mutex
+	relayed := 0
+	SubscribeAsync(b, func(e itemAdded) {
+		batch := make([]Event, relays)
+		for seq := range batch {
+			batch[seq] = itemRelayed{ID: e.ID, Seq: seq}
+		}
+		b.Publish(batch...)
+	})
+	SubscribeAsync(b, func(e itemRelayed) {
+		<-gate
+		mu.Lock()
+		relayed++
+		mu.Unlock()
+		b.Publish(itemCounted{ID: e.ID})
+	})
+	Subscribe(b, func(itemCounted) {})
+
+	b.Publish(itemAdded{ID: 1})
+	// Let the relaying worker fill the gated shard and block on it
+	time.Sleep(50 * time.Millisecond)
+	closed := make(chan struct{})
+	go func() {
+		b.Close()
+		close(closed)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(gate)
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not return")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if relayed != relays {
+		t.Errorf("handled %d relayed events, want %d", relayed, relays)
+	}
+}
@@ -0,0 +1,63 @@
+package events
+
+import (
+	"sync"
+)
+
+// Recorder keeps every event published on a bus, so tests can assert what
+// was emitted.
+type Recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+// Record starts recording the events published on b.
+func Record(b *Bus) *Recorder {
+	r := &Recorder{}
+	Subscribe(b, func(e Event) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.events = append(r.events, e)
+	})
+	return r
+}
+
+// Events returns the recorded events in the order they were published.
+func (r *Recorder) Events() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := make([]Event, len(r.events))
+	copy(events, r.events)
+	return events
+}
+
+// Names returns the names of the recorded events, in order.
+func (r *Recorder) Names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, len(r.events))
+	for i, e := range r.events {
+		names[i] = e.Name()
+	}
+	return names
+}
+
+// Reset forgets the events recorded so far.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = nil
+}
+
+// Recorded returns the recorded events of type E, in order.
+func Recorded[E Event](r *Recorder) []E {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var events []E
+	for _, e := range r.events {
+		if te, ok := e.(E); ok {
+			events = append(events, te)
+		}
+	}
+	return events
+}
@@ -0,0 +1,172 @@
+package length
+
+import (
+	"unicode"
+)
+
+// This is a compact implementation of the extended grapheme cluster rules from
+// Unicode Standard Annex #29, enough to count what users perceive as
+// characters: combining marks, emoji modifier and ZWJ sequences, flags,
+// Hangul syllables and Indic conjuncts each count once. Property tables are
+// approximated with the unicode package and a few hard-coded ranges rather
+// than pulling in the full Unicode character database.
+
+type graphemeClass int
+
+const (
+	classOther graphemeClass = iota
+	classCR
+	classLF
+	classControl
+	classExtend
+	classZWJ
+	classRegionalIndicator
+	classSpacingMark
+	classPictographic
+	classHangulL
+	classHangulV
+	classHangulT
+	classHangulLV
+	classHangulLVT
+	classVirama
+)
+
+const (
+	hangulSBase  = 0xAC00
+	hangulSCount = 11172
+	hangulTCount = 28
+)
+
+func classify(r rune) graphemeClass {
+	switch {
+	case r == '\r':
+		return classCR
+	case r == '\n':
+		return classLF
+	case r == 0x200D:
+		return classZWJ
+	case r == 0x200C:
+		return classExtend
+	case unicode.IsControl(r) || r == 0x2028 || r == 0x2029:
+		return classControl
+	case r >= 0x1F1E6 && r <= 0x1F1FF:
+		return classRegionalIndicator
+	case r >= 0x1F3FB && r <= 0x1F3FF, // emoji skin tone modifiers
+		r >= 0xFE00 && r <= 0xFE0F,   // variation selectors
+		r >= 0xE0020 && r <= 0xE007F, // tags, used in subdivision flags
+		r >= 0xE0100 && r <= 0xE01EF:
+		return classExtend
+	case isVirama(r):
+		return classVirama
+	case unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Me, r):
+		return classExtend
+	case unicode.Is(unicode.Mc, r),
+		r == 0x0E33 || r == 0x0EB3: // Thai and Lao sara am
+		return classSpacingMark
+	case unicode.Is(unicode.Cf, r):
+		return classControl
+	case r >= 0x1100 && r <= 0x115F, r >= 0xA960 && r <= 0xA97C:
+		return classHangulL
+	case r >= 0x1160 && r <= 0x11A7, r >= 0xD7B0 && r <= 0xD7C6:
+		return classHangulV
+	case r >= 0x11A8 && r <= 0x11FF, r >= 0xD7CB && r <= 0xD7FB:
+		return classHangulT
+	case r >= hangulSBase && r < hangulSBase+hangulSCount:
+		if (r-hangulSBase)%hangulTCount == 0 {
+			return classHangulLV
+		}
+		return classHangulLVT
+	case isPictographic(r):
+		return classPictographic
+	}
+	return classOther
+}
+
+func isPictographic(r rune) bool {
+	switch {
+	case r == 0x00A9, r == 0x00AE, r == 0x203C, r == 0x2049, r == 0x2122, r == 0x2139,
+		r == 0x3030, r == 0x303D, r == 0x3297, r == 0x3299:
+		return true
+	case r >= 0x2194 && r <= 0x21AA,
+		r >= 0x2300 && r <= 0x23FF,
+		r >= 0x25A0 && r <= 0x27BF,
+		r >= 0x2900 && r <= 0x297F,
+		r >= 0x2B00 && r <= 0x2BFF,
+		r >= 0x1F000 && r <= 0x1FAFF:
+		return true
+	}
+	return false
+}
+
+// isVirama reports whether r is the virama of one of the major Brahmic
+// scripts, which joins the consonants either side of it into one conjunct.
+func isVirama(r rune) bool {
+	switch r {
+	case 0x094D, 0x09CD, 0x0A4D, 0x0ACD, 0x0B4D, 0x0BCD, 0x0C4D, 0x0CCD, 0x0D4D:
+		return true
+	}
+	return false
+}
+
+// Graphemes counts the extended grapheme clusters in s.
+func Graphemes(s string) int {
+	count := 0
+	var prev graphemeClass
+	var prevRune rune
+	// inPictographic is set while we're in an emoji sequence that a ZWJ may
+	// extend; riCount counts consecutive regional indicators, which pair up.
+	inPictographic := false
+	riCount := 0
+	for i, r := range s {
+		class := classify(r)
+		if i == 0 || isBoundary(prev, class, prevRune, r, inPictographic, riCount) {
+			count++
+		}
+		switch class {
+		case classPictographic:
+			inPictographic = true
+		case classExtend, classZWJ:
+		default:
+			inPictographic = false
+		}
+		if class == classRegionalIndicator {
+			riCount++
+		} else {
+			riCount = 0
+		}
+		prev, prevRune = class, r
+	}
+	return count
+}
+
+func isBoundary(prev, next graphemeClass, prevRune, nextRune rune, inPictographic bool, riCount int) bool {
+	switch {
+	case prev == classCR && next == classLF:
+		return false
+	case prev == classCR, prev == classLF, prev == classControl:
+		return true
+	case next == classCR, next == classLF, next == classControl:
+		return true
+	case prev == classHangulL && (next == classHangulL || next == classHangulV || next == classHangulLV || next == classHangulLVT):
+		return false
+	case (prev == classHangulLV || prev == classHangulV) && (next == classHangulV || next == classHangulT):
+		return false
+	case (prev == classHangulLVT || prev == classHangulT) && next == classHangulT:
+		return false
+	case next == classExtend, next == classZWJ, next == classSpacingMark, next == classVirama:
+		return false
+	case prev == classZWJ && next == classPictographic && inPictographic:
+		return false
+	case prev == classRegionalIndicator && next == classRegionalIndicator:
+		return riCount%2 == 0
+	case prev == classVirama && unicode.IsLetter(nextRune) && sameBlock(prevRune, nextRune):
+		return false
+	}
+	return true
+}
+
+// sameBlock reports whether two runes are in the same 128-code-point Brahmic
+// script block.
+func sameBlock(a, b rune) bool {
+	return a < 0x0E00 && a>>7 == b>>7
+}
@@ -0,0 +1,46 @@
+package length
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// URLWeight is how many characters a link counts as, however long it is,
+	// so that authors aren't penalised for long URLs.
+	URLWeight = 23
+)
+
+var (
+	urlPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+)
+
+// Chirp returns the length of a chirp body as users perceive it: grapheme
+// clusters, with every URL counted as URLWeight.
+func Chirp(body string) int {
+	n := 0
+	last := 0
+	for _, loc := range urlPattern.FindAllStringIndex(body, -1) {
+		n += text(body[last:loc[0]]) + URLWeight
+		last = loc[1]
+	}
+	return n + text(body[last:])
+}
+
+func text(s string) int {
+	// CRLF is the only ASCII sequence that forms a single grapheme
+	if isASCII(s) {
+		return len(s) - strings.Count(s, "\r\n")
+	}
+	return Graphemes(s)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
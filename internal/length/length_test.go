@@ -0,0 +1,90 @@
+package length
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGraphemes(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want int
+	}{
+		{"empty", "", 0},
+		{"ascii", "hello", 5},
+		{"crlf", "a\r\nb", 3},
+		{"lone cr and lf", "a\rb\nc", 5},
+		{"latin precomposed", "café", 4},
+		{"combining acute", "cafe\u0301", 4},
+		{"stacked combining marks", "a\u0323\u0301\u0308b", 2},
+		{"greek", "αβγ", 3},
+		{"cyrillic", "привет", 6},
+		{"arabic", "سلام", 4},
+		{"hebrew with points", "\u05e9\u05c1\u05b8", 1},
+		{"cjk", "中文字", 3},
+		{"hiragana", "ひらがな", 4},
+		{"hangul syllables", "한국어", 3},
+		{"hangul jamo lv", "\u1100\u1161", 1},
+		{"hangul jamo lvt", "\u1100\u1161\u11a8", 1},
+		{"hangul syllable with trailing jamo", "\uac00\u11a8", 1},
+		{"hangul leading jamo then syllable", "\u1100\uac00", 1},
+		{"devanagari with vowel sign", "\u0915\u093f", 1},
+		{"devanagari conjunct", "\u0915\u094d\u0937", 1},
+		{"devanagari word", "\u0928\u092e\u0938\u094d\u0924\u0947", 3},
+		{"thai with sara am", "\u0e01\u0e33", 1},
+		{"lao with sara am", "\u0e81\u0eb3", 1},
+		{"emoji", "😀", 1},
+		{"emoji run", "😀😀😀", 3},
+		{"emoji with variation selector", "\u2764\ufe0f", 1},
+		{"skin tone modifier", "\U0001f44d\U0001f3fd", 1},
+		{"skin tones", "\U0001f44d\U0001f3fb\U0001f44d\U0001f3ff", 2},
+		{"zwj family", "\U0001f468\u200d\U0001f469\u200d\U0001f467\u200d\U0001f466", 1},
+		{"zwj profession with skin tone", "\U0001f469\U0001f3fd\u200d\U0001f4bb", 1},
+		{"zwj rainbow flag", "\U0001f3f3\ufe0f\u200d\U0001f308", 1},
+		{"flag", "🇺🇸", 1},
+		{"two flags", "🇺🇸🇫🇷", 2},
+		{"odd regional indicators", "🇺🇸🇫", 2},
+		{"subdivision flag", "🏴\U000E0067\U000E0062\U000E0073\U000E0063\U000E0074\U000E007F", 1},
+		{"keycap", "1\ufe0f\u20e3", 1},
+		{"keycaps", "#\ufe0f\u20e31\ufe0f\u20e3", 2},
+		{"zwj between letters", "a\u200db", 2},
+		{"mixed scripts and emoji", "hi \U0001f44b\U0001f3fc \u4e16\u754c \U0001f1ef\U0001f1f5", 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Graphemes(tt.s); got != tt.want {
+				t.Errorf("Graphemes(%q) = %d, want %d", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChirp(t *testing.T) {
+	long := "https://example.com/" + strings.Repeat("a", 100)
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"plain", "hello world", 11},
+		{"url", "https://example.com", URLWeight},
+		{"long url", long, URLWeight},
+		{"text and url", "see " + long + " now", 4 + URLWeight + 4},
+		{"www url", "www.example.com/path", URLWeight},
+		{"two urls", "http://a.io http://b.io", 2*URLWeight + 1},
+		{"url case insensitive", "HTTPS://EXAMPLE.COM", URLWeight},
+		{"not a url", "example.com", 11},
+		{"url ends at whitespace", "http://a.io\tx", URLWeight + 2},
+		{"emoji and url", "\U0001f468\u200d\U0001f469\u200d\U0001f467 http://a.io \U0001f1fa\U0001f1f8", 1 + 1 + URLWeight + 1 + 1},
+		{"combining marks", "e\u0301e\u0301", 2},
+		{"crlf", "a\r\nb", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Chirp(tt.body); got != tt.want {
+				t.Errorf("Chirp(%q) = %d, want %d", tt.body, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+const (
+	// MaxPixels guards against decompression bombs: small files that decode to
+	// enormous images.
+	MaxPixels = 40_000_000
+
+	ThumbnailSize = 320
+	jpegQuality   = 85
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported image type")
+	ErrInvalidImage    = errors.New("invalid image")
+	ErrTooManyPixels   = errors.New("image dimensions too large")
+)
+
+// Image is an uploaded image after processing. Data has been re-encoded from
+// the decoded pixels, so metadata such as EXIF location is gone.
+type Image struct {
+	ContentType string
+	Data        []byte
+	Width       int
+	Height      int
+	Thumbnail   Thumbnail
+}
+
+type Thumbnail struct {
+	ContentType string
+	Data        []byte
+	Width       int
+	Height      int
+}
+
+// Process validates an uploaded JPEG, PNG or GIF, strips its metadata and
+// generates a thumbnail. JPEG orientation from EXIF is applied to the pixels
+// before the EXIF data is dropped, so photos stay the right way up.
+func Process(data []byte) (Image, error) {
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return Image{}, ErrUnsupportedType
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, ErrInvalidImage
+	}
+	if cfg.Width*cfg.Height > MaxPixels {
+		return Image{}, ErrTooManyPixels
+	}
+
+	var img Image
+	var first image.Image
+	switch contentType {
+	case "image/gif":
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return Image{}, ErrInvalidImage
+		}
+		// Re-encoding keeps the frames and timing but drops comments and
+		// application extensions other than looping.
+		var buf bytes.Buffer
+		err = gif.EncodeAll(&buf, g)
+		if err != nil {
+			return Image{}, err
+		}
+		img.Data = buf.Bytes()
+		first = g.Image[0]
+	case "image/png":
+		src, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return Image{}, ErrInvalidImage
+		}
+		img.Data, err = encodePNG(src)
+		if err != nil {
+			return Image{}, err
+		}
+		first = src
+	case "image/jpeg":
+		src, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return Image{}, ErrInvalidImage
+		}
+		src = orient(src, jpegOrientation(data))
+		img.Data, err = encodeJPEG(src)
+		if err != nil {
+			return Image{}, err
+		}
+		first = src
+	}
+	img.ContentType = contentType
+	img.Width = first.Bounds().Dx()
+	img.Height = first.Bounds().Dy()
+
+	thumb := resize(first, ThumbnailSize)
+	img.Thumbnail.Width = thumb.Bounds().Dx()
+	img.Thumbnail.Height = thumb.Bounds().Dy()
+	if contentType == "image/jpeg" {
+		img.Thumbnail.ContentType = "image/jpeg"
+		img.Thumbnail.Data, err = encodeJPEG(thumb)
+	} else {
+		img.Thumbnail.ContentType = "image/png"
+		img.Thumbnail.Data, err = encodePNG(thumb)
+	}
+	if err != nil {
+		return Image{}, err
+	}
+	return img, nil
+}
+
+func encodeJPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	return buf.Bytes(), err
+}
+
+func encodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	return buf.Bytes(), err
+}
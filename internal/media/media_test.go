@@ -0,0 +1,246 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// gradient returns a w×h image whose pixels are all different, so that
+// orientation changes can be seen.
+func gradient(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 255 / w), G: uint8(y * 255 / h), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func encode(t *testing.T, img image.Image, format string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withEXIFOrientation inserts an EXIF segment with the given orientation after
+// a JPEG's start of image marker.
+func withEXIFOrientation(jpg []byte, orientation int, bigEndian bool) []byte {
+	var order binary.AppendByteOrder = binary.LittleEndian
+	tiff := []byte("II")
+	if bigEndian {
+		order = binary.BigEndian
+		tiff = []byte("MM")
+	}
+	tiff = order.AppendUint16(tiff, 42)
+	tiff = order.AppendUint32(tiff, 8)
+	tiff = order.AppendUint16(tiff, 1) // one IFD entry
+	tiff = order.AppendUint16(tiff, 0x0112)
+	tiff = order.AppendUint16(tiff, 3) // SHORT
+	tiff = order.AppendUint32(tiff, 1)
+	tiff = order.AppendUint16(tiff, uint16(orientation))
+	tiff = order.AppendUint16(tiff, 0)
+	tiff = order.AppendUint32(tiff, 0) // no next IFD
+	seg := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := binary.BigEndian.AppendUint16([]byte{0xFF, 0xE1}, uint16(len(seg)+2))
+	app1 = append(app1, seg...)
+	return append(append(append([]byte{}, jpg[:2]...), app1...), jpg[2:]...)
+}
+
+func TestProcessPNG(t *testing.T) {
+	img, err := Process(encode(t, gradient(640, 200), "png"))
+	if err != nil {
+		t.Fatalf("Process: %s", err)
+	}
+	if img.ContentType != "image/png" || img.Width != 640 || img.Height != 200 {
+		t.Errorf("got %s %dx%d, want image/png 640x200", img.ContentType, img.Width, img.Height)
+	}
+	th := img.Thumbnail
+	if th.ContentType != "image/png" || th.Width != ThumbnailSize || th.Height != 100 {
+		t.Errorf("thumbnail is %s %dx%d, want image/png %dx100", th.ContentType, th.Width, th.Height, ThumbnailSize)
+	}
+	decoded, err := png.Decode(bytes.NewReader(th.Data))
+	if err != nil || decoded.Bounds().Dx() != th.Width || decoded.Bounds().Dy() != th.Height {
+		t.Errorf("thumbnail data doesn't decode to its size: %v", err)
+	}
+}
+
+func TestProcessJPEGAppliesOrientation(t *testing.T) {
+	for _, bigEndian := range []bool{false, true} {
+		data := withEXIFOrientation(encode(t, gradient(60, 20), "jpeg"), 6, bigEndian)
+		img, err := Process(data)
+		if err != nil {
+			t.Fatalf("Process: %s", err)
+		}
+		if img.ContentType != "image/jpeg" || img.Width != 20 || img.Height != 60 {
+			t.Errorf("big endian %v: got %s %dx%d, want a rotated image/jpeg 20x60", bigEndian, img.ContentType, img.Width, img.Height)
+		}
+		if bytes.Contains(img.Data, []byte("Exif")) {
+			t.Errorf("big endian %v: EXIF data was kept", bigEndian)
+		}
+		if img.Thumbnail.ContentType != "image/jpeg" {
+			t.Errorf("big endian %v: thumbnail is %s, want image/jpeg", bigEndian, img.Thumbnail.ContentType)
+		}
+	}
+}
+
+func TestProcessGIFKeepsFrames(t *testing.T) {
+	palette := color.Palette{color.Black, color.White}
+	anim := &gif.GIF{LoopCount: 0}
+	for i := 0; i < 3; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 400, 400), palette)
+		frame.SetColorIndex(i, i, 1)
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	img, err := Process(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Process: %s", err)
+	}
+	out, err := gif.DecodeAll(bytes.NewReader(img.Data))
+	if err != nil {
+		t.Fatalf("decoding processed GIF: %s", err)
+	}
+	if len(out.Image) != 3 || out.Delay[2] != 10 {
+		t.Errorf("processed GIF has %d frames, want 3 with their delays", len(out.Image))
+	}
+	if img.Thumbnail.ContentType != "image/png" || img.Thumbnail.Width != ThumbnailSize {
+		t.Errorf("thumbnail is %s %dpx wide", img.Thumbnail.ContentType, img.Thumbnail.Width)
+	}
+}
+
+func TestProcessRejects(t *testing.T) {
+	// A PNG header claiming dimensions too large to decode, with a valid
+	// checksum so only the size is wrong
+	huge := encode(t, gradient(1, 1), "png")
+	binary.BigEndian.PutUint32(huge[16:], 10000)
+	binary.BigEndian.PutUint32(huge[20:], 10000)
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+
+	truncated := encode(t, gradient(50, 50), "png")
+	truncated = truncated[:len(truncated)/2]
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"text", []byte("hello, world"), ErrUnsupportedType},
+		{"empty", nil, ErrUnsupportedType},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), ErrUnsupportedType},
+		{"png signature only", []byte("\x89PNG\r\n\x1a\n garbage"), ErrInvalidImage},
+		{"truncated png", truncated, ErrInvalidImage},
+		{"too many pixels", huge, ErrTooManyPixels},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Process(tt.data); !errors.Is(err, tt.want) {
+				t.Errorf("Process: got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestResize(t *testing.T) {
+	tests := []struct {
+		w, h, maxSide int
+		wantW, wantH  int
+	}{
+		{100, 50, 320, 100, 50},
+		{640, 480, 320, 320, 240},
+		{480, 640, 320, 240, 320},
+		{1000, 1, 320, 320, 1},
+		{320, 320, 320, 320, 320},
+	}
+	for _, tt := range tests {
+		got := resize(gradient(tt.w, tt.h), tt.maxSide).Bounds()
+		if got.Dx() != tt.wantW || got.Dy() != tt.wantH {
+			t.Errorf("resize(%dx%d, %d) is %dx%d, want %dx%d", tt.w, tt.h, tt.maxSide, got.Dx(), got.Dy(), tt.wantW, tt.wantH)
+		}
+	}
+
+	// Each pixel is the average of the ones it covers
+	checker := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	checker.Set(0, 0, color.White)
+	checker.Set(1, 1, color.White)
+	checker.Set(1, 0, color.Black)
+	checker.Set(0, 1, color.Black)
+	r, g, b, a := resize(checker, 1).At(0, 0).RGBA()
+	if r != 0x7f7f || g != 0x7f7f || b != 0x7f7f || a != 0xffff {
+		t.Errorf("resized checkerboard is %x %x %x %x, want mid grey", r, g, b, a)
+	}
+}
+
+func TestOrient(t *testing.T) {
+	src := gradient(3, 2)
+	topLeft := src.At(0, 0)
+	tests := []struct {
+		orientation int
+		w, h        int
+		x, y        int // where the top-left source pixel ends up
+	}{
+		{1, 3, 2, 0, 0},
+		{2, 3, 2, 2, 0},
+		{3, 3, 2, 2, 1},
+		{4, 3, 2, 0, 1},
+		{5, 2, 3, 0, 0},
+		{6, 2, 3, 1, 0},
+		{7, 2, 3, 1, 2},
+		{8, 2, 3, 0, 2},
+		{9, 3, 2, 0, 0},
+	}
+	for _, tt := range tests {
+		dst := orient(src, tt.orientation)
+		if b := dst.Bounds(); b.Dx() != tt.w || b.Dy() != tt.h {
+			t.Errorf("orientation %d: got %dx%d, want %dx%d", tt.orientation, b.Dx(), b.Dy(), tt.w, tt.h)
+			continue
+		}
+		if got := color.RGBAModel.Convert(dst.At(tt.x, tt.y)); got != topLeft {
+			t.Errorf("orientation %d: pixel at %d,%d is %v, want the top-left source pixel %v", tt.orientation, tt.x, tt.y, got, topLeft)
+		}
+	}
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	plain := encode(t, gradient(4, 4), "jpeg")
+	if got := jpegOrientation(plain); got != 1 {
+		t.Errorf("without EXIF: got %d, want 1", got)
+	}
+	for o := 1; o <= 8; o++ {
+		if got := jpegOrientation(withEXIFOrientation(plain, o, true)); got != o {
+			t.Errorf("got orientation %d, want %d", got, o)
+		}
+	}
+	// Truncated and malformed segments mustn't be read past their end
+	withEXIF := withEXIFOrientation(plain, 6, false)
+	for n := 0; n < 40; n++ {
+		jpegOrientation(withEXIF[:n])
+	}
+	bad := withEXIFOrientation(plain, 6, false)
+	binary.LittleEndian.PutUint32(bad[2+4+6+4:], 0xFFFFFF)
+	if got := jpegOrientation(bad); got != 1 {
+		t.Errorf("with an IFD offset out of range: got %d, want 1", got)
+	}
+}
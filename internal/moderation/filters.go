@@ -0,0 +1,170 @@
+package moderation
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+
+	// strokeFolds maps the Latin letters whose marks are part of the letter,
+	// so Unicode doesn't decompose them, to their base letter. The keys are
+	// case folded.
+	strokeFolds = map[rune]rune{
+		'đ': 'd',
+		'ħ': 'h',
+		'ı': 'i',
+		'ł': 'l',
+		'ø': 'o',
+		'ŧ': 't',
+	}
+)
+
+// normalize reduces s to a canonical form for matching. It applies Unicode
+// compatibility decomposition (NFKD), which maps full-width, stylized and
+// ligature forms to plain letters and separates accents from their letters,
+// then case folds, and drops the accents along with invisible formatting
+// characters.
+//
+// Letters from other scripts that merely look like Latin ones, such as
+// Cyrillic "е", are left alone, so they still get past a WordFilter.
+func normalize(s string) string {
+	var b strings.Builder
+	for _, r := range cases.Fold().String(norm.NFKD.String(s)) {
+		if unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Cf, r) {
+			continue
+		}
+		if base, ok := strokeFolds[r]; ok {
+			r = base
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Cf, r)
+}
+
+// WordFilter matches whole words from a list, ignoring case, accents and any
+// punctuation around the word. Words are compared after normalize.
+type WordFilter struct {
+	words  map[string]struct{}
+	action Action
+	reason string
+}
+
+func NewWordFilter(words []string, action Action) *WordFilter {
+	wf := &WordFilter{
+		words:  make(map[string]struct{}, len(words)),
+		action: action,
+		reason: "contains a prohibited word",
+	}
+	for _, w := range words {
+		wf.words[normalize(w)] = struct{}{}
+	}
+	return wf
+}
+
+func (wf *WordFilter) Check(body string) []Finding {
+	var findings []Finding
+	start := -1
+	for i := 0; i <= len(body); {
+		r, size := utf8.DecodeRuneInString(body[i:])
+		inWord := i < len(body) && isWordRune(r)
+		if inWord && start < 0 {
+			start = i
+		}
+		if !inWord && start >= 0 {
+			if _, ok := wf.words[normalize(body[start:i])]; ok {
+				findings = append(findings, Finding{Action: wf.action, Reason: wf.reason, Start: start, End: i})
+			}
+			start = -1
+		}
+		if i == len(body) {
+			break
+		}
+		i += size
+	}
+	return findings
+}
+
+// RegexFilter matches a regular expression against the raw body.
+type RegexFilter struct {
+	pattern *regexp.Regexp
+	action  Action
+	reason  string
+}
+
+func NewRegexFilter(pattern string, action Action) (*RegexFilter, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &RegexFilter{pattern: re, action: action, reason: "matches a prohibited pattern"}, nil
+}
+
+func (rf *RegexFilter) Check(body string) []Finding {
+	var findings []Finding
+	for _, loc := range rf.pattern.FindAllStringIndex(body, -1) {
+		findings = append(findings, Finding{Action: rf.action, Reason: rf.reason, Start: loc[0], End: loc[1]})
+	}
+	return findings
+}
+
+// LinkFilter matches links, either all of them or only those to the given
+// domains and their subdomains.
+type LinkFilter struct {
+	domains []string
+	action  Action
+}
+
+func NewLinkFilter(domains []string, action Action) *LinkFilter {
+	lf := &LinkFilter{action: action}
+	for _, d := range domains {
+		lf.domains = append(lf.domains, strings.ToLower(d))
+	}
+	return lf
+}
+
+func (lf *LinkFilter) Check(body string) []Finding {
+	var findings []Finding
+	for _, loc := range linkPattern.FindAllStringIndex(body, -1) {
+		host := linkHost(body[loc[0]:loc[1]])
+		reason, ok := lf.matches(host)
+		if ok {
+			findings = append(findings, Finding{Action: lf.action, Reason: reason, Start: loc[0], End: loc[1]})
+		}
+	}
+	return findings
+}
+
+func (lf *LinkFilter) matches(host string) (string, bool) {
+	if len(lf.domains) == 0 {
+		return "links are not allowed", true
+	}
+	for _, d := range lf.domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return "links to " + d + " are not allowed", true
+		}
+	}
+	return "", false
+}
+
+func linkHost(link string) string {
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
@@ -0,0 +1,137 @@
+package moderation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Action is what happens to a chirp that trips a filter. Actions are ordered by
+// severity, and a chirp gets the most severe action of all its findings.
+type Action int
+
+const (
+	Allow Action = iota
+	// Mask replaces the offending span of the body with maskText.
+	Mask
+	// Flag publishes the chirp as it is, and queues it for admin review.
+	Flag
+	// Reject refuses the chirp, telling the author why.
+	Reject
+)
+
+const (
+	maskText = "****"
+)
+
+func (a Action) String() string {
+	switch a {
+	case Mask:
+		return "mask"
+	case Flag:
+		return "flag"
+	case Reject:
+		return "reject"
+	}
+	return "allow"
+}
+
+func ParseAction(s string) (Action, error) {
+	switch s {
+	case "mask":
+		return Mask, nil
+	case "flag":
+		return Flag, nil
+	case "reject":
+		return Reject, nil
+	}
+	return Allow, fmt.Errorf("unknown moderation action: %s", s)
+}
+
+// Finding is a span of a chirp body that a filter objected to. Start and End
+// are byte offsets.
+type Finding struct {
+	Action Action
+	Reason string
+	Start  int
+	End    int
+}
+
+// Filter inspects a chirp body. Filters are independent of each other and
+// are combined by a Pipeline.
+type Filter interface {
+	Check(body string) []Finding
+}
+
+// Result is the outcome of moderating a chirp. Body has any masked spans
+// replaced; Reasons holds the reasons for the flag or rejection, if any.
+type Result struct {
+	Action  Action
+	Body    string
+	Reasons []string
+}
+
+type Pipeline struct {
+	filters []Filter
+}
+
+func NewPipeline(filters ...Filter) *Pipeline {
+	return &Pipeline{filters: filters}
+}
+
+// Moderate runs body through every filter. Masking is only applied when the
+// chirp is not also rejected.
+func (p *Pipeline) Moderate(body string) Result {
+	var findings []Finding
+	for _, f := range p.filters {
+		findings = append(findings, f.Check(body)...)
+	}
+	result := Result{Action: Allow, Body: body}
+	var masks []Finding
+	for _, f := range findings {
+		result.Action = max(result.Action, f.Action)
+		if f.Action == Mask {
+			masks = append(masks, f)
+		}
+	}
+	for _, f := range findings {
+		if f.Action == result.Action && f.Action != Mask && !contains(result.Reasons, f.Reason) {
+			result.Reasons = append(result.Reasons, f.Reason)
+		}
+	}
+	if result.Action != Reject {
+		result.Body = applyMasks(body, masks)
+	}
+	return result
+}
+
+// applyMasks replaces each masked span with maskText, merging spans that
+// overlap.
+func applyMasks(body string, masks []Finding) string {
+	if len(masks) == 0 {
+		return body
+	}
+	sort.Slice(masks, func(i, j int) bool { return masks[i].Start < masks[j].Start })
+	var b strings.Builder
+	last := 0
+	for _, m := range masks {
+		if m.Start < last {
+			last = max(last, m.End)
+			continue
+		}
+		b.WriteString(body[last:m.Start])
+		b.WriteString(maskText)
+		last = m.End
+	}
+	b.WriteString(body[last:])
+	return b.String()
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
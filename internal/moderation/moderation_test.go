@@ -0,0 +1,201 @@
+package moderation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWordFilter(t *testing.T) {
+	wf := NewWordFilter([]string{"kerfuffle", "Strasse", "lodz"}, Mask)
+	tests := []struct {
+		name string
+		body string
+		want [][2]int
+	}{
+		{"plain", "what a kerfuffle", [][2]int{{7, 16}}},
+		{"punctuation", "Kerfuffle!", [][2]int{{0, 9}}},
+		{"wrapped in punctuation", "(kerfuffle), \"kerfuffle\"", [][2]int{{1, 10}, {14, 23}}},
+		{"upper case", "KERFUFFLE", [][2]int{{0, 9}}},
+		{"precomposed accent", "kérfuffle", [][2]int{{0, 10}}},
+		{"combining accent", "ke\u0301rfuffle", [][2]int{{0, 11}}},
+		{"zero-width space", "ker\u200bfuffle", [][2]int{{0, 12}}},
+		{"full-width", "\uff4b\uff45\uff52\uff46\uff55\uff46\uff46\uff4c\uff45", [][2]int{{0, 27}}},
+		{"ligature", "kerfu\ufb00le", [][2]int{{0, 10}}},
+		{"mathematical letters", "\U0001d424\U0001d41e\U0001d42b\U0001d41f\U0001d42e\U0001d41f\U0001d41f\U0001d425\U0001d41e", [][2]int{{0, 36}}},
+		{"letter with stroke", "Łódź", [][2]int{{0, 7}}},
+		{"case folded", "STRAßE", [][2]int{{0, 7}}},
+		{"part of a longer word", "kerfuffles kerfuffle2 kerfufflé2", nil},
+		{"other scripts are not folded", "k\u0435rfuffle", nil},
+		{"none", "all quiet", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got [][2]int
+			for _, f := range wf.Check(tt.body) {
+				if f.Action != Mask {
+					t.Errorf("finding has action %s, want mask", f.Action)
+				}
+				got = append(got, [2]int{f.Start, f.End})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check(%q) found %v, want %v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegexFilter(t *testing.T) {
+	rf, err := NewRegexFilter(`(?i)buy\s+now`, Reject)
+	if err != nil {
+		t.Fatalf("NewRegexFilter: %s", err)
+	}
+	findings := rf.Check("BUY  now, or buy now")
+	if len(findings) != 2 || findings[0].Start != 0 || findings[0].End != 8 || findings[1].Start != 13 {
+		t.Errorf("Check found %+v, want matches at 0 and 13", findings)
+	}
+	if _, err := NewRegexFilter(`(unclosed`, Reject); err == nil {
+		t.Error("NewRegexFilter accepted an invalid pattern")
+	}
+}
+
+func TestLinkFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		domains []string
+		body    string
+		want    []string
+	}{
+		{"any link", nil, "see https://example.com/a and www.other.org", []string{"https://example.com/a", "www.other.org"}},
+		{"no links", nil, "example.com is not a link", nil},
+		{"domain", []string{"Spam.com"}, "http://spam.com/x https://ok.com", []string{"http://spam.com/x"}},
+		{"subdomain", []string{"spam.com"}, "HTTPS://WWW.SPAM.COM/x", []string{"HTTPS://WWW.SPAM.COM/x"}},
+		{"lookalike domain", []string{"spam.com"}, "http://notspam.com http://spam.com.evil.io", nil},
+		{"port and credentials", []string{"spam.com"}, "http://user@spam.com:8080/", []string{"http://user@spam.com:8080/"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, f := range NewLinkFilter(tt.domains, Flag).Check(tt.body) {
+				got = append(got, tt.body[f.Start:f.End])
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check(%q) found %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	mask := NewWordFilter([]string{"kerfuffle", "sharbert"}, Mask)
+	flag := NewWordFilter([]string{"sketchy"}, Flag)
+	links := NewLinkFilter(nil, Flag)
+	reject := NewWordFilter([]string{"forbidden"}, Reject)
+	overlap, err := NewRegexFilter(`fuffle sharb`, Mask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewPipeline(mask, flag, links, reject, overlap)
+	tests := []struct {
+		name string
+		body string
+		want Result
+	}{
+		{"allowed", "hello", Result{Action: Allow, Body: "hello"}},
+		{"masked", "What a Kerfuffle!", Result{Action: Mask, Body: "What a ****!"}},
+		{"overlapping masks merge", "kerfuffle sharbert end", Result{Action: Mask, Body: "**** end"}},
+		{"flagged is published as written", "a sketchy kerfuffle", Result{
+			Action:  Flag,
+			Body:    "a sketchy ****",
+			Reasons: []string{"contains a prohibited word"},
+		}},
+		{"reasons of the winning action, once each", "sketchy sketchy http://x.io", Result{
+			Action:  Flag,
+			Body:    "sketchy sketchy http://x.io",
+			Reasons: []string{"contains a prohibited word", "links are not allowed"},
+		}},
+		{"rejected is not masked", "forbidden sketchy kerfuffle", Result{
+			Action:  Reject,
+			Body:    "forbidden sketchy kerfuffle",
+			Reasons: []string{"contains a prohibited word"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.Moderate(tt.body); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Moderate(%q) = %+v, want %+v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	p, err := ParseRules(strings.NewReader(`
+# comment
+mask word kerfuffle
+flag link *
+flag link spam.com
+reject link evil.com
+reject regex buy\s+now  please
+`))
+	if err != nil {
+		t.Fatalf("ParseRules: %s", err)
+	}
+	tests := []struct {
+		body   string
+		action Action
+		result string
+	}{
+		{"a kerfuffle.", Mask, "a ****."},
+		{"http://example.com", Flag, "http://example.com"},
+		{"http://a.spam.com", Flag, "http://a.spam.com"},
+		{"http://evil.com", Reject, "http://evil.com"},
+		{"buy   now  please", Reject, "buy   now  please"},
+		{"buy now", Allow, "buy now"},
+	}
+	for _, tt := range tests {
+		if got := p.Moderate(tt.body); got.Action != tt.action || got.Body != tt.result {
+			t.Errorf("Moderate(%q) = %s %q, want %s %q", tt.body, got.Action, got.Body, tt.action, tt.result)
+		}
+	}
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	tests := []struct {
+		rules string
+		want  string
+	}{
+		{"mask word", "line 1: expected '<action> <kind> <value>'"},
+		{"\n# ok\nban word x", "line 3: unknown moderation action: ban"},
+		{"mask phrase x", "line 1: unknown rule kind: phrase"},
+		{"mask word ok\nreject regex (x", "line 2: error parsing regexp"},
+	}
+	for _, tt := range tests {
+		_, err := ParseRules(strings.NewReader(tt.rules))
+		if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("ParseRules(%q) error = %v, want %q", tt.rules, err, tt.want)
+		}
+	}
+}
+
+func TestDefaultRules(t *testing.T) {
+	p, err := ParseRules(strings.NewReader(DefaultRules))
+	if err != nil {
+		t.Fatalf("ParseRules(DefaultRules): %s", err)
+	}
+	if got := p.Moderate("Kerfuffle! Sharbert? fornax."); got.Body != "****! ****? ****." {
+		t.Errorf("Moderate = %q, want every word masked", got.Body)
+	}
+}
+
+func TestParseAction(t *testing.T) {
+	for _, a := range []Action{Mask, Flag, Reject} {
+		got, err := ParseAction(a.String())
+		if err != nil || got != a {
+			t.Errorf("ParseAction(%q) = %s, %v", a.String(), got, err)
+		}
+	}
+	if _, err := ParseAction("allow"); err == nil {
+		t.Error("ParseAction(allow) succeeded, want an error")
+	}
+}
@@ -0,0 +1,179 @@
+package moderation
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRules are used when there is no rules file.
+const DefaultRules = `
+mask word kerfuffle
+mask word sharbert
+mask word fornax
+`
+
+// ParseRules builds a Pipeline from rules, one per line, in the form
+//
+//	<action> <kind> <value>
+//
+// where action is mask, flag or reject (see Action) and kind is one of:
+//
+//	word   a single word, matched ignoring case, accents and punctuation
+//	regex  a regular expression (the rest of the line) matched against the body
+//	link   "*" for any link, or a domain whose links (including subdomains) match
+//
+// Blank lines and lines starting with '#' are ignored.
+func ParseRules(r io.Reader) (*Pipeline, error) {
+	words := make(map[Action][]string)
+	var filters []Filter
+	linkDomains := make(map[Action][]string)
+	allLinks := make(map[Action]bool)
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d: expected '<action> <kind> <value>'", lineNo)
+		}
+		action, err := ParseAction(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		switch fields[1] {
+		case "word":
+			words[action] = append(words[action], fields[2])
+		case "regex":
+			value := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line[len(fields[0]):]), "regex"))
+			rf, err := NewRegexFilter(value, action)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			filters = append(filters, rf)
+		case "link":
+			if fields[2] == "*" {
+				allLinks[action] = true
+			} else {
+				linkDomains[action] = append(linkDomains[action], fields[2])
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unknown rule kind: %s", lineNo, fields[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for action, ws := range words {
+		filters = append(filters, NewWordFilter(ws, action))
+	}
+	for action := range allLinks {
+		filters = append(filters, NewLinkFilter(nil, action))
+	}
+	for action, domains := range linkDomains {
+		if !allLinks[action] {
+			filters = append(filters, NewLinkFilter(domains, action))
+		}
+	}
+	return NewPipeline(filters...), nil
+}
+
+// RulesFile is a Pipeline loaded from a rules file that can be reloaded while
+// the server is running.
+type RulesFile struct {
+	path     string
+	mux      *sync.RWMutex
+	pipeline *Pipeline
+	modTime  time.Time
+}
+
+// LoadRulesFile loads the rules at path, falling back to DefaultRules if the
+// file doesn't exist.
+func LoadRulesFile(path string) (*RulesFile, error) {
+	rf := &RulesFile{
+		path: path,
+		mux:  &sync.RWMutex{},
+	}
+	err := rf.Reload()
+	if os.IsNotExist(err) {
+		log.Printf("moderation rules file '%s' not found, using default rules", path)
+		rf.pipeline, err = ParseRules(strings.NewReader(DefaultRules))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RulesFile) Moderate(body string) Result {
+	rf.mux.RLock()
+	p := rf.pipeline
+	rf.mux.RUnlock()
+	return p.Moderate(body)
+}
+
+// Reload re-reads the rules file. If it can't be parsed the current rules are
+// kept.
+func (rf *RulesFile) Reload() error {
+	f, err := os.Open(rf.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	p, err := ParseRules(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", rf.path, err)
+	}
+	rf.mux.Lock()
+	rf.pipeline = p
+	rf.modTime = info.ModTime()
+	rf.mux.Unlock()
+	return nil
+}
+
+// Watch reloads the rules whenever the file's modification time changes,
+// checking every interval until stop is closed.
+func (rf *RulesFile) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(rf.path)
+		if err != nil {
+			continue
+		}
+		rf.mux.RLock()
+		changed := !info.ModTime().Equal(rf.modTime)
+		rf.mux.RUnlock()
+		if !changed {
+			continue
+		}
+		err = rf.Reload()
+		if err != nil {
+			log.Printf("error reloading moderation rules, keeping current rules: %s", err)
+			// Don't retry until the file changes again
+			rf.mux.Lock()
+			rf.modTime = info.ModTime()
+			rf.mux.Unlock()
+			continue
+		}
+		log.Printf("reloaded moderation rules from '%s'", rf.path)
+	}
+}
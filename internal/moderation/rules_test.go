@@ -0,0 +1,109 @@
+package moderation
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeRules(t *testing.T, path string, rules string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(rules), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Set the time explicitly, as writes in quick succession may not change it
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadRulesFileDefaults(t *testing.T) {
+	rf, err := LoadRulesFile(filepath.Join(t.TempDir(), "missing.txt"))
+	if err != nil {
+		t.Fatalf("LoadRulesFile: %s", err)
+	}
+	if got := rf.Moderate("kerfuffle"); got.Body != "****" {
+		t.Errorf("Moderate = %q, want the default rules applied", got.Body)
+	}
+}
+
+func TestLoadRulesFileInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.txt")
+	writeRules(t, path, "mask nonsense x", time.Now())
+	if _, err := LoadRulesFile(path); err == nil {
+		t.Error("LoadRulesFile succeeded with an invalid file")
+	}
+}
+
+func TestRulesFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.txt")
+	start := time.Now().Add(-time.Hour)
+	writeRules(t, path, "mask word apple", start)
+	rf, err := LoadRulesFile(path)
+	if err != nil {
+		t.Fatalf("LoadRulesFile: %s", err)
+	}
+	if got := rf.Moderate("apple pear"); got.Body != "**** pear" {
+		t.Fatalf("Moderate = %q before reloading", got.Body)
+	}
+
+	writeRules(t, path, "reject word pear", start.Add(time.Minute))
+	if err := rf.Reload(); err != nil {
+		t.Fatalf("Reload: %s", err)
+	}
+	if got := rf.Moderate("apple pear"); got.Action != Reject {
+		t.Errorf("Moderate = %+v after reloading, want rejected", got)
+	}
+
+	// A broken file leaves the current rules in place
+	writeRules(t, path, "reject", start.Add(2*time.Minute))
+	if err := rf.Reload(); err == nil {
+		t.Error("Reload succeeded with an invalid file")
+	}
+	if got := rf.Moderate("apple pear"); got.Action != Reject {
+		t.Errorf("Moderate = %+v after a failed reload, want the previous rules", got)
+	}
+}
+
+func TestRulesFileWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.txt")
+	start := time.Now().Add(-time.Hour)
+	writeRules(t, path, "mask word apple", start)
+	rf, err := LoadRulesFile(path)
+	if err != nil {
+		t.Fatalf("LoadRulesFile: %s", err)
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		rf.Watch(5*time.Millisecond, stop)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	waitFor := func(what string, body string, want string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for rf.Moderate(body).Body != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("rules weren't reloaded: %s", what)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	writeRules(t, path, "mask word pear", start.Add(time.Minute))
+	waitFor("after a change", "apple pear", "apple ****")
+
+	// A broken file is skipped, and the next good change is still picked up
+	writeRules(t, path, "mask", start.Add(2*time.Minute))
+	time.Sleep(50 * time.Millisecond)
+	if got := rf.Moderate("apple pear").Body; got != "apple ****" {
+		t.Fatalf("Moderate = %q after a broken change, want the previous rules", got)
+	}
+	writeRules(t, path, "mask word apple", start.Add(3*time.Minute))
+	waitFor("after fixing the file", "apple pear", "**** pear")
+}
@@ -0,0 +1,104 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	dateLayout = "2006-01-02"
+)
+
+// Query is a parsed search query. Terms must all appear in a chirp, each
+// phrase must appear as consecutive terms, and the remaining fields are
+// filters. Since is inclusive and Until is exclusive; either may be zero.
+type Query struct {
+	Terms    []string
+	Phrases  [][]string
+	From     []string
+	Hashtags []string
+	Since    time.Time
+	Until    time.Time
+}
+
+func (q Query) Empty() bool {
+	return len(q.Terms) == 0 && len(q.Phrases) == 0 && len(q.From) == 0 &&
+		len(q.Hashtags) == 0 && q.Since.IsZero() && q.Until.IsZero()
+}
+
+// Tokenize splits text into lower-cased search terms. Anything other than
+// letters, digits and combining marks separates terms.
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.Is(unicode.Mn, r)
+	})
+}
+
+// Parse parses a query string such as
+//
+//	chirpy "web server" from:alice #golang since:2024-01-01 until:2024-02-01
+func Parse(q string) (Query, error) {
+	var query Query
+	for _, word := range splitQuery(q) {
+		if strings.HasPrefix(word, `"`) {
+			phrase := Tokenize(word)
+			switch len(phrase) {
+			case 0:
+			case 1:
+				query.Terms = append(query.Terms, phrase[0])
+			default:
+				query.Phrases = append(query.Phrases, phrase)
+			}
+			continue
+		}
+		key, value, hasKey := strings.Cut(word, ":")
+		switch {
+		case hasKey && key == "from" && value != "":
+			query.From = append(query.From, strings.TrimPrefix(value, "@"))
+		case hasKey && (key == "since" || key == "until"):
+			t, err := time.Parse(dateLayout, value)
+			if err != nil {
+				return Query{}, fmt.Errorf("invalid %s date, expected YYYY-MM-DD: %s", key, value)
+			}
+			if key == "since" {
+				query.Since = t
+			} else {
+				query.Until = t
+			}
+		case strings.HasPrefix(word, "#") && len(word) > 1:
+			query.Hashtags = append(query.Hashtags, strings.ToLower(word[1:]))
+		default:
+			query.Terms = append(query.Terms, Tokenize(word)...)
+		}
+	}
+	return query, nil
+}
+
+// splitQuery splits on whitespace, keeping double-quoted phrases (with their
+// opening quote) together. An unterminated quote runs to the end of q.
+func splitQuery(q string) []string {
+	var words []string
+	for {
+		q = strings.TrimLeftFunc(q, unicode.IsSpace)
+		if q == "" {
+			return words
+		}
+		if q[0] == '"' {
+			end := strings.IndexByte(q[1:], '"')
+			if end < 0 {
+				return append(words, q)
+			}
+			words = append(words, q[:end+1])
+			q = q[end+2:]
+			continue
+		}
+		end := strings.IndexFunc(q, unicode.IsSpace)
+		if end < 0 {
+			return append(words, q)
+		}
+		words = append(words, q[:end])
+		q = q[end:]
+	}
+}
@@ -0,0 +1,104 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", []string{}},
+		{"Hello, World!", []string{"hello", "world"}},
+		{"web-server v2.0", []string{"web", "server", "v2", "0"}},
+		{"don't", []string{"don", "t"}},
+		{"#golang @bob", []string{"golang", "bob"}},
+		{"Crème brûlée", []string{"crème", "brûlée"}},
+		{"cafe\u0301 ok", []string{"cafe\u0301", "ok"}},
+		{"日本語 テキスト", []string{"日本語", "テキスト"}},
+		{"  \t\n ", []string{}},
+	}
+	for _, tt := range tests {
+		if got := Tokenize(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.Parse(dateLayout, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	tests := []struct {
+		name string
+		q    string
+		want Query
+	}{
+		{"empty", "", Query{}},
+		{"terms", "Chirpy  rocks", Query{Terms: []string{"chirpy", "rocks"}}},
+		{"punctuated term", "web-server", Query{Terms: []string{"web", "server"}}},
+		{"phrase", `"web server" go`, Query{Terms: []string{"go"}, Phrases: [][]string{{"web", "server"}}}},
+		{"one-word phrase is a term", `"go"`, Query{Terms: []string{"go"}}},
+		{"empty phrase", `"" go`, Query{Terms: []string{"go"}}},
+		{"unterminated phrase", `go "web server`, Query{Terms: []string{"go"}, Phrases: [][]string{{"web", "server"}}}},
+		{"from", "from:alice from:@Bob", Query{From: []string{"alice", "Bob"}}},
+		{"empty from is a term", "from:", Query{Terms: []string{"from"}}},
+		{"hashtags", "#GoLang #go", Query{Hashtags: []string{"golang", "go"}}},
+		{"lone hash", "#", Query{}},
+		{"dates", "since:2024-01-01 until:2024-02-01", Query{Since: day("2024-01-01"), Until: day("2024-02-01")}},
+		{"everything", `chirpy "web server" from:alice #golang since:2024-01-01`, Query{
+			Terms:    []string{"chirpy"},
+			Phrases:  [][]string{{"web", "server"}},
+			From:     []string{"alice"},
+			Hashtags: []string{"golang"},
+			Since:    day("2024-01-01"),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.q)
+			if err != nil {
+				t.Fatalf("Parse(%q): %s", tt.q, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.q, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInvalidDates(t *testing.T) {
+	for _, q := range []string{"since:yesterday", "until:2024-13-01", "since:", "until:01/02/2024"} {
+		if _, err := Parse(q); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", q)
+		}
+	}
+}
+
+func TestQueryEmpty(t *testing.T) {
+	for _, q := range []string{"", "   ", `""`, "#"} {
+		parsed, err := Parse(q)
+		if err != nil {
+			t.Fatalf("Parse(%q): %s", q, err)
+		}
+		if !parsed.Empty() {
+			t.Errorf("Parse(%q).Empty() = false, want true", q)
+		}
+	}
+	for _, q := range []string{"go", "from:alice", "#go", "since:2024-01-01", `"web server"`} {
+		parsed, err := Parse(q)
+		if err != nil {
+			t.Fatalf("Parse(%q): %s", q, err)
+		}
+		if parsed.Empty() {
+			t.Errorf("Parse(%q).Empty() = true, want false", q)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package stream
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrLagging ends a subscription whose buffer filled up because its
+	// client wasn't keeping up. The client can resume from the last event
+	// it received.
+	ErrLagging = errors.New("subscriber fell behind")
+	ErrClosed  = errors.New("hub closed")
+)
+
+// Event is a message fanned out to subscribers. IDs increase by one per
+// event.
+type Event struct {
+	ID   uint64
+	Name string
+	Data any
+}
+
+// Hub fans events out to subscribers and keeps the most recent ones so that
+// subscribers that reconnect can catch up on what they missed.
+type Hub struct {
+	mu     sync.Mutex
+	replay []Event
+	size   int
+	lastID uint64
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+// Subscription receives events from a hub on C until Done is closed.
+type Subscription struct {
+	C    <-chan Event
+	Done <-chan struct{}
+
+	c    chan Event
+	done chan struct{}
+	err  error
+}
+
+// Err explains why the subscription ended, once Done is closed.
+func (s *Subscription) Err() error {
+	<-s.done
+	return s.err
+}
+
+// NewHub returns a hub that keeps the last replaySize events. Event IDs start
+// from the current time in microseconds, so they keep increasing across
+// restarts and an ID from before a restart is never mistaken for a recent one.
+func NewHub(replaySize int) *Hub {
+	return &Hub{
+		size:   replaySize,
+		lastID: uint64(time.Now().UnixMicro()),
+		subs:   make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish sends an event to every subscriber without waiting for them.
+// Subscribers whose buffers are full are dropped with ErrLagging.
+func (h *Hub) Publish(name string, data any) Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastID++
+	e := Event{ID: h.lastID, Name: name, Data: data}
+	if h.closed {
+		return e
+	}
+	h.replay = append(h.replay, e)
+	if len(h.replay) > h.size {
+		h.replay = h.replay[len(h.replay)-h.size:]
+	}
+	for s := range h.subs {
+		select {
+		case s.c <- e:
+		default:
+			h.end(s, ErrLagging)
+		}
+	}
+	return e
+}
+
+// Subscribe starts a subscription with room for buffer pending events. If
+// lastID is set, the events published after it are returned for replay;
+// complete is false if some of those have already been discarded, or lastID
+// wasn't issued by this hub, in which case the caller has missed events.
+func (h *Hub) Subscribe(lastID uint64, buffer int) (sub *Subscription, missed []Event, complete bool, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, nil, false, ErrClosed
+	}
+	c := make(chan Event, buffer)
+	done := make(chan struct{})
+	sub = &Subscription{C: c, Done: done, c: c, done: done}
+	h.subs[sub] = struct{}{}
+	complete = true
+	if lastID != 0 {
+		missed, complete = h.since(lastID)
+	}
+	return sub, missed, complete, nil
+}
+
+// since returns the kept events after lastID and whether they're all of them.
+func (h *Hub) since(lastID uint64) ([]Event, bool) {
+	if lastID > h.lastID {
+		return nil, false
+	}
+	oldest := h.lastID + 1
+	if len(h.replay) > 0 {
+		oldest = h.replay[0].ID
+	}
+	if lastID+1 < oldest {
+		return append([]Event(nil), h.replay...), false
+	}
+	i := int(lastID + 1 - oldest)
+	return append([]Event(nil), h.replay[i:]...), true
+}
+
+// Unsubscribe ends a subscription.
+func (h *Hub) Unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.end(s, nil)
+}
+
+func (h *Hub) end(s *Subscription, err error) {
+	if _, ok := h.subs[s]; !ok {
+		return
+	}
+	delete(h.subs, s)
+	s.err = err
+	close(s.done)
+}
+
+// Close ends every subscription with ErrClosed and refuses new ones.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for s := range h.subs {
+		h.end(s, ErrClosed)
+	}
+}
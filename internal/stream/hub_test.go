@@ -0,0 +1,134 @@
+package stream
+
+import (
+	"reflect"
+	"testing"
+)
+
+func eventIDs(events []Event) []uint64 {
+	var ids []uint64
+	for _, e := range events {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func TestHubPublish(t *testing.T) {
+	h := NewHub(10)
+	a, _, _, err := h.Subscribe(0, 10)
+	if err != nil {
+		t.Fatalf("Subscribe: %s", err)
+	}
+	b, _, _, err := h.Subscribe(0, 10)
+	if err != nil {
+		t.Fatalf("Subscribe: %s", err)
+	}
+	first := h.Publish("one", 1)
+	second := h.Publish("two", 2)
+	if second.ID != first.ID+1 {
+		t.Errorf("event IDs %d then %d, want them to increase by one", first.ID, second.ID)
+	}
+	for _, sub := range []*Subscription{a, b} {
+		for _, want := range []Event{first, second} {
+			if got := <-sub.C; !reflect.DeepEqual(got, want) {
+				t.Errorf("received %+v, want %+v", got, want)
+			}
+		}
+	}
+
+	h.Unsubscribe(a)
+	if err := a.Err(); err != nil {
+		t.Errorf("Err after Unsubscribe = %v, want nil", err)
+	}
+	h.Publish("three", 3)
+	if got := <-b.C; got.Name != "three" {
+		t.Errorf("remaining subscriber received %+v, want three", got)
+	}
+	select {
+	case e := <-a.C:
+		t.Errorf("unsubscribed subscriber received %+v", e)
+	default:
+	}
+}
+
+func TestHubReplay(t *testing.T) {
+	h := NewHub(3)
+	var published []Event
+	for i := 0; i < 5; i++ {
+		published = append(published, h.Publish("event", i))
+	}
+	// Only the last 3 events are kept
+	tests := []struct {
+		name     string
+		lastID   uint64
+		want     []Event
+		complete bool
+	}{
+		{"no last ID", 0, nil, true},
+		{"up to date", published[4].ID, nil, true},
+		{"missed some", published[2].ID, published[3:], true},
+		{"missed all kept", published[1].ID, published[2:], true},
+		{"missed discarded events", published[0].ID, published[2:], false},
+		{"from another hub", published[4].ID + 100, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, missed, complete, err := h.Subscribe(tt.lastID, 10)
+			if err != nil {
+				t.Fatalf("Subscribe: %s", err)
+			}
+			defer h.Unsubscribe(sub)
+			if !reflect.DeepEqual(eventIDs(missed), eventIDs(tt.want)) || complete != tt.complete {
+				t.Errorf("Subscribe(%d) missed %v, complete %v; want %v, %v",
+					tt.lastID, eventIDs(missed), complete, eventIDs(tt.want), tt.complete)
+			}
+		})
+	}
+}
+
+func TestHubDropsLaggingSubscribers(t *testing.T) {
+	h := NewHub(10)
+	slow, _, _, err := h.Subscribe(0, 1)
+	if err != nil {
+		t.Fatalf("Subscribe: %s", err)
+	}
+	fast, _, _, err := h.Subscribe(0, 10)
+	if err != nil {
+		t.Fatalf("Subscribe: %s", err)
+	}
+	h.Publish("one", 1)
+	h.Publish("two", 2)
+	<-slow.Done
+	if err := slow.Err(); err != ErrLagging {
+		t.Errorf("slow subscriber ended with %v, want ErrLagging", err)
+	}
+	if got := <-slow.C; got.Name != "one" {
+		t.Errorf("slow subscriber's buffer held %+v, want one", got)
+	}
+	select {
+	case <-fast.Done:
+		t.Errorf("subscriber that kept up was ended: %v", fast.Err())
+	default:
+	}
+	// Publishing to a dropped subscriber again doesn't end it twice
+	h.Publish("three", 3)
+}
+
+func TestHubClose(t *testing.T) {
+	h := NewHub(10)
+	sub, _, _, err := h.Subscribe(0, 10)
+	if err != nil {
+		t.Fatalf("Subscribe: %s", err)
+	}
+	h.Close()
+	<-sub.Done
+	if err := sub.Err(); err != ErrClosed {
+		t.Errorf("subscription ended with %v, want ErrClosed", err)
+	}
+	if _, _, _, err := h.Subscribe(0, 10); err != ErrClosed {
+		t.Errorf("Subscribe after Close: got error %v, want ErrClosed", err)
+	}
+	h.Publish("late", nil)
+	h.Unsubscribe(sub)
+	h.Close()
+}
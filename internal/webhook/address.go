@@ -0,0 +1,80 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"syscall"
+)
+
+// ErrForbiddenAddress is returned for webhook URLs that point at this host or
+// a private network, which users mustn't be able to make us send requests to.
+var ErrForbiddenAddress = errors.New("webhook address is not publicly routable")
+
+var forbiddenPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"), // NAT64, which can reach IPv4 private ranges
+}
+
+// PublicAddr reports whether webhooks may be sent to addr: it mustn't be a
+// loopback, private, link-local, multicast or otherwise reserved address.
+func PublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsUnspecified() || addr.IsLoopback() || addr.IsPrivate() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+	for _, p := range forbiddenPrefixes {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckHost returns ErrForbiddenAddress if host, an IP address or a name, is
+// or resolves to an address that isn't public.
+func CheckHost(ctx context.Context, host string) error {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrForbiddenAddress
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if !PublicAddr(addr) {
+			return ErrForbiddenAddress
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !PublicAddr(addr) {
+			return ErrForbiddenAddress
+		}
+	}
+	return nil
+}
+
+// DialControl is a net.Dialer Control function that refuses connections to
+// addresses that aren't public. It runs on the address actually being dialed,
+// so a name that's re-pointed at a private address after the endpoint was
+// registered is still refused.
+func DialControl(network string, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !PublicAddr(ap.Addr()) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
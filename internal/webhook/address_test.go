@@ -0,0 +1,65 @@
+package webhook
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+)
+
+func TestPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.215.14", true},
+		{"8.8.8.8", true},
+		{"2606:4700::1111", true},
+		{"127.0.0.1", false},
+		{"127.1.2.3", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"172.31.255.255", false},
+		{"192.168.0.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::1", false},
+		{"::", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"ff02::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"64:ff9b::a00:1", false},
+	}
+	for _, tt := range tests {
+		if got := PublicAddr(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("PublicAddr(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	for _, host := range []string{"localhost", "LOCALHOST.", "api.localhost", "127.0.0.1", "::1", "169.254.169.254"} {
+		if err := CheckHost(context.Background(), host); err != ErrForbiddenAddress {
+			t.Errorf("CheckHost(%s) = %v, want ErrForbiddenAddress", host, err)
+		}
+	}
+	if err := CheckHost(context.Background(), "93.184.215.14"); err != nil {
+		t.Errorf("CheckHost of a public address = %v", err)
+	}
+}
+
+func TestDialControl(t *testing.T) {
+	if err := DialControl("tcp4", "10.0.0.1:443", nil); err != ErrForbiddenAddress {
+		t.Errorf("DialControl to a private address = %v, want ErrForbiddenAddress", err)
+	}
+	if err := DialControl("tcp6", "[::1]:80", nil); err != ErrForbiddenAddress {
+		t.Errorf("DialControl to loopback = %v, want ErrForbiddenAddress", err)
+	}
+	if err := DialControl("tcp4", "93.184.215.14:443", nil); err != nil {
+		t.Errorf("DialControl to a public address = %v", err)
+	}
+}
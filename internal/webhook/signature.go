@@ -0,0 +1,89 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMissingSignature = errors.New("missing or malformed signature header")
+	ErrInvalidSignature = errors.New("signature does not match")
+	ErrStaleTimestamp   = errors.New("timestamp outside tolerance")
+)
+
+// NewSecret returns a random secret for signing webhooks.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// Sign returns a signature header value for body sent at t, in the form
+//
+//	t=1700000000,v1=5257a869e7...
+//
+// where v1 is the hex HMAC-SHA256, keyed by secret, of the timestamp, a dot,
+// and the raw body.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", ts, hex.EncodeToString(mac(secret, ts, body)))
+}
+
+// Verify checks a signature header made by Sign against body. The header may
+// carry several v1 signatures and any of secrets may match, so that keys can
+// be rotated without dropping deliveries. The timestamp must be within
+// tolerance of now in either direction; it's returned if the header is valid.
+func Verify(header string, body []byte, secrets []string, tolerance time.Duration, now time.Time) (time.Time, error) {
+	var ts string
+	var sigs [][]byte
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			ts = value
+		case "v1":
+			sig, err := hex.DecodeString(value)
+			if err == nil {
+				sigs = append(sigs, sig)
+			}
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || len(sigs) == 0 {
+		return time.Time{}, ErrMissingSignature
+	}
+	t := time.Unix(unix, 0)
+	if d := now.Sub(t); d > tolerance || d < -tolerance {
+		return time.Time{}, ErrStaleTimestamp
+	}
+	for _, secret := range secrets {
+		expected := mac(secret, ts, body)
+		for _, sig := range sigs {
+			if hmac.Equal(sig, expected) {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, ErrInvalidSignature
+}
+
+func mac(secret string, ts string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(ts))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}
@@ -0,0 +1,70 @@
+package webhook
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	const current, previous = "whsec_current", "whsec_previous"
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"id":"evt_1","event":"user.upgraded"}`)
+	signed := Sign(current, now, body)
+	_, currentSig, _ := strings.Cut(signed, ",")
+
+	tests := []struct {
+		name    string
+		header  string
+		body    []byte
+		secrets []string
+		now     time.Time
+		want    error
+	}{
+		{"valid", signed, body, []string{current}, now, nil},
+		{"previous key during rotation", Sign(previous, now, body), body, []string{current, previous}, now, nil},
+		{"previous key after rotation", Sign(previous, now, body), body, []string{current}, now, ErrInvalidSignature},
+		{"one of several signatures", signed + ",v1=" + strings.Repeat("00", 32), body, []string{current}, now, nil},
+		{"spaces around parts", strings.ReplaceAll(signed, ",", " , "), body, []string{current}, now, nil},
+		{"tampered body", signed, []byte(`{"id":"evt_1","event":"user.refunded"}`), []string{current}, now, ErrInvalidSignature},
+		{"tampered timestamp", "t=1700000001," + currentSig, body, []string{current}, now, ErrInvalidSignature},
+		{"wrong key", signed, body, []string{"whsec_other"}, now, ErrInvalidSignature},
+		{"empty header", "", body, []string{current}, now, ErrMissingSignature},
+		{"no timestamp", currentSig, body, []string{current}, now, ErrMissingSignature},
+		{"bad timestamp", "t=soon," + currentSig, body, []string{current}, now, ErrMissingSignature},
+		{"no signature", "t=1700000000", body, []string{current}, now, ErrMissingSignature},
+		{"signature not hex", "t=1700000000,v1=xyz", body, []string{current}, now, ErrMissingSignature},
+		{"unknown scheme only", "t=1700000000,v0=" + strings.TrimPrefix(currentSig, "v1="), body, []string{current}, now, ErrMissingSignature},
+		{"at the edge of tolerance", signed, body, []string{current}, now.Add(5 * time.Minute), nil},
+		{"too old", signed, body, []string{current}, now.Add(5*time.Minute + time.Second), ErrStaleTimestamp},
+		{"too far ahead", signed, body, []string{current}, now.Add(-5*time.Minute - time.Second), ErrStaleTimestamp},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, err := Verify(tt.header, tt.body, tt.secrets, 5*time.Minute, tt.now)
+			if err != tt.want {
+				t.Fatalf("Verify: got error %v, want %v", err, tt.want)
+			}
+			if err == nil && !ts.Equal(now) {
+				t.Errorf("Verify returned timestamp %s, want %s", ts, now)
+			}
+		})
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	a, err := NewSecret()
+	if err != nil {
+		t.Fatalf("NewSecret: %s", err)
+	}
+	b, err := NewSecret()
+	if err != nil {
+		t.Fatalf("NewSecret: %s", err)
+	}
+	if !strings.HasPrefix(a, "whsec_") || len(a) != len("whsec_")+64 {
+		t.Errorf("NewSecret() = %q, want whsec_ and 64 hex digits", a)
+	}
+	if a == b {
+		t.Error("NewSecret returned the same secret twice")
+	}
+}
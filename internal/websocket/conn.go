@@ -0,0 +1,397 @@
+// Package websocket implements the server side of the WebSocket protocol (RFC
+// 6455): the opening handshake, message framing and the closing handshake.
+// Extensions and subprotocols aren't supported.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type MessageType int
+
+const (
+	continuationFrame MessageType = 0
+	TextMessage       MessageType = 1
+	BinaryMessage     MessageType = 2
+	CloseMessage      MessageType = 8
+	PingMessage       MessageType = 9
+	PongMessage       MessageType = 10
+)
+
+// Close codes, from RFC 6455 section 7.4.1.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+	CloseTryAgainLater   = 1013
+)
+
+// acceptGUID is appended to the client's key to compute Sec-WebSocket-Accept.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxControlPayload is the largest payload a ping, pong or close frame may
+// carry.
+const maxControlPayload = 125
+
+// DefaultReadLimit is the read limit of upgraders that don't set one. A frame
+// declares its own length, so without a limit a client could make the server
+// allocate as much memory as it liked.
+const DefaultReadLimit = 1 << 20
+
+var (
+	ErrBadHandshake = errors.New("not a valid websocket handshake")
+	ErrBadOrigin    = errors.New("websocket request from another origin")
+	ErrReadLimit    = errors.New("message exceeds read limit")
+	errProtocol     = errors.New("protocol error")
+)
+
+// CloseError is returned by ReadMessage once the client closes the
+// connection. The close has already been acknowledged.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed with %d %s", e.Code, e.Text)
+}
+
+// Upgrader upgrades HTTP requests to WebSocket connections.
+type Upgrader struct {
+	// ReadLimit is the largest message that will be read, in bytes. Larger
+	// messages fail the connection with CloseMessageTooBig. It's
+	// DefaultReadLimit if not set.
+	ReadLimit int64
+	// WriteTimeout bounds how long writing a frame may take.
+	WriteTimeout time.Duration
+	// CheckOrigin reports whether to accept a request, given its Origin
+	// header. If it's nil, requests from browsers are only accepted from
+	// pages on the same host, so other sites can't open connections with
+	// the user's credentials.
+	CheckOrigin func(r *http.Request) bool
+}
+
+// Upgrade completes the opening handshake and takes over the request's
+// connection. If the request isn't a valid handshake, it responds with an
+// error and returns ErrBadHandshake, or ErrBadOrigin if it's refused by
+// CheckOrigin.
+func (u Upgrader) Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(r) {
+		http.Error(w, "WebSocket origin not allowed", http.StatusForbidden)
+		return nil, ErrBadOrigin
+	}
+	if r.Method != http.MethodGet ||
+		!headerHasToken(r.Header, "Connection", "upgrade") ||
+		!headerHasToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "Expected a WebSocket upgrade", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, ErrBadHandshake
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, "Invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+
+	netConn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "WebSocket upgrade not supported", http.StatusInternalServerError)
+		return nil, err
+	}
+	// The server's deadlines no longer apply once the connection is ours.
+	netConn.SetDeadline(time.Time{})
+	c := &Conn{
+		conn:         netConn,
+		br:           brw.Reader,
+		readLimit:    u.ReadLimit,
+		writeTimeout: u.WriteTimeout,
+	}
+	if c.readLimit <= 0 {
+		c.readLimit = DefaultReadLimit
+	}
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	c.setWriteDeadline()
+	_, err = netConn.Write([]byte(response))
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// sameOrigin accepts requests without an Origin header, which browsers always
+// send, and those whose origin has the same host as the request.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// headerHasToken reports whether a comma-separated header includes token,
+// ignoring case.
+func headerHasToken(h http.Header, name string, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Conn is a server-side WebSocket connection. One goroutine may read from it
+// while others write.
+type Conn struct {
+	conn         net.Conn
+	br           *bufio.Reader
+	readLimit    int64
+	writeTimeout time.Duration
+	onPong       func()
+
+	writeMux  sync.Mutex
+	closeSent bool
+}
+
+// SetReadDeadline sets when a pending or future ReadMessage times out.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetPongHandler sets a function ReadMessage calls, in the reading goroutine,
+// when a pong arrives.
+func (c *Conn) SetPongHandler(fn func()) {
+	c.onPong = fn
+}
+
+// ReadMessage returns the next text or binary message, reassembled from its
+// fragments. Pings are answered and close frames acknowledged as they arrive;
+// after the client closes the connection, ReadMessage returns a *CloseError.
+// Protocol violations fail the connection with the appropriate close code.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	var (
+		messageType MessageType
+		message     []byte
+	)
+	for {
+		fin, op, payload, err := c.readFrame(int64(len(message)))
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case PingMessage:
+			err = c.writeFrame(PongMessage, payload)
+			if err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			if c.onPong != nil {
+				c.onPong()
+			}
+			continue
+		case CloseMessage:
+			return 0, nil, c.handleClose(payload)
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return 0, nil, c.fail(CloseProtocolError, errProtocol, "expected a continuation frame")
+			}
+			messageType = op
+		case continuationFrame:
+			if messageType == 0 {
+				return 0, nil, c.fail(CloseProtocolError, errProtocol, "unexpected continuation frame")
+			}
+		default:
+			return 0, nil, c.fail(CloseProtocolError, errProtocol, "unknown opcode")
+		}
+		message = append(message, payload...)
+		if !fin {
+			continue
+		}
+		if messageType == TextMessage && !utf8.Valid(message) {
+			return 0, nil, c.fail(CloseInvalidPayload, errProtocol, "invalid UTF-8")
+		}
+		return messageType, message, nil
+	}
+}
+
+// readFrame reads one frame, given how much of the current message has
+// already been read.
+func (c *Conn) readFrame(read int64) (fin bool, op MessageType, payload []byte, err error) {
+	var header [2]byte
+	_, err = io.ReadFull(c.br, header[:])
+	if err != nil {
+		return false, 0, nil, err
+	}
+	fin = header[0]&0x80 != 0
+	op = MessageType(header[0] & 0x0f)
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, errProtocol, "unexpected reserved bits")
+	}
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, errProtocol, "client frames must be masked")
+	}
+	length := int64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(c.br, ext[:])
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(c.br, ext[:])
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+	if err != nil {
+		return false, 0, nil, err
+	}
+	if op >= CloseMessage && (!fin || length > maxControlPayload) {
+		return false, 0, nil, c.fail(CloseProtocolError, errProtocol, "invalid control frame")
+	}
+	if length < 0 || (op < CloseMessage && read+length > c.readLimit) {
+		return false, 0, nil, c.fail(CloseMessageTooBig, ErrReadLimit, "message too big")
+	}
+	var mask [4]byte
+	_, err = io.ReadFull(c.br, mask[:])
+	if err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, length)
+	_, err = io.ReadFull(c.br, payload)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// handleClose acknowledges a close frame from the client.
+func (c *Conn) handleClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatus}
+	if len(payload) >= 2 {
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Text = string(payload[2:])
+	}
+	if len(payload) == 1 || !utf8.ValidString(closeErr.Text) {
+		return c.fail(CloseProtocolError, errProtocol, "invalid close frame")
+	}
+	code := closeErr.Code
+	if code == CloseNoStatus {
+		code = CloseNormal
+	}
+	c.CloseWith(code, "")
+	return closeErr
+}
+
+// fail closes the connection with code after a protocol violation, returning
+// err.
+func (c *Conn) fail(code int, err error, text string) error {
+	c.CloseWith(code, text)
+	return err
+}
+
+// WriteMessage sends data as a single text or binary frame.
+func (c *Conn) WriteMessage(messageType MessageType, data []byte) error {
+	return c.writeFrame(messageType, data)
+}
+
+// Ping sends a ping; the client's pong goes to the pong handler.
+func (c *Conn) Ping() error {
+	return c.writeFrame(PingMessage, nil)
+}
+
+func (c *Conn) writeFrame(op MessageType, payload []byte) error {
+	c.writeMux.Lock()
+	defer c.writeMux.Unlock()
+	if c.closeSent {
+		return net.ErrClosed
+	}
+	return c.writeFrameLocked(op, payload)
+}
+
+func (c *Conn) writeFrameLocked(op MessageType, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|byte(op))
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, payload...)
+	c.setWriteDeadline()
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func (c *Conn) setWriteDeadline() {
+	if c.writeTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+}
+
+// CloseWith sends a close frame with code and text, unless one has already
+// been sent, and closes the connection.
+func (c *Conn) CloseWith(code int, text string) error {
+	c.writeMux.Lock()
+	defer c.writeMux.Unlock()
+	if !c.closeSent {
+		c.closeSent = true
+		payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+		payload = append(payload, text[:min(len(text), maxControlPayload-2)]...)
+		c.writeFrameLocked(CloseMessage, payload)
+	}
+	return c.conn.Close()
+}
+
+// Close closes the connection without a closing handshake.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
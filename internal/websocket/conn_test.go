@@ -0,0 +1,401 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+// peer is the client end of a connection to an echo server, which sends back
+// every message it reads.
+type peer struct {
+	t      *testing.T
+	conn   net.Conn
+	br     *bufio.Reader
+	server *Conn
+	// err is the error that ended the server's read loop
+	err   chan error
+	pongs chan struct{}
+}
+
+func dial(t *testing.T, u Upgrader) *peer {
+	t.Helper()
+	p := &peer{t: t, err: make(chan error, 1), pongs: make(chan struct{}, 10)}
+	servers := make(chan *Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r)
+		if err != nil {
+			p.err <- err
+			return
+		}
+		c.SetPongHandler(func() { p.pongs <- struct{}{} })
+		servers <- c
+		for {
+			t, msg, err := c.ReadMessage()
+			if err != nil {
+				p.err <- err
+				return
+			}
+			c.WriteMessage(t, msg)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dialing: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	req := "GET / HTTP/1.1\r\nHost: " + srv.Listener.Addr().String() + "\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + testKey + "\r\nSec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		t.Fatalf("writing handshake: %s", err)
+	}
+	p.conn, p.br = conn, bufio.NewReader(conn)
+	resp, err := http.ReadResponse(p.br, nil)
+	if err != nil {
+		t.Fatalf("reading handshake response: %s", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake status %d, want 101", resp.StatusCode)
+	}
+	// The accept key for testKey is given in RFC 6455 section 1.3
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Sec-WebSocket-Accept = %q", got)
+	}
+	p.server = <-servers
+	return p
+}
+
+// frame encodes a client frame, masked unless mask is nil.
+func frame(fin bool, op MessageType, payload []byte, mask []byte) []byte {
+	b := byte(op)
+	if fin {
+		b |= 0x80
+	}
+	f := []byte{b}
+	maskBit := byte(0)
+	if mask != nil {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		f = append(f, maskBit|byte(n))
+	case n <= 0xffff:
+		f = append(f, maskBit|126)
+		f = binary.BigEndian.AppendUint16(f, uint16(n))
+	default:
+		f = append(f, maskBit|127)
+		f = binary.BigEndian.AppendUint64(f, uint64(n))
+	}
+	if mask == nil {
+		return append(f, payload...)
+	}
+	f = append(f, mask...)
+	for i, c := range payload {
+		f = append(f, c^mask[i%4])
+	}
+	return f
+}
+
+func (p *peer) send(frames ...[]byte) {
+	p.t.Helper()
+	if _, err := p.conn.Write(bytes.Join(frames, nil)); err != nil {
+		p.t.Fatalf("writing frames: %s", err)
+	}
+}
+
+// receive reads a frame from the server, which must be unmasked.
+func (p *peer) receive() (MessageType, []byte) {
+	p.t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(p.br, header[:]); err != nil {
+		p.t.Fatalf("reading frame: %s", err)
+	}
+	if header[0]&0x80 == 0 || header[1]&0x80 != 0 {
+		p.t.Fatalf("server sent frame header %x, want it final and unmasked", header)
+	}
+	n := int(header[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(p.br, ext[:])
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(p.br, ext[:])
+		n = int(binary.BigEndian.Uint64(ext[:]))
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(p.br, payload); err != nil {
+		p.t.Fatalf("reading payload: %s", err)
+	}
+	return MessageType(header[0] & 0x0f), payload
+}
+
+// expectClose reads a close frame with code from the server, and checks that
+// it then closed the connection.
+func (p *peer) expectClose(code int) {
+	p.t.Helper()
+	op, payload := p.receive()
+	if op != CloseMessage || len(payload) < 2 {
+		p.t.Fatalf("got frame %d %q, want a close frame", op, payload)
+	}
+	if got := int(binary.BigEndian.Uint16(payload)); got != code {
+		p.t.Errorf("server closed with %d %q, want %d", got, payload[2:], code)
+	}
+	if _, err := p.br.ReadByte(); err != io.EOF {
+		p.t.Errorf("after closing, read got error %v, want EOF", err)
+	}
+}
+
+func (p *peer) serverErr() error {
+	p.t.Helper()
+	select {
+	case err := <-p.err:
+		return err
+	case <-time.After(5 * time.Second):
+		p.t.Fatal("server is still reading")
+		return nil
+	}
+}
+
+var testMask = []byte{0x12, 0x34, 0x56, 0x78}
+
+func TestUpgradeRefusesBadHandshakes(t *testing.T) {
+	valid := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "http://chirpy.example/api/ws", nil)
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Connection", "keep-alive, Upgrade")
+		r.Header.Set("Sec-WebSocket-Key", testKey)
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		return r
+	}
+	tests := []struct {
+		name   string
+		change func(r *http.Request)
+		status int
+		err    error
+	}{
+		{"post", func(r *http.Request) { r.Method = http.MethodPost }, http.StatusBadRequest, ErrBadHandshake},
+		{"no upgrade", func(r *http.Request) { r.Header.Del("Upgrade") }, http.StatusBadRequest, ErrBadHandshake},
+		{"no connection upgrade", func(r *http.Request) { r.Header.Set("Connection", "keep-alive") }, http.StatusBadRequest, ErrBadHandshake},
+		{"old version", func(r *http.Request) { r.Header.Set("Sec-WebSocket-Version", "8") }, http.StatusUpgradeRequired, ErrBadHandshake},
+		{"short key", func(r *http.Request) { r.Header.Set("Sec-WebSocket-Key", "c2hvcnQ=") }, http.StatusBadRequest, ErrBadHandshake},
+		{"key not base64", func(r *http.Request) { r.Header.Set("Sec-WebSocket-Key", "not a key!") }, http.StatusBadRequest, ErrBadHandshake},
+		{"other origin", func(r *http.Request) { r.Header.Set("Origin", "https://evil.example") }, http.StatusForbidden, ErrBadOrigin},
+		{"other port", func(r *http.Request) { r.Header.Set("Origin", "https://chirpy.example:8443") }, http.StatusForbidden, ErrBadOrigin},
+		{"bad origin", func(r *http.Request) { r.Header.Set("Origin", "://") }, http.StatusForbidden, ErrBadOrigin},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := valid()
+			tt.change(r)
+			w := httptest.NewRecorder()
+			if _, err := (Upgrader{}).Upgrade(w, r); err != tt.err {
+				t.Errorf("Upgrade: got error %v, want %v", err, tt.err)
+			}
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestSameOrigin(t *testing.T) {
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true},
+		{"https://chirpy.example", true},
+		{"http://CHIRPY.example", true},
+		{"https://chirpy.example.evil", false},
+		{"https://evil.example", false},
+		{"null", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://chirpy.example/", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := sameOrigin(r); got != tt.want {
+			t.Errorf("sameOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestUpgradeCheckOrigin(t *testing.T) {
+	u := Upgrader{CheckOrigin: func(r *http.Request) bool { return r.Header.Get("Origin") == "https://app.example" }}
+	r := httptest.NewRequest(http.MethodGet, "http://chirpy.example/", nil)
+	r.Header.Set("Origin", "https://chirpy.example")
+	if _, err := u.Upgrade(httptest.NewRecorder(), r); err != ErrBadOrigin {
+		t.Errorf("Upgrade from an origin CheckOrigin refuses: got error %v, want ErrBadOrigin", err)
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	p := dial(t, Upgrader{})
+	p.send(frame(true, TextMessage, []byte("hello"), testMask))
+	if op, msg := p.receive(); op != TextMessage || string(msg) != "hello" {
+		t.Errorf("echo = %d %q, want text hello", op, msg)
+	}
+
+	// Fragments are reassembled, and control frames between them are
+	// handled straight away
+	p.send(
+		frame(false, BinaryMessage, []byte{1, 2}, testMask),
+		frame(true, PingMessage, []byte("are you there"), testMask),
+		frame(false, continuationFrame, []byte{3}, testMask),
+		frame(true, PongMessage, nil, testMask),
+		frame(true, continuationFrame, []byte{4, 5}, testMask),
+	)
+	if op, msg := p.receive(); op != PongMessage || string(msg) != "are you there" {
+		t.Errorf("reply to ping = %d %q, want a pong with the same payload", op, msg)
+	}
+	if op, msg := p.receive(); op != BinaryMessage || !bytes.Equal(msg, []byte{1, 2, 3, 4, 5}) {
+		t.Errorf("echo = %d %v, want the fragments joined", op, msg)
+	}
+	select {
+	case <-p.pongs:
+	default:
+		t.Error("pong handler wasn't called")
+	}
+
+	// Lengths that need the 16 and 64 bit forms
+	for _, n := range []int{126, 0xffff + 1} {
+		payload := bytes.Repeat([]byte("x"), n)
+		p.send(frame(true, TextMessage, payload, testMask))
+		if _, msg := p.receive(); !bytes.Equal(msg, payload) {
+			t.Errorf("echo of %d bytes came back as %d bytes", n, len(msg))
+		}
+	}
+}
+
+func TestPing(t *testing.T) {
+	p := dial(t, Upgrader{})
+	if err := p.server.Ping(); err != nil {
+		t.Fatalf("Ping: %s", err)
+	}
+	if op, _ := p.receive(); op != PingMessage {
+		t.Errorf("got frame %d, want a ping", op)
+	}
+}
+
+func TestCloseHandshake(t *testing.T) {
+	t.Run("client closes", func(t *testing.T) {
+		p := dial(t, Upgrader{})
+		p.send(frame(true, CloseMessage, append(binary.BigEndian.AppendUint16(nil, CloseGoingAway), "bye"...), testMask))
+		p.expectClose(CloseGoingAway)
+		var closeErr *CloseError
+		if err := p.serverErr(); !errors.As(err, &closeErr) || *closeErr != (CloseError{CloseGoingAway, "bye"}) {
+			t.Errorf("ReadMessage returned %v, want a CloseError with 1001 bye", err)
+		}
+		if err := p.server.WriteMessage(TextMessage, []byte("late")); err == nil {
+			t.Error("WriteMessage after closing succeeded")
+		}
+	})
+	t.Run("client closes without a code", func(t *testing.T) {
+		p := dial(t, Upgrader{})
+		p.send(frame(true, CloseMessage, nil, testMask))
+		p.expectClose(CloseNormal)
+		var closeErr *CloseError
+		if err := p.serverErr(); !errors.As(err, &closeErr) || closeErr.Code != CloseNoStatus {
+			t.Errorf("ReadMessage returned %v, want a CloseError with 1005", err)
+		}
+	})
+	t.Run("server closes", func(t *testing.T) {
+		p := dial(t, Upgrader{})
+		p.server.CloseWith(CloseTryAgainLater, "fell behind")
+		op, payload := p.receive()
+		if op != CloseMessage || binary.BigEndian.Uint16(payload) != CloseTryAgainLater || string(payload[2:]) != "fell behind" {
+			t.Errorf("got frame %d %q, want a close with 1013 fell behind", op, payload)
+		}
+	})
+}
+
+func TestProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames [][]byte
+		code   int
+	}{
+		{"unmasked", [][]byte{frame(true, TextMessage, []byte("hi"), nil)}, CloseProtocolError},
+		{"reserved bits", [][]byte{append([]byte{0xc1}, frame(true, TextMessage, []byte("hi"), testMask)[1:]...)}, CloseProtocolError},
+		{"unknown opcode", [][]byte{frame(true, 3, nil, testMask)}, CloseProtocolError},
+		{"fragmented ping", [][]byte{frame(false, PingMessage, nil, testMask)}, CloseProtocolError},
+		{"long ping", [][]byte{frame(true, PingMessage, make([]byte, 126), testMask)}, CloseProtocolError},
+		{"continuation first", [][]byte{frame(true, continuationFrame, []byte("hi"), testMask)}, CloseProtocolError},
+		{"new message mid-fragment", [][]byte{
+			frame(false, TextMessage, []byte("a"), testMask),
+			frame(true, TextMessage, []byte("b"), testMask),
+		}, CloseProtocolError},
+		{"close with one byte", [][]byte{frame(true, CloseMessage, []byte{3}, testMask)}, CloseProtocolError},
+		{"invalid utf-8", [][]byte{frame(true, TextMessage, []byte{0xff, 0xfe}, testMask)}, CloseInvalidPayload},
+		{"utf-8 split across fragments", [][]byte{
+			frame(false, TextMessage, []byte{0xc3}, testMask),
+			frame(true, continuationFrame, []byte{0xa9, 0xff}, testMask),
+		}, CloseInvalidPayload},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := dial(t, Upgrader{})
+			p.send(tt.frames...)
+			p.expectClose(tt.code)
+			if err := p.serverErr(); err == nil {
+				t.Error("ReadMessage succeeded")
+			}
+		})
+	}
+}
+
+func TestReadLimit(t *testing.T) {
+	// A header claiming a huge payload, sent without one
+	huge := func(length uint64) []byte {
+		f := []byte{0x80 | byte(BinaryMessage), 0x80 | 127}
+		f = binary.BigEndian.AppendUint64(f, length)
+		return append(f, testMask...)
+	}
+	tests := []struct {
+		name   string
+		limit  int64
+		frames [][]byte
+	}{
+		{"one frame", 16, [][]byte{frame(true, TextMessage, make([]byte, 17), testMask)}},
+		{"across fragments", 16, [][]byte{
+			frame(false, TextMessage, make([]byte, 10), testMask),
+			frame(true, continuationFrame, make([]byte, 7), testMask),
+		}},
+		{"default limit", 0, [][]byte{huge(DefaultReadLimit + 1)}},
+		{"declared 1 EiB", 0, [][]byte{huge(1 << 60)}},
+		{"declared length with the top bit set", 0, [][]byte{huge(1 << 63)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := dial(t, Upgrader{ReadLimit: tt.limit})
+			p.send(tt.frames...)
+			p.expectClose(CloseMessageTooBig)
+			if err := p.serverErr(); err != ErrReadLimit {
+				t.Errorf("ReadMessage returned %v, want ErrReadLimit", err)
+			}
+		})
+	}
+
+	p := dial(t, Upgrader{ReadLimit: 16})
+	p.send(frame(true, TextMessage, []byte(strings.Repeat("x", 16)), testMask))
+	if _, msg := p.receive(); len(msg) != 16 {
+		t.Errorf("message at the limit came back as %d bytes", len(msg))
+	}
+}
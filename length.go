@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/thomasem/chirpy/internal/database"
+	"github.com/thomasem/chirpy/internal/length"
+)
+
+const (
+	defaultChirpLimit   = 140
+	chirpyRedChirpLimit = 280
+)
+
+type chirpLengthResponse struct {
+	Length    int `json:"length"`
+	Limit     int `json:"limit"`
+	Remaining int `json:"remaining"`
+}
+
+// chirpLimit is the longest chirp, in characters as counted by length.Chirp,
+// that u may post.
+func chirpLimit(u database.User) int {
+	if u.HasChirpyRed(time.Now()) {
+		return chirpyRedChirpLimit
+	}
+	return defaultChirpLimit
+}
+
+// chirpLengthHandler measures a draft chirp against the caller's limit so
+// clients can render a character counter that agrees with the server. It
+// doesn't require authentication, but anonymous callers get the default limit.
+func (cs *chirpyService) chirpLengthHandler(w http.ResponseWriter, r *http.Request) {
+	cr, err := decodeBody[chirpRequest](r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON request")
+		return
+	}
+	limit := defaultChirpLimit
+	if v := cs.getViewer(r); v.ID != 0 {
+		user, err := cs.db.GetUser(v.ID)
+		if err == nil {
+			limit = chirpLimit(user)
+		}
+	}
+	n := length.Chirp(cr.Body)
+	respondWithJSON(w, http.StatusOK, chirpLengthResponse{
+		Length:    n,
+		Limit:     limit,
+		Remaining: limit - n,
+	})
+}
@@ -1,21 +1,37 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 
+	"github.com/thomasem/chirpy/internal/blob"
 	"github.com/thomasem/chirpy/internal/database"
+	"github.com/thomasem/chirpy/internal/moderation"
 )
 
 const (
 	addr         = "localhost:8080"
 	dbPath       = "database.json"
+	rulesPath    = "moderation_rules.txt"
+	mediaPath    = "media"
 	jwtSecretEnv = "JWT_SECRET"
 	polkaKeyEnv  = "POLKA_API_KEY"
+	// polkaPreviousKeyEnv optionally holds the old key during a rotation
+	polkaPreviousKeyEnv = "POLKA_API_KEY_PREVIOUS"
+
+	rulesReload   = 10 * time.Second
+	purgeInterval = time.Hour
+	// shutdownTimeout is how long in-flight requests get to finish on
+	// shutdown.
+	shutdownTimeout = 10 * time.Second
 )
 
 type errorResponse struct {
@@ -29,14 +45,34 @@ func faviconHandler(w http.ResponseWriter, r *http.Request) {
 func configureRoutes(mux *http.ServeMux, cs *chirpyService) {
 	// Admin
 	mux.Handle("GET /admin/metrics", http.HandlerFunc(cs.metricsHandler))
+	mux.Handle("GET /api/admin/chirps/flagged", http.HandlerFunc(cs.getFlaggedChirpsHandler))
+	mux.Handle("GET /api/admin/reports", http.HandlerFunc(cs.getReportsHandler))
+	mux.Handle("POST /api/admin/reports/{reportID}/claim", http.HandlerFunc(cs.claimReportHandler))
+	mux.Handle("POST /api/admin/reports/{reportID}/resolve", http.HandlerFunc(cs.resolveReportHandler))
+	mux.Handle("GET /api/admin/audit", http.HandlerFunc(cs.getAuditLogHandler))
+	mux.Handle("GET /api/admin/webhooks/events", http.HandlerFunc(cs.getWebhookEventsHandler))
+	mux.Handle("POST /api/admin/webhooks/events/{eventID}/replay", http.HandlerFunc(cs.replayWebhookEventHandler))
+	mux.Handle("POST /api/admin/users/{userID}/suspension", http.HandlerFunc(cs.suspendUserHandler))
+	mux.Handle("DELETE /api/admin/users/{userID}/suspension", http.HandlerFunc(cs.unsuspendUserHandler))
 
 	// Unauthenticated API
 	mux.Handle("GET /api/healthz", http.HandlerFunc(cs.readyHandler))
 	mux.Handle("GET /api/reset", http.HandlerFunc(cs.resetHandler))
 	mux.Handle("GET /api/chirps", http.HandlerFunc(cs.getChirpsHandler))
 	mux.Handle("GET /api/chirps/{chirpID}", http.HandlerFunc(cs.getChirpHandler))
+	mux.Handle("GET /api/chirps/{chirpID}/history", http.HandlerFunc(cs.getChirpHistoryHandler))
+	mux.Handle("POST /api/chirps/length", http.HandlerFunc(cs.chirpLengthHandler))
+	mux.Handle("GET /api/media/{mediaID}", http.HandlerFunc(cs.getMediaHandler))
+	mux.Handle("GET /api/media/{mediaID}/thumbnail", http.HandlerFunc(cs.getMediaThumbnailHandler))
 	mux.Handle("POST /api/users", http.HandlerFunc(cs.createUserHandler))
 	mux.Handle("GET /api/users", http.HandlerFunc(cs.getUsersHandler))
+	mux.Handle("GET /api/users/{handle}", http.HandlerFunc(cs.getProfileHandler))
+	mux.Handle("GET /api/users/{userID}/followers", http.HandlerFunc(cs.getFollowersHandler))
+	mux.Handle("GET /api/users/{userID}/following", http.HandlerFunc(cs.getFollowingHandler))
+	mux.Handle("GET /api/users/{userID}/mentions", http.HandlerFunc(cs.getMentionsHandler))
+	mux.Handle("GET /api/hashtags/{tag}/chirps", http.HandlerFunc(cs.getHashtagChirpsHandler))
+	mux.Handle("GET /api/search", http.HandlerFunc(cs.searchHandler))
+	mux.Handle("GET /api/stream", http.HandlerFunc(cs.streamHandler))
 
 	// Password Authenticated API
 	mux.Handle("POST /api/login", http.HandlerFunc(cs.loginHandler))
@@ -47,8 +83,58 @@ func configureRoutes(mux *http.ServeMux, cs *chirpyService) {
 
 	// JWT Authenticated API
 	mux.Handle("PUT /api/users", http.HandlerFunc(cs.updateUserHandler))
+	mux.Handle("PATCH /api/users", http.HandlerFunc(cs.updateProfileHandler))
+	mux.Handle("DELETE /api/users", http.HandlerFunc(cs.deleteUserHandler))
 	mux.Handle("POST /api/chirps", http.HandlerFunc(cs.createChirpHandler))
+	mux.Handle("POST /api/media", http.HandlerFunc(cs.uploadMediaHandler))
+	mux.Handle("PATCH /api/chirps/{chirpID}", http.HandlerFunc(cs.editChirpHandler))
 	mux.Handle("DELETE /api/chirps/{chirpID}", http.HandlerFunc(cs.deleteChirpHandler))
+	mux.Handle("POST /api/chirps/{chirpID}/poll/votes", http.HandlerFunc(cs.votePollHandler))
+	mux.Handle("POST /api/chirps/{chirpID}/bookmark", http.HandlerFunc(cs.bookmarkHandler))
+	mux.Handle("DELETE /api/chirps/{chirpID}/bookmark", http.HandlerFunc(cs.removeBookmarkHandler))
+	mux.Handle("GET /api/bookmarks", http.HandlerFunc(cs.getBookmarksHandler))
+	mux.Handle("POST /api/chirps/{chirpID}/pin", http.HandlerFunc(cs.pinHandler))
+	mux.Handle("DELETE /api/chirps/{chirpID}/pin", http.HandlerFunc(cs.unpinHandler))
+	mux.Handle("POST /api/users/{userID}/follow", http.HandlerFunc(cs.followHandler))
+	mux.Handle("DELETE /api/users/{userID}/follow", http.HandlerFunc(cs.unfollowHandler))
+	mux.Handle("GET /api/follow-requests", http.HandlerFunc(cs.getFollowRequestsHandler))
+	mux.Handle("POST /api/follow-requests/{userID}/approve", http.HandlerFunc(cs.approveFollowRequestHandler))
+	mux.Handle("DELETE /api/follow-requests/{userID}", http.HandlerFunc(cs.rejectFollowRequestHandler))
+	mux.Handle("POST /api/users/{userID}/block", http.HandlerFunc(cs.blockHandler))
+	mux.Handle("DELETE /api/users/{userID}/block", http.HandlerFunc(cs.unblockHandler))
+	mux.Handle("GET /api/blocks", http.HandlerFunc(cs.getBlocksHandler))
+	mux.Handle("POST /api/users/{userID}/mute", http.HandlerFunc(cs.muteHandler))
+	mux.Handle("DELETE /api/users/{userID}/mute", http.HandlerFunc(cs.unmuteHandler))
+	mux.Handle("GET /api/mutes", http.HandlerFunc(cs.getMutesHandler))
+	mux.Handle("POST /api/chirps/{chirpID}/reports", http.HandlerFunc(cs.reportChirpHandler))
+	mux.Handle("POST /api/users/{userID}/reports", http.HandlerFunc(cs.reportUserHandler))
+	mux.Handle("POST /api/exports", http.HandlerFunc(cs.createExportHandler))
+	mux.Handle("GET /api/exports", http.HandlerFunc(cs.getExportsHandler))
+	mux.Handle("GET /api/exports/{exportID}", http.HandlerFunc(cs.getExportHandler))
+	mux.Handle("GET /api/exports/{exportID}/download", http.HandlerFunc(cs.downloadExportHandler))
+	mux.Handle("GET /api/timeline", http.HandlerFunc(cs.getTimelineHandler))
+	mux.Handle("GET /api/ws", http.HandlerFunc(cs.websocketHandler))
+	mux.Handle("GET /api/notifications", http.HandlerFunc(cs.getNotificationsHandler))
+	mux.Handle("POST /api/notifications/read", http.HandlerFunc(cs.markNotificationsReadHandler))
+	mux.Handle("POST /api/notifications/{notificationID}/read", http.HandlerFunc(cs.markNotificationReadHandler))
+	mux.Handle("POST /api/conversations", http.HandlerFunc(cs.createConversationHandler))
+	mux.Handle("GET /api/conversations", http.HandlerFunc(cs.getConversationsHandler))
+	mux.Handle("GET /api/conversations/{conversationID}", http.HandlerFunc(cs.getConversationHandler))
+	mux.Handle("GET /api/conversations/{conversationID}/messages", http.HandlerFunc(cs.getMessagesHandler))
+	mux.Handle("POST /api/conversations/{conversationID}/messages", http.HandlerFunc(cs.sendMessageHandler))
+	mux.Handle("DELETE /api/conversations/{conversationID}/messages/{messageID}", http.HandlerFunc(cs.deleteMessageHandler))
+	mux.Handle("POST /api/conversations/{conversationID}/read", http.HandlerFunc(cs.readConversationHandler))
+	mux.Handle("POST /api/drafts", http.HandlerFunc(cs.createDraftHandler))
+	mux.Handle("GET /api/drafts", http.HandlerFunc(cs.getDraftsHandler))
+	mux.Handle("GET /api/drafts/{draftID}", http.HandlerFunc(cs.getDraftHandler))
+	mux.Handle("PUT /api/drafts/{draftID}", http.HandlerFunc(cs.updateDraftHandler))
+	mux.Handle("DELETE /api/drafts/{draftID}", http.HandlerFunc(cs.deleteDraftHandler))
+	mux.Handle("POST /api/drafts/{draftID}/publish", http.HandlerFunc(cs.publishDraftHandler))
+	mux.Handle("POST /api/webhooks", http.HandlerFunc(cs.createWebhookEndpointHandler))
+	mux.Handle("GET /api/webhooks", http.HandlerFunc(cs.getWebhookEndpointsHandler))
+	mux.Handle("DELETE /api/webhooks/{endpointID}", http.HandlerFunc(cs.deleteWebhookEndpointHandler))
+	mux.Handle("GET /api/webhooks/{endpointID}/deliveries", http.HandlerFunc(cs.getWebhookDeliveriesHandler))
+	mux.Handle("POST /api/webhooks/{endpointID}/deliveries/{deliveryID}/redeliver", http.HandlerFunc(cs.redeliverWebhookHandler))
 
 	// Polka Webhooks
 	mux.Handle("POST /api/polka/webhooks", http.HandlerFunc(cs.polkaWebhookHandler))
@@ -73,8 +159,16 @@ func main() {
 	if polkaKey == "" {
 		log.Fatalf("'%s' not set in environment variables", polkaKeyEnv)
 	}
+	polkaKeys := []string{polkaKey}
+	if previous := os.Getenv(polkaPreviousKeyEnv); previous != "" {
+		polkaKeys = append(polkaKeys, previous)
+	}
 
 	dbg := flag.Bool("debug", false, "Enable debug mode")
+	adminEmail := flag.String("admin", "", "Email of an existing user to grant admin rights")
+	editWindow := flag.Duration("edit-window", 15*time.Minute, "How long after posting chirps can be edited")
+	redEditWindow := flag.Duration("red-edit-window", time.Hour, "How long after posting Chirpy Red users can edit chirps")
+	deletionGrace := flag.Duration("deletion-grace", 14*24*time.Hour, "How long deleted accounts can still be restored by logging in")
 	flag.Parse()
 
 	mux := http.NewServeMux()
@@ -87,14 +181,63 @@ func main() {
 	if err != nil {
 		log.Fatalf("error getting DB connection: %s", err)
 	}
-	cs := NewChirpyService(db, jwtSecret, polkaKey)
+	if *adminEmail != "" {
+		au, err := db.GetAuthUserByEmail(*adminEmail)
+		if err != nil {
+			log.Fatalf("could not find admin user '%s': %s", *adminEmail, err)
+		}
+		err = db.SetAdmin(au.ID, true)
+		if err != nil {
+			log.Fatalf("could not grant admin rights: %s", err)
+		}
+	}
+	rules, err := moderation.LoadRulesFile(rulesPath)
+	if err != nil {
+		log.Fatalf("error loading moderation rules: %s", err)
+	}
+
+	blobs, err := blob.NewLocalStore(mediaPath)
+	if err != nil {
+		log.Fatalf("error opening media storage: %s", err)
+	}
+
+	cs := NewChirpyService(db, rules, blobs, serviceConfig{
+		jwtSecret:     jwtSecret,
+		polkaKeys:     polkaKeys,
+		editWindow:    *editWindow,
+		redEditWindow: *redEditWindow,
+		deletionGrace: *deletionGrace,
+	})
 
 	configureRoutes(mux, cs)
 
-	// TODO: add graceful handling of signals and shutdown later
-	log.Printf("Serving on %s", srv.Addr)
-	err = srv.ListenAndServe()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go rules.Watch(rulesReload, ctx.Done())
+	go cs.runScheduler(ctx.Done())
+	go cs.runPurger(purgeInterval, ctx.Done())
+	go cs.runWebhookDispatcher(ctx.Done())
+	cs.resumeExports()
+
+	// Streams and WebSockets never go idle, so end them as soon as shutdown
+	// starts.
+	srv.RegisterOnShutdown(cs.stream.Close)
+
+	go func() {
+		log.Printf("Serving on %s", srv.Addr)
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Printf("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err = srv.Shutdown(shutdownCtx)
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("error shutting down server: %s", err)
 	}
+	cs.bus.Close()
 }
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/thomasem/chirpy/internal/blob"
+	"github.com/thomasem/chirpy/internal/database"
+	"github.com/thomasem/chirpy/internal/media"
+)
+
+const (
+	maxUploadBytes = 5 << 20 // 5 MiB
+	uploadFormFile = "file"
+)
+
+var (
+	invalidAttachmentsMessage = fmt.Sprintf("Attachments must be up to %d of your own unposted uploads", database.MaxAttachmentsPerChirp)
+)
+
+type Attachment struct {
+	ID              int    `json:"id"`
+	ContentType     string `json:"content_type"`
+	Width           int    `json:"width"`
+	Height          int    `json:"height"`
+	URL             string `json:"url"`
+	ThumbnailURL    string `json:"thumbnail_url"`
+	ThumbnailWidth  int    `json:"thumbnail_width"`
+	ThumbnailHeight int    `json:"thumbnail_height"`
+}
+
+func attachmentResponse(a database.Attachment) Attachment {
+	return Attachment{
+		ID:              a.ID,
+		ContentType:     a.ContentType,
+		Width:           a.Width,
+		Height:          a.Height,
+		URL:             fmt.Sprintf("/api/media/%d", a.ID),
+		ThumbnailURL:    fmt.Sprintf("/api/media/%d/thumbnail", a.ID),
+		ThumbnailWidth:  a.ThumbnailWidth,
+		ThumbnailHeight: a.ThumbnailHeight,
+	}
+}
+
+func (cs *chirpyService) attachmentsResponse(attachmentIDs []int) []Attachment {
+	attachments := cs.db.GetAttachments(attachmentIDs)
+	response := make([]Attachment, 0, len(attachments))
+	for _, a := range attachments {
+		response = append(response, attachmentResponse(a))
+	}
+	return response
+}
+
+func attachmentBlobKeys(id int) (string, string) {
+	return fmt.Sprintf("attachments/%d/original", id), fmt.Sprintf("attachments/%d/thumbnail", id)
+}
+
+func (cs *chirpyService) deleteAttachmentBlobs(attachments []database.Attachment) {
+	for _, a := range attachments {
+		for _, key := range []string{a.BlobKey, a.ThumbnailKey} {
+			err := cs.blobs.Delete(key)
+			if err != nil {
+				log.Printf("error deleting blob '%s': %s", key, err)
+			}
+		}
+	}
+}
+
+func (cs *chirpyService) uploadMediaHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	// Leave room for the multipart framing around the file itself
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes+1<<10)
+	file, _, err := r.FormFile(uploadFormFile)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Uploads are limited to %d bytes", maxUploadBytes))
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Expected a multipart form with a '%s' field", uploadFormFile))
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxUploadBytes+1))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to read upload")
+		return
+	}
+	if len(data) > maxUploadBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Uploads are limited to %d bytes", maxUploadBytes))
+		return
+	}
+	img, err := media.Process(data)
+	if err == media.ErrUnsupportedType {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Only JPEG, PNG and GIF images are supported")
+		return
+	}
+	if err == media.ErrInvalidImage || err == media.ErrTooManyPixels {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("error processing upload: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to process image")
+		return
+	}
+
+	a, err := cs.db.CreateAttachment(database.Attachment{
+		OwnerID:              userID,
+		ContentType:          img.ContentType,
+		Size:                 len(img.Data),
+		Width:                img.Width,
+		Height:               img.Height,
+		ThumbnailContentType: img.Thumbnail.ContentType,
+		ThumbnailWidth:       img.Thumbnail.Width,
+		ThumbnailHeight:      img.Thumbnail.Height,
+	}, attachmentBlobKeys)
+	if err != nil {
+		log.Printf("error creating attachment in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to save upload")
+		return
+	}
+	err = cs.blobs.Put(a.BlobKey, bytes.NewReader(img.Data))
+	if err == nil {
+		err = cs.blobs.Put(a.ThumbnailKey, bytes.NewReader(img.Thumbnail.Data))
+	}
+	if err != nil {
+		log.Printf("error storing attachment blobs: %s", err)
+		cs.deleteAttachmentBlobs([]database.Attachment{a})
+		cs.db.DeleteAttachment(a.ID)
+		respondWithError(w, http.StatusInternalServerError, "Failed to save upload")
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, attachmentResponse(a))
+}
+
+func (cs *chirpyService) getMediaHandler(w http.ResponseWriter, r *http.Request) {
+	cs.serveAttachment(w, r, false)
+}
+
+func (cs *chirpyService) getMediaThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	cs.serveAttachment(w, r, true)
+}
+
+// canViewAttachment reports whether v may fetch a. Media posted with a chirp
+// is served to those who can see the chirp; media that hasn't been posted,
+// including media on drafts and scheduled chirps, only to its owner.
+func (cs *chirpyService) canViewAttachment(a database.Attachment, v viewer) bool {
+	if a.ChirpID == 0 {
+		return v.ID != 0 && v.ID == a.OwnerID
+	}
+	chirp, err := cs.db.GetChirp(a.ChirpID)
+	if err != nil {
+		return false
+	}
+	return cs.db.CanView(v.ID, chirp)
+}
+
+func (cs *chirpyService) serveAttachment(w http.ResponseWriter, r *http.Request, thumbnail bool) {
+	mediaID, err := getIDFromPath(r, "mediaID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid media ID in URL")
+		return
+	}
+	a, err := cs.db.GetAttachment(mediaID)
+	if err == database.ErrDoesNotExist {
+		respondWithError(w, http.StatusNotFound, "Media not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Unable to get attachment from database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving media")
+		return
+	}
+	if !cs.canViewAttachment(a, cs.getViewer(r)) {
+		respondWithError(w, http.StatusNotFound, "Media not found")
+		return
+	}
+	key, contentType := a.BlobKey, a.ContentType
+	if thumbnail {
+		key, contentType = a.ThumbnailKey, a.ThumbnailContentType
+	}
+	rc, err := cs.blobs.Get(key)
+	if err == blob.ErrNotFound {
+		respondWithError(w, http.StatusNotFound, "Media not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Unable to get blob '%s': %s", key, err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving media")
+		return
+	}
+	defer rc.Close()
+	w.Header().Set(contentTypeHeader, contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, rc)
+}
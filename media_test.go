@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// uploadAs uploads data as userID's media, or anonymously if it's 0,
+// returning the response.
+func uploadAs(t *testing.T, cs *chirpyService, userID int, data []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile(uploadFormFile, "upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(data)
+	mw.Close()
+	r := httptest.NewRequest(http.MethodPost, "/api/media", &body)
+	r.Header.Set(contentTypeHeader, mw.FormDataContentType())
+	if userID != 0 {
+		token, err := cs.generateJWT(userID, 0)
+		if err != nil {
+			t.Fatalf("generateJWT: %s", err)
+		}
+		r.Header.Set(authorizationHeader, "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	cs.uploadMediaHandler(w, r)
+	return w
+}
+
+// fetchMedia fetches an attachment or its thumbnail as userID, or anonymously
+// if it's 0.
+func fetchMedia(t *testing.T, cs *chirpyService, userID int, mediaID int, thumbnail bool) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if userID != 0 {
+		token, err := cs.generateJWT(userID, 0)
+		if err != nil {
+			t.Fatalf("generateJWT: %s", err)
+		}
+		r.Header.Set(authorizationHeader, "Bearer "+token)
+	}
+	r.SetPathValue("mediaID", strconv.Itoa(mediaID))
+	w := httptest.NewRecorder()
+	if thumbnail {
+		cs.getMediaThumbnailHandler(w, r)
+	} else {
+		cs.getMediaHandler(w, r)
+	}
+	return w
+}
+
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func mustUpload(t *testing.T, cs *chirpyService, userID int) Attachment {
+	t.Helper()
+	w := uploadAs(t, cs, userID, testPNG(t, 800, 400))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("upload: status %d: %s", w.Code, w.Body)
+	}
+	var a Attachment
+	if err := json.Unmarshal(w.Body.Bytes(), &a); err != nil {
+		t.Fatalf("decoding attachment: %s", err)
+	}
+	return a
+}
+
+func TestUploadMedia(t *testing.T) {
+	cs := newTestService(t)
+	owner := mustCreateUser(t, cs, "owner")
+	a := mustUpload(t, cs, owner.ID)
+	if a.ContentType != "image/png" || a.Width != 800 || a.Height != 400 || a.ThumbnailWidth != 320 || a.ThumbnailHeight != 160 {
+		t.Errorf("attachment = %+v", a)
+	}
+	if a.URL != fmt.Sprintf("/api/media/%d", a.ID) {
+		t.Errorf("URL = %s", a.URL)
+	}
+	for _, thumbnail := range []bool{false, true} {
+		w := fetchMedia(t, cs, owner.ID, a.ID, thumbnail)
+		if w.Code != http.StatusOK || w.Header().Get(contentTypeHeader) != "image/png" || w.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("fetching (thumbnail %v): status %d, headers %v", thumbnail, w.Code, w.Header())
+		}
+	}
+
+	if w := uploadAs(t, cs, owner.ID, []byte("not an image")); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("uploading text: status %d, want %d", w.Code, http.StatusUnsupportedMediaType)
+	}
+	if w := uploadAs(t, cs, 0, testPNG(t, 1, 1)); w.Code != http.StatusUnauthorized {
+		t.Errorf("uploading without a token: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestMediaIsServedToThoseWhoCanSeeIt(t *testing.T) {
+	cs := newTestService(t)
+	owner := mustCreateUser(t, cs, "owner")
+	follower := mustCreateUser(t, cs, "follower")
+	stranger := mustCreateUser(t, cs, "stranger")
+	if _, err := cs.db.FollowUser(follower.ID, owner.ID); err != nil {
+		t.Fatalf("FollowUser: %s", err)
+	}
+	status := func(userID int, a Attachment) int {
+		return fetchMedia(t, cs, userID, a.ID, false).Code
+	}
+
+	// Unposted media is only served to its owner
+	unposted := mustUpload(t, cs, owner.ID)
+	for _, id := range []int{0, stranger.ID, follower.ID} {
+		if code := status(id, unposted); code != http.StatusNotFound {
+			t.Errorf("unposted media fetched by user %d: status %d, want %d", id, code, http.StatusNotFound)
+		}
+	}
+
+	post := func(visibility string, a Attachment) int {
+		t.Helper()
+		w := serveAs(t, cs, cs.createChirpHandler, owner.ID, http.MethodPost,
+			fmt.Sprintf(`{"body":"look","attachment_ids":[%d],"visibility":"%s"}`, a.ID, visibility))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("posting: status %d: %s", w.Code, w.Body)
+		}
+		var chirp struct{ ID int }
+		json.Unmarshal(w.Body.Bytes(), &chirp)
+		return chirp.ID
+	}
+	post("public", unposted)
+	for _, id := range []int{0, stranger.ID, owner.ID} {
+		if code := status(id, unposted); code != http.StatusOK {
+			t.Errorf("public media fetched by user %d: status %d, want %d", id, code, http.StatusOK)
+		}
+	}
+
+	private := mustUpload(t, cs, owner.ID)
+	chirpID := post("followers", private)
+	if code := status(stranger.ID, private); code != http.StatusNotFound {
+		t.Errorf("followers-only media fetched by a stranger: status %d, want %d", code, http.StatusNotFound)
+	}
+	if code := status(follower.ID, private); code != http.StatusOK {
+		t.Errorf("followers-only media fetched by a follower: status %d, want %d", code, http.StatusOK)
+	}
+
+	// Deleting the chirp deletes its media
+	if w := serveAs(t, cs, cs.deleteChirpHandler, owner.ID, http.MethodDelete, "", "chirpID", strconv.Itoa(chirpID)); w.Code != http.StatusNoContent {
+		t.Fatalf("deleting: status %d", w.Code)
+	}
+	if code := status(owner.ID, private); code != http.StatusNotFound {
+		t.Errorf("media of a deleted chirp: status %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestChirpsOnlyAttachOwnUnpostedMedia(t *testing.T) {
+	cs := newTestService(t)
+	owner := mustCreateUser(t, cs, "owner")
+	other := mustCreateUser(t, cs, "other")
+	a := mustUpload(t, cs, owner.ID)
+	body := fmt.Sprintf(`{"body":"mine","attachment_ids":[%d]}`, a.ID)
+	if w := serveAs(t, cs, cs.createChirpHandler, other.ID, http.MethodPost, body); w.Code != http.StatusBadRequest {
+		t.Errorf("attaching someone else's media: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := serveAs(t, cs, cs.createChirpHandler, owner.ID, http.MethodPost, body); w.Code != http.StatusCreated {
+		t.Fatalf("attaching own media: status %d: %s", w.Code, w.Body)
+	}
+	if w := serveAs(t, cs, cs.createChirpHandler, owner.ID, http.MethodPost, body); w.Code != http.StatusBadRequest {
+		t.Errorf("attaching posted media again: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
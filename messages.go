@@ -0,0 +1,339 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/thomasem/chirpy/internal/database"
+)
+
+const (
+	maxMessageLength = 1000
+	// maxConversationSize is how many users, including its creator, a group
+	// conversation can have.
+	maxConversationSize = 10
+)
+
+type Conversation struct {
+	ID           int       `json:"id"`
+	Group        bool      `json:"group"`
+	Participants []User    `json:"participants"`
+	LastMessage  *Message  `json:"last_message,omitempty"`
+	UnreadCount  int       `json:"unread_count"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+type Message struct {
+	ID             int       `json:"id"`
+	ConversationID int       `json:"conversation_id"`
+	SenderID       int       `json:"sender_id"`
+	Body           string    `json:"body"`
+	CreatedAt      time.Time `json:"created_at"`
+	// ReadBy lists the other participants who have read the message
+	ReadBy []int `json:"read_by"`
+}
+
+type conversationsResponse struct {
+	Conversations []Conversation `json:"conversations"`
+	UnreadCount   int            `json:"unread_count"`
+	// NextCursor is passed as before to get the next page, and is omitted
+	// on the last page
+	NextCursor int `json:"next_cursor,omitempty"`
+}
+
+type createConversationRequest struct {
+	ParticipantIDs []int  `json:"participant_ids"`
+	Body           string `json:"body"`
+}
+
+type messageRequest struct {
+	Body string `json:"body"`
+}
+
+type readConversationRequest struct {
+	MessageID int `json:"message_id"`
+}
+
+type readConversationResponse struct {
+	UnreadCount int `json:"unread_count"`
+}
+
+func validateMessage(body string) error {
+	if strings.TrimSpace(body) == "" {
+		return errors.New("message body is required")
+	}
+	if utf8.RuneCountInString(body) > maxMessageLength {
+		return errors.New("message is too long")
+	}
+	return nil
+}
+
+func (cs *chirpyService) conversationResponse(s database.ConversationSummary, v viewer) Conversation {
+	conversation := Conversation{
+		ID:           s.ID,
+		Group:        s.Group,
+		Participants: []User{},
+		UnreadCount:  s.Unread,
+		CreatedAt:    s.CreatedAt,
+		UpdatedAt:    s.UpdatedAt,
+	}
+	for _, id := range s.ParticipantIDs {
+		u, err := cs.db.GetUser(id)
+		if err == nil && !u.Deactivated() {
+			conversation.Participants = append(conversation.Participants, cs.userResponse(u, v))
+		}
+	}
+	if s.LastMessage != nil {
+		m := messageResponse(*s.LastMessage, s.Conversation)
+		conversation.LastMessage = &m
+	}
+	return conversation
+}
+
+// messageResponse renders m with the read receipts recorded in c.
+func messageResponse(m database.Message, c database.Conversation) Message {
+	message := Message{
+		ID:             m.ID,
+		ConversationID: m.ConversationID,
+		SenderID:       m.SenderID,
+		Body:           m.Body,
+		CreatedAt:      m.CreatedAt,
+		ReadBy:         []int{},
+	}
+	for _, id := range c.ParticipantIDs {
+		if id != m.SenderID && c.ReadUpTo[id] >= m.ID {
+			message.ReadBy = append(message.ReadBy, id)
+		}
+	}
+	return message
+}
+
+// respondWithMessagingError responds to the errors that sending a message can
+// fail with.
+func respondWithMessagingError(w http.ResponseWriter, err error) {
+	switch err {
+	case database.ErrDoesNotExist:
+		respondWithError(w, http.StatusNotFound, "User not found")
+	case database.ErrCannotMessage:
+		respondWithError(w, http.StatusForbidden, "This user doesn't accept messages from you")
+	default:
+		log.Printf("error sending message in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to send message")
+	}
+}
+
+// createConversationHandler sends a message to one or more users. A message
+// to one user goes to the conversation the two already have, if there is
+// one.
+func (cs *chirpyService) createConversationHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	cr, err := decodeBody[createConversationRequest](r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON request")
+		return
+	}
+	others := 0
+	seen := make(map[int]bool)
+	for _, id := range cr.ParticipantIDs {
+		if id != userID && !seen[id] {
+			seen[id] = true
+			others++
+		}
+	}
+	if others == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one other participant is required")
+		return
+	}
+	if others+1 > maxConversationSize {
+		respondWithError(w, http.StatusBadRequest, "Too many participants")
+		return
+	}
+	err = validateMessage(cr.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	c, _, err := cs.db.CreateConversation(userID, cr.ParticipantIDs, cr.Body)
+	if err != nil {
+		respondWithMessagingError(w, err)
+		return
+	}
+	s, err := cs.db.GetConversation(c.ID, userID)
+	if err != nil {
+		log.Printf("error getting conversation from database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving conversation")
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, cs.conversationResponse(s, viewer{ID: userID}))
+}
+
+// getConversationsHandler lists the caller's conversations, most recently
+// active first, with how many unread messages each has.
+func (cs *chirpyService) getConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	limit, before, err := getPagination(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	conversations, unread := cs.db.GetConversations(userID, before, limit)
+	v := viewer{ID: userID}
+	response := conversationsResponse{
+		Conversations: make([]Conversation, 0, len(conversations)),
+		UnreadCount:   unread,
+	}
+	for _, s := range conversations {
+		response.Conversations = append(response.Conversations, cs.conversationResponse(s, v))
+	}
+	if len(conversations) == limit {
+		response.NextCursor = conversations[len(conversations)-1].Seq
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// getOwnConversation gets a conversation the caller is in, responding with an
+// error if it can't. Other users' conversations are reported as not found.
+func (cs *chirpyService) getOwnConversation(w http.ResponseWriter, r *http.Request) (database.ConversationSummary, int, bool) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return database.ConversationSummary{}, 0, false
+	}
+	conversationID, err := getIDFromPath(r, "conversationID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid conversation ID in URL")
+		return database.ConversationSummary{}, 0, false
+	}
+	s, err := cs.db.GetConversation(conversationID, userID)
+	if err == database.ErrDoesNotExist {
+		respondWithError(w, http.StatusNotFound, "Conversation not found")
+		return database.ConversationSummary{}, 0, false
+	}
+	if err != nil {
+		log.Printf("error getting conversation from database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving conversation")
+		return database.ConversationSummary{}, 0, false
+	}
+	return s, userID, true
+}
+
+func (cs *chirpyService) getConversationHandler(w http.ResponseWriter, r *http.Request) {
+	s, userID, ok := cs.getOwnConversation(w, r)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cs.conversationResponse(s, viewer{ID: userID}))
+}
+
+// getMessagesHandler lists a conversation's messages, newest first. Messages
+// from users the caller has blocked, or been blocked by, are left out.
+func (cs *chirpyService) getMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	s, userID, ok := cs.getOwnConversation(w, r)
+	if !ok {
+		return
+	}
+	limit, before, err := getPagination(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	messages, err := cs.db.GetMessages(s.ID, userID, before, limit)
+	if err != nil {
+		log.Printf("error getting messages from database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving messages")
+		return
+	}
+	response := make([]Message, 0, len(messages))
+	for _, m := range messages {
+		response = append(response, messageResponse(m, s.Conversation))
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cs *chirpyService) sendMessageHandler(w http.ResponseWriter, r *http.Request) {
+	s, userID, ok := cs.getOwnConversation(w, r)
+	if !ok {
+		return
+	}
+	mr, err := decodeBody[messageRequest](r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON request")
+		return
+	}
+	err = validateMessage(mr.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	m, err := cs.db.SendMessage(s.ID, userID, mr.Body)
+	if err != nil {
+		respondWithMessagingError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, messageResponse(m, s.Conversation))
+}
+
+// deleteMessageHandler deletes one of the caller's messages for everyone in
+// the conversation.
+func (cs *chirpyService) deleteMessageHandler(w http.ResponseWriter, r *http.Request) {
+	s, userID, ok := cs.getOwnConversation(w, r)
+	if !ok {
+		return
+	}
+	messageID, err := getIDFromPath(r, "messageID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid message ID in URL")
+		return
+	}
+	err = cs.db.DeleteMessage(s.ID, messageID, userID)
+	if err == database.ErrDoesNotExist {
+		respondWithError(w, http.StatusNotFound, "Message not found")
+		return
+	}
+	if err != nil {
+		log.Printf("error deleting message in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete message")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readConversationHandler marks a conversation read up to the message in the
+// body, or all of it if there's no body. The other participants see this in
+// their messages' read_by.
+func (cs *chirpyService) readConversationHandler(w http.ResponseWriter, r *http.Request) {
+	s, userID, ok := cs.getOwnConversation(w, r)
+	if !ok {
+		return
+	}
+	rr, err := decodeBody[readConversationRequest](r)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON request")
+		return
+	}
+	unread, err := cs.db.MarkConversationRead(s.ID, userID, rr.MessageID)
+	if err == database.ErrDoesNotExist {
+		respondWithError(w, http.StatusNotFound, "Message not found")
+		return
+	}
+	if err != nil {
+		log.Printf("error marking conversation read in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to mark conversation read")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, readConversationResponse{UnreadCount: unread})
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+)
+
+type FlaggedChirp struct {
+	Chirp
+	Flags []string `json:"flags"`
+}
+
+// requireAdmin authenticates the request and checks the caller is an admin,
+// responding with an error if not.
+func (cs *chirpyService) requireAdmin(w http.ResponseWriter, r *http.Request) (viewer, bool) {
+	v := cs.getViewer(r)
+	if v.ID == 0 {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return v, false
+	}
+	if !v.Admin {
+		respondWithError(w, http.StatusForbidden, "Admin access required")
+		return v, false
+	}
+	return v, true
+}
+
+func (cs *chirpyService) getFlaggedChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	v, ok := cs.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	chirps := cs.db.GetFlaggedChirps()
+	response := make([]FlaggedChirp, 0, len(chirps))
+	for _, chirp := range chirps {
+		response = append(response, FlaggedChirp{
+			Chirp: cs.chirpResponse(chirp, v),
+			Flags: chirp.Flags,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
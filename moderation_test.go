@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/thomasem/chirpy/internal/database"
+	"github.com/thomasem/chirpy/internal/moderation"
+)
+
+func TestModerationActions(t *testing.T) {
+	cs := newTestService(t)
+	cs.moderator = moderation.NewPipeline(
+		moderation.NewWordFilter([]string{"kerfuffle"}, moderation.Mask),
+		moderation.NewWordFilter([]string{"sketchy"}, moderation.Flag),
+		moderation.NewWordFilter([]string{"forbidden"}, moderation.Reject),
+	)
+	author := mustCreateUser(t, cs, "author")
+	post := func(body string) (int, database.Chirp) {
+		t.Helper()
+		w := serveAs(t, cs, cs.createChirpHandler, author.ID, http.MethodPost, `{"body":"`+body+`"}`)
+		var chirp database.Chirp
+		if w.Code == http.StatusCreated {
+			if err := json.Unmarshal(w.Body.Bytes(), &chirp); err != nil {
+				t.Fatalf("decoding chirp: %s", err)
+			}
+		}
+		return w.Code, chirp
+	}
+
+	if code, chirp := post("What a Kerfuffle!"); code != http.StatusCreated || chirp.Body != "What a ****!" {
+		t.Errorf("masked chirp: status %d, body %q", code, chirp.Body)
+	}
+	if code, _ := post("forbidden words"); code != http.StatusBadRequest {
+		t.Errorf("rejected chirp: status %d, want %d", code, http.StatusBadRequest)
+	}
+
+	// Flagged chirps are published as written, and queued for review
+	code, flagged := post("a sketchy deal")
+	if code != http.StatusCreated || flagged.Body != "a sketchy deal" {
+		t.Fatalf("flagged chirp: status %d, body %q", code, flagged.Body)
+	}
+	if _, err := cs.db.GetChirp(flagged.ID); err != nil {
+		t.Errorf("flagged chirp wasn't published: %s", err)
+	}
+	if chirps := cs.db.GetChirps(0, author.ID, database.Asc); len(chirps) != 2 {
+		t.Errorf("author has %d public chirps, want the masked and flagged ones", len(chirps))
+	}
+	held := cs.db.GetFlaggedChirps()
+	if len(held) != 1 || held[0].ID != flagged.ID {
+		t.Errorf("GetFlaggedChirps = %+v, want only the flagged chirp", held)
+	}
+	reports := cs.db.GetReports(database.ReportOpen)
+	if len(reports) != 1 || reports[0].ChirpID != flagged.ID || reports[0].Reason != database.ReasonAutomated {
+		t.Errorf("open reports = %+v, want one automated report of the flagged chirp", reports)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/thomasem/chirpy/internal/database"
+	"github.com/thomasem/chirpy/internal/events"
+)
+
+// maxNotificationActors is how many of a grouped notification's actors are
+// included in full; the rest are only counted.
+const maxNotificationActors = 3
+
+type Notification struct {
+	ID         int                       `json:"id"`
+	Type       database.NotificationType `json:"type"`
+	Summary    string                    `json:"summary"`
+	Actors     []User                    `json:"actors"`
+	ActorCount int                       `json:"actor_count"`
+	Chirp      *Chirp                    `json:"chirp,omitempty"`
+	Read       bool                      `json:"read"`
+	CreatedAt  time.Time                 `json:"created_at"`
+	UpdatedAt  time.Time                 `json:"updated_at"`
+}
+
+type notificationsResponse struct {
+	Notifications []Notification `json:"notifications"`
+	UnreadCount   int            `json:"unread_count"`
+	// NextCursor is passed as before to get the next page, and is omitted
+	// on the last page
+	NextCursor int `json:"next_cursor,omitempty"`
+}
+
+type markReadRequest struct {
+	IDs []int `json:"ids"`
+}
+
+type markReadResponse struct {
+	Marked      int `json:"marked"`
+	UnreadCount int `json:"unread_count"`
+}
+
+// subscribeNotifications notifies users when they're mentioned, followed or
+// asked to be followed.
+func (cs *chirpyService) subscribeNotifications() {
+	events.SubscribeAsync(cs.bus, func(e ChirpCreated) {
+		cs.notifyMentions(e.Chirp)
+	})
+	// Mentions added by an edit are notified too; ones already notified
+	// aren't notified again.
+	events.SubscribeAsync(cs.bus, func(e ChirpUpdated) {
+		cs.notifyMentions(e.Chirp)
+	})
+	// Follows and unfollows are handled by one subscriber so that they're
+	// seen in the order they happened, and an unfollow can't be withdrawn
+	// before the follow it undoes has been notified.
+	events.SubscribeAsync(cs.bus, func(e events.Event) {
+		switch e := e.(type) {
+		case UserFollowed:
+			// Users who approve a follow don't need to be told about it
+			if !e.Approved {
+				cs.notify(e.FolloweeID, database.NotificationFollow, e.FollowerID, 0)
+			}
+		case FollowRequested:
+			cs.notify(e.FolloweeID, database.NotificationFollowRequest, e.FollowerID, 0)
+		case UserUnfollowed:
+			err := cs.db.WithdrawNotification(e.FolloweeID, database.NotificationFollow, e.FollowerID)
+			if err != nil {
+				log.Printf("error withdrawing notification in database: %s", err)
+			}
+		}
+	})
+}
+
+func (cs *chirpyService) notifyMentions(chirp database.Chirp) {
+	for _, e := range chirp.Entities {
+		if e.Type == database.EntityMention {
+			cs.notify(e.UserID, database.NotificationMention, chirp.AuthorID, chirp.ID)
+		}
+	}
+}
+
+func (cs *chirpyService) notify(recipientID int, t database.NotificationType, actorID int, chirpID int) {
+	n, ok, err := cs.db.Notify(recipientID, t, actorID, chirpID)
+	if err != nil && err != database.ErrDoesNotExist {
+		log.Printf("error creating %s notification in database: %s", t, err)
+		return
+	}
+	if ok {
+		cs.bus.Publish(NotificationCreated{Notification: n})
+	}
+}
+
+func (cs *chirpyService) notificationResponse(n database.Notification, v viewer) Notification {
+	notification := Notification{
+		ID:         n.ID,
+		Type:       n.Type,
+		Actors:     []User{},
+		ActorCount: len(n.ActorIDs),
+		Read:       n.ReadAt != nil,
+		CreatedAt:  n.CreatedAt,
+		UpdatedAt:  n.UpdatedAt,
+	}
+	for _, id := range n.ActorIDs[:min(len(n.ActorIDs), maxNotificationActors)] {
+		actor, err := cs.db.GetUser(id)
+		if err == nil {
+			notification.Actors = append(notification.Actors, cs.userResponse(actor, v))
+		}
+	}
+	if n.ChirpID != 0 {
+		chirp, err := cs.db.GetChirp(n.ChirpID)
+		if err == nil {
+			c := cs.chirpResponse(chirp, v)
+			notification.Chirp = &c
+		}
+	}
+	notification.Summary = notificationSummary(n.Type, notification.Actors, notification.ActorCount)
+	return notification
+}
+
+// notificationSummary describes a notification in a sentence, like "@alice
+// and 4 others followed you".
+func notificationSummary(t database.NotificationType, actors []User, count int) string {
+	who := "Someone"
+	switch {
+	case len(actors) == 0:
+	case count == 1:
+		who = "@" + actors[0].Handle
+	case count == 2 && len(actors) == 2:
+		who = fmt.Sprintf("@%s and @%s", actors[0].Handle, actors[1].Handle)
+	case count == 2:
+		who = fmt.Sprintf("@%s and 1 other", actors[0].Handle)
+	default:
+		who = fmt.Sprintf("@%s and %d others", actors[0].Handle, count-1)
+	}
+	switch t {
+	case database.NotificationMention:
+		return who + " mentioned you"
+	case database.NotificationFollow:
+		return who + " followed you"
+	case database.NotificationFollowRequest:
+		return who + " requested to follow you"
+	}
+	return who + " interacted with you"
+}
+
+// getNotificationsHandler lists the caller's notifications, most recently
+// updated first. ?unread=true leaves out ones that have been read.
+func (cs *chirpyService) getNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	v := viewer{ID: userID}
+	limit, before, err := getPagination(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+	notifications, unread := cs.db.GetNotifications(userID, before, limit, unreadOnly)
+	response := notificationsResponse{
+		Notifications: make([]Notification, 0, len(notifications)),
+		UnreadCount:   unread,
+	}
+	for _, n := range notifications {
+		response.Notifications = append(response.Notifications, cs.notificationResponse(n, v))
+	}
+	if len(notifications) == limit {
+		response.NextCursor = notifications[len(notifications)-1].Seq
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cs *chirpyService) markNotificationReadHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	notificationID, err := getIDFromPath(r, "notificationID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid notification ID in URL")
+		return
+	}
+	_, err = cs.db.MarkNotificationsRead(userID, []int{notificationID})
+	if err == database.ErrDoesNotExist {
+		respondWithError(w, http.StatusNotFound, "Notification not found")
+		return
+	}
+	if err != nil {
+		log.Printf("error marking notification read in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to mark notification read")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// markNotificationsReadHandler marks the notifications listed in the body as
+// read, or all of the caller's notifications if there's no body.
+func (cs *chirpyService) markNotificationsReadHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	mr, err := decodeBody[markReadRequest](r)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON request")
+		return
+	}
+	marked, err := cs.db.MarkNotificationsRead(userID, mr.IDs)
+	if err == database.ErrDoesNotExist {
+		respondWithError(w, http.StatusNotFound, "Notification not found")
+		return
+	}
+	if err != nil {
+		log.Printf("error marking notifications read in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to mark notifications read")
+		return
+	}
+	_, unread := cs.db.GetNotifications(userID, 0, 0, true)
+	respondWithJSON(w, http.StatusOK, markReadResponse{Marked: marked, UnreadCount: unread})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/thomasem/chirpy/internal/database"
+)
+
+func TestNotificationSummary(t *testing.T) {
+	users := func(handles ...string) []User {
+		var us []User
+		for _, h := range handles {
+			us = append(us, User{Handle: h})
+		}
+		return us
+	}
+	tests := []struct {
+		t      database.NotificationType
+		actors []User
+		count  int
+		want   string
+	}{
+		{database.NotificationMention, users("ann"), 1, "@ann mentioned you"},
+		{database.NotificationFollow, users("ann", "bob"), 2, "@ann and @bob followed you"},
+		{database.NotificationFollow, users("ann"), 2, "@ann and 1 other followed you"},
+		{database.NotificationFollow, users("ann", "bob", "cat"), 5, "@ann and 4 others followed you"},
+		{database.NotificationFollowRequest, users("ann"), 1, "@ann requested to follow you"},
+		{database.NotificationFollow, nil, 2, "Someone followed you"},
+	}
+	for _, tt := range tests {
+		if got := notificationSummary(tt.t, tt.actors, tt.count); got != tt.want {
+			t.Errorf("notificationSummary(%s, %d actors, %d) = %q, want %q", tt.t, len(tt.actors), tt.count, got, tt.want)
+		}
+	}
+}
+
+func TestNotificationHandlers(t *testing.T) {
+	cs := newTestService(t)
+	user := mustCreateUser(t, cs, "user")
+	userID := strconv.Itoa(user.ID)
+	var followers []database.User
+	for _, handle := range []string{"ann", "bob", "cat"} {
+		follower := mustCreateUser(t, cs, handle)
+		followers = append(followers, follower)
+		if w := serveAs(t, cs, cs.followHandler, follower.ID, http.MethodPost, "", "userID", userID); w.Code != http.StatusNoContent {
+			t.Fatalf("follow: status %d: %s", w.Code, w.Body)
+		}
+	}
+	// cat changes their mind before the user has looked
+	if w := serveAs(t, cs, cs.unfollowHandler, followers[2].ID, http.MethodDelete, "", "userID", userID); w.Code != http.StatusNoContent {
+		t.Fatalf("unfollow: status %d: %s", w.Code, w.Body)
+	}
+	cs.bus.Flush()
+
+	list := func() notificationsResponse {
+		t.Helper()
+		w := serveAs(t, cs, cs.getNotificationsHandler, user.ID, http.MethodGet, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("list: status %d: %s", w.Code, w.Body)
+		}
+		var resp notificationsResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decoding notifications: %s", err)
+		}
+		return resp
+	}
+	resp := list()
+	if len(resp.Notifications) != 1 || resp.UnreadCount != 1 {
+		t.Fatalf("got %+v, want one unread notification", resp)
+	}
+	n := resp.Notifications[0]
+	if n.Summary != "@bob and @ann followed you" || n.ActorCount != 2 || n.Read {
+		t.Errorf("notification = %+v, want an unread follow by bob and ann", n)
+	}
+
+	notificationID := strconv.Itoa(n.ID)
+	if w := serveAs(t, cs, cs.markNotificationReadHandler, followers[0].ID, http.MethodPost, "", "notificationID", notificationID); w.Code != http.StatusNotFound {
+		t.Errorf("marking another user's notification: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	w := serveAs(t, cs, cs.markNotificationsReadHandler, user.ID, http.MethodPost, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("mark all read: status %d: %s", w.Code, w.Body)
+	}
+	var marked markReadResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &marked); err != nil {
+		t.Fatalf("decoding response: %s", err)
+	}
+	if marked != (markReadResponse{Marked: 1, UnreadCount: 0}) {
+		t.Errorf("mark all read = %+v, want 1 marked and none unread", marked)
+	}
+	if resp := list(); len(resp.Notifications) != 1 || !resp.Notifications[0].Read || resp.UnreadCount != 0 {
+		t.Errorf("after marking read got %+v", resp)
+	}
+}
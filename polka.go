@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/thomasem/chirpy/internal/database"
+	"github.com/thomasem/chirpy/internal/webhook"
+)
+
+const (
+	polkaSource          = "polka"
+	polkaSignatureHeader = "Polka-Signature"
+	// polkaTolerance is how far a webhook's signed timestamp may be from our
+	// clock. Older deliveries are rejected, and newer ones are deduplicated
+	// by the event log.
+	polkaTolerance = 5 * time.Minute
+	// polkaEventLease is how long an event may be being applied before a
+	// redelivery assumes the attempt was lost and applies it again.
+	polkaEventLease = time.Minute
+	maxWebhookBytes = 1 << 20
+	// chirpyRedPeriod is how long an upgrade or renewal lasts when Polka
+	// doesn't say when the billing period ends.
+	chirpyRedPeriod = 30 * 24 * time.Hour
+)
+
+var (
+	errUnknownUser = errors.New("user does not exist")
+)
+
+// polkaBillingEvents maps the Polka events we handle to subscription changes.
+var polkaBillingEvents = map[string]database.BillingEventType{
+	"user.upgraded":       database.BillingUpgraded,
+	"user.renewed":        database.BillingRenewed,
+	"user.payment_failed": database.BillingPaymentFailed,
+	"user.downgraded":     database.BillingDowngraded,
+	"user.refunded":       database.BillingRefunded,
+}
+
+type polkaEventData struct {
+	UserID    int       `json:"user_id"`
+	Plan      string    `json:"plan"`
+	PeriodEnd time.Time `json:"period_end"`
+}
+
+type polkaEvent struct {
+	ID    string         `json:"id"`
+	Event string         `json:"event"`
+	Data  polkaEventData `json:"data"`
+}
+
+// polkaWebhookHandler applies payment events from Polka. Each delivery must
+// be signed with one of our Polka keys over its timestamp and raw body. Every
+// event is logged; deliveries of an event that has already been applied are
+// acknowledged without applying it again.
+func (cs *chirpyService) polkaWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBytes))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to read request body")
+		return
+	}
+	_, err = webhook.Verify(r.Header.Get(polkaSignatureHeader), body, cs.config.polkaKeys, polkaTolerance, time.Now())
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid webhook signature")
+		return
+	}
+	var pe polkaEvent
+	err = json.Unmarshal(body, &pe)
+	if err != nil || pe.ID == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON request")
+		return
+	}
+	event, apply, err := cs.db.BeginWebhookEvent(database.WebhookEvent{
+		ID:      pe.ID,
+		Source:  polkaSource,
+		Type:    pe.Event,
+		Payload: body,
+	}, time.Now(), polkaEventLease)
+	if err != nil {
+		log.Printf("error logging webhook event in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "failed to record event")
+		return
+	}
+	if !apply {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	_, err = cs.processPolkaEvent(event, pe)
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errUnknownUser:
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		respondWithError(w, http.StatusInternalServerError, "failed to apply event")
+	}
+}
+
+// processPolkaEvent applies a logged event and records how it went, returning
+// the updated event and the error applying it, if any.
+func (cs *chirpyService) processPolkaEvent(event database.WebhookEvent, pe polkaEvent) (database.WebhookEvent, error) {
+	outcome, reason := database.WebhookProcessed, ""
+	applied, applyErr := cs.applyPolkaEvent(pe)
+	if applyErr != nil {
+		outcome, reason = database.WebhookFailed, applyErr.Error()
+		if applyErr != errUnknownUser {
+			log.Printf("error applying Polka event %s: %s", pe.ID, applyErr)
+		}
+	} else if !applied {
+		outcome = database.WebhookIgnored
+	}
+	finished, err := cs.db.FinishWebhookEvent(event.ID, outcome, reason)
+	if err != nil {
+		log.Printf("error logging webhook outcome in database: %s", err)
+		event.Outcome, event.Error = outcome, reason
+		return event, applyErr
+	}
+	return finished, applyErr
+}
+
+// applyPolkaEvent acts on an event, returning false for events we don't
+// handle or that don't change the user's subscription.
+func (cs *chirpyService) applyPolkaEvent(pe polkaEvent) (bool, error) {
+	eventType, ok := polkaBillingEvents[pe.Event]
+	if !ok {
+		return false, nil
+	}
+	applied, err := cs.db.ApplyBillingEvent(pe.Data.UserID, database.BillingEvent{
+		Type:      eventType,
+		EventID:   pe.ID,
+		Plan:      pe.Data.Plan,
+		PeriodEnd: pe.Data.PeriodEnd,
+	}, chirpyRedPeriod)
+	if err == database.ErrDoesNotExist {
+		return false, errUnknownUser
+	}
+	if applied && err == nil {
+		cs.bus.Publish(SubscriptionChanged{UserID: pe.Data.UserID, Change: eventType})
+	}
+	return applied, err
+}
+
+func (cs *chirpyService) getWebhookEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cs.requireAdmin(w, r); !ok {
+		return
+	}
+	outcome := database.WebhookOutcome(r.URL.Query().Get("outcome"))
+	respondWithJSON(w, http.StatusOK, cs.db.GetWebhookEvents(outcome))
+}
+
+// replayWebhookEventHandler applies a logged event again, such as one that
+// failed because of a bug that has since been fixed.
+func (cs *chirpyService) replayWebhookEventHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cs.requireAdmin(w, r); !ok {
+		return
+	}
+	event, err := cs.db.GetWebhookEvent(r.PathValue("eventID"))
+	if err == database.ErrDoesNotExist {
+		respondWithError(w, http.StatusNotFound, "Event not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Unable to get webhook event from database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving event")
+		return
+	}
+	var pe polkaEvent
+	err = json.Unmarshal(event.Payload, &pe)
+	if err != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, "Event payload is invalid")
+		return
+	}
+	event, err = cs.db.ReplayWebhookEvent(event.ID)
+	if err != nil {
+		log.Printf("error replaying webhook event in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to replay event")
+		return
+	}
+	event, _ = cs.processPolkaEvent(event, pe)
+	respondWithJSON(w, http.StatusOK, event)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thomasem/chirpy/internal/database"
+	"github.com/thomasem/chirpy/internal/events"
+	"github.com/thomasem/chirpy/internal/webhook"
+)
+
+const (
+	testPolkaKey         = "whsec_current"
+	testPolkaPreviousKey = "whsec_previous"
+)
+
+// deliverPolka sends body to the Polka webhook with the given signature header.
+func deliverPolka(t *testing.T, cs *chirpyService, signature string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/api/polka/webhooks", strings.NewReader(body))
+	if signature != "" {
+		r.Header.Set(polkaSignatureHeader, signature)
+	}
+	w := httptest.NewRecorder()
+	cs.polkaWebhookHandler(w, r)
+	return w
+}
+
+func upgradeEvent(eventID string, userID int) string {
+	return fmt.Sprintf(`{"id":%q,"event":"user.upgraded","data":{"user_id":%d}}`, eventID, userID)
+}
+
+func TestPolkaWebhookSignatures(t *testing.T) {
+	cs := newTestService(t)
+	cs.config.polkaKeys = []string{testPolkaKey, testPolkaPreviousKey}
+	rec := events.Record(cs.bus)
+	user := mustCreateUser(t, cs, "user")
+	body := upgradeEvent("evt_1", user.ID)
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		signature string
+		body      string
+	}{
+		{"unsigned", "", body},
+		{"malformed header", "v1=abc,t=", body},
+		{"unknown key", webhook.Sign("whsec_other", now, []byte(body)), body},
+		{"tampered body", webhook.Sign(testPolkaKey, now, []byte(body)), upgradeEvent("evt_1", user.ID+1)},
+		{"stale timestamp", webhook.Sign(testPolkaKey, now.Add(-polkaTolerance-time.Minute), []byte(body)), body},
+		{"future timestamp", webhook.Sign(testPolkaKey, now.Add(polkaTolerance+time.Minute), []byte(body)), body},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := deliverPolka(t, cs, tt.signature, tt.body); w.Code != http.StatusUnauthorized {
+				t.Errorf("status %d, want %d", w.Code, http.StatusUnauthorized)
+			}
+		})
+	}
+	if changed := events.Recorded[SubscriptionChanged](rec); len(changed) != 0 {
+		t.Fatalf("refused deliveries published %+v", changed)
+	}
+	if _, err := cs.db.GetWebhookEvent("evt_1"); err == nil {
+		t.Fatal("a refused delivery was logged")
+	}
+
+	// While keys are rotated, Polka may still sign with the previous one
+	if w := deliverPolka(t, cs, webhook.Sign(testPolkaPreviousKey, now, []byte(body)), body); w.Code != http.StatusNoContent {
+		t.Fatalf("signed with the previous key: status %d: %s", w.Code, w.Body)
+	}
+	if changed := events.Recorded[SubscriptionChanged](rec); len(changed) != 1 || changed[0].UserID != user.ID {
+		t.Errorf("upgrade published %+v, want one SubscriptionChanged for the user", changed)
+	}
+	upgraded, err := cs.db.GetUser(user.ID)
+	if err != nil {
+		t.Fatalf("GetUser: %s", err)
+	}
+	if !upgraded.HasChirpyRed(time.Now()) {
+		t.Error("user doesn't have Chirpy Red after the upgrade")
+	}
+}
+
+func TestPolkaWebhookAppliesEventsOnce(t *testing.T) {
+	cs := newTestService(t)
+	cs.config.polkaKeys = []string{testPolkaKey}
+	user := mustCreateUser(t, cs, "user")
+	body := upgradeEvent("evt_1", user.ID)
+
+	// Polka redelivers when it misses our answer, and may sign each delivery
+	// afresh
+	for i, signedAt := range []time.Time{time.Now(), time.Now().Add(-time.Minute)} {
+		if w := deliverPolka(t, cs, webhook.Sign(testPolkaKey, signedAt, []byte(body)), body); w.Code != http.StatusNoContent {
+			t.Fatalf("delivery %d: status %d: %s", i+1, w.Code, w.Body)
+		}
+	}
+	event, err := cs.db.GetWebhookEvent("evt_1")
+	if err != nil {
+		t.Fatalf("GetWebhookEvent: %s", err)
+	}
+	if event.Attempts != 1 {
+		t.Errorf("event was applied %d times, want once", event.Attempts)
+	}
+
+	// An event for a user we don't know fails, and is tried again when
+	// redelivered
+	unknown := upgradeEvent("evt_2", user.ID+100)
+	for i := 1; i <= 2; i++ {
+		if w := deliverPolka(t, cs, webhook.Sign(testPolkaKey, time.Now(), []byte(unknown)), unknown); w.Code != http.StatusNotFound {
+			t.Fatalf("delivery %d for an unknown user: status %d, want %d", i, w.Code, http.StatusNotFound)
+		}
+		event, err := cs.db.GetWebhookEvent("evt_2")
+		if err != nil {
+			t.Fatalf("GetWebhookEvent: %s", err)
+		}
+		if event.Attempts != i {
+			t.Errorf("after delivery %d the event was tried %d times", i, event.Attempts)
+		}
+	}
+}
+
+func TestPolkaWebhookRedeliveryWhileApplying(t *testing.T) {
+	cs := newTestService(t)
+	cs.config.polkaKeys = []string{testPolkaKey}
+	rec := events.Record(cs.bus)
+	user := mustCreateUser(t, cs, "user")
+	tests := []struct {
+		name      string
+		startedAt time.Time
+		apply     bool
+	}{
+		// Another request is applying the event right now
+		{"pending", time.Now(), false},
+		// The attempt began long ago and never finished, so it was lost
+		{"lease expired", time.Now().Add(-polkaEventLease - time.Second), true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec.Reset()
+			eventID := fmt.Sprintf("evt_%d", i)
+			body := upgradeEvent(eventID, user.ID)
+			_, apply, err := cs.db.BeginWebhookEvent(database.WebhookEvent{
+				ID:      eventID,
+				Source:  polkaSource,
+				Type:    "user.upgraded",
+				Payload: []byte(body),
+			}, tt.startedAt, polkaEventLease)
+			if err != nil || !apply {
+				t.Fatalf("BeginWebhookEvent: apply %v, err %v", apply, err)
+			}
+
+			if w := deliverPolka(t, cs, webhook.Sign(testPolkaKey, time.Now(), []byte(body)), body); w.Code != http.StatusNoContent {
+				t.Fatalf("redelivery: status %d: %s", w.Code, w.Body)
+			}
+			event, err := cs.db.GetWebhookEvent(eventID)
+			if err != nil {
+				t.Fatalf("GetWebhookEvent: %s", err)
+			}
+			if tt.apply {
+				if event.Attempts != 2 || event.Outcome != database.WebhookProcessed {
+					t.Errorf("event tried %d times with outcome %q, want 2 and %q", event.Attempts, event.Outcome, database.WebhookProcessed)
+				}
+				if changed := events.Recorded[SubscriptionChanged](rec); len(changed) != 1 {
+					t.Errorf("redelivery published %+v, want one SubscriptionChanged", changed)
+				}
+			} else {
+				if event.Attempts != 1 || event.Outcome != database.WebhookPending {
+					t.Errorf("event tried %d times with outcome %q, want 1 and %q", event.Attempts, event.Outcome, database.WebhookPending)
+				}
+				if changed := events.Recorded[SubscriptionChanged](rec); len(changed) != 0 {
+					t.Errorf("redelivery published %+v while the event was being applied", changed)
+				}
+			}
+		})
+	}
+}
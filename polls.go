@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thomasem/chirpy/internal/database"
+	"github.com/thomasem/chirpy/internal/length"
+	"github.com/thomasem/chirpy/internal/moderation"
+)
+
+const (
+	minPollOptions      = 2
+	maxPollOptions      = 4
+	maxPollOptionLength = 25
+	minPollDuration     = 5 * time.Minute
+	maxPollDuration     = 7 * 24 * time.Hour
+)
+
+type pollRequest struct {
+	Options  []string  `json:"options"`
+	ClosesAt time.Time `json:"closes_at"`
+}
+
+type voteRequest struct {
+	Option int `json:"option"`
+}
+
+// Poll is a chirp's poll as seen by one viewer. Vote counts are only included
+// once the viewer has voted, if they wrote the chirp, or after the poll has
+// closed, so that early results don't sway anyone.
+type Poll struct {
+	Options    []PollOption `json:"options"`
+	ClosesAt   time.Time    `json:"closes_at"`
+	Closed     bool         `json:"closed"`
+	TotalVotes *int         `json:"total_votes,omitempty"`
+	ViewerVote *int         `json:"viewer_vote"`
+}
+
+type PollOption struct {
+	Text  string `json:"text"`
+	Votes *int   `json:"votes,omitempty"`
+}
+
+func (cs *chirpyService) pollResponse(c database.Chirp, v viewer) *Poll {
+	results, err := cs.db.GetPollResults(c.ID, v.ID)
+	if err != nil {
+		log.Printf("Unable to get poll results from database: %s", err)
+		return nil
+	}
+	poll := &Poll{
+		Options:  make([]PollOption, 0, len(c.Poll.Options)),
+		ClosesAt: c.Poll.ClosesAt,
+		Closed:   !time.Now().Before(c.Poll.ClosesAt),
+	}
+	if v.ID != 0 && results.UserVote >= 0 {
+		poll.ViewerVote = &results.UserVote
+	}
+	showResults := poll.Closed || poll.ViewerVote != nil || (v.ID != 0 && v.ID == c.AuthorID)
+	if showResults {
+		poll.TotalVotes = &results.Total
+	}
+	for i, text := range c.Poll.Options {
+		option := PollOption{Text: text}
+		if showResults {
+			option.Votes = &results.Counts[i]
+		}
+		poll.Options = append(poll.Options, option)
+	}
+	return poll
+}
+
+// buildPoll validates and moderates a poll to attach to a new chirp.
+func (cs *chirpyService) buildPoll(pr pollRequest) (*database.Poll, error) {
+	if len(pr.Options) < minPollOptions || len(pr.Options) > maxPollOptions {
+		return nil, fmt.Errorf("Polls must have %d-%d options", minPollOptions, maxPollOptions)
+	}
+	until := time.Until(pr.ClosesAt)
+	if until < minPollDuration || until > maxPollDuration {
+		return nil, fmt.Errorf("Polls must close between %s and %s from now", minPollDuration, maxPollDuration)
+	}
+	poll := &database.Poll{ClosesAt: pr.ClosesAt.UTC()}
+	for _, option := range pr.Options {
+		option = strings.TrimSpace(option)
+		if option == "" || length.Chirp(option) > maxPollOptionLength {
+			return nil, fmt.Errorf("Poll options must be 1-%d characters", maxPollOptionLength)
+		}
+		mr := cs.moderator.Moderate(option)
+		if mr.Action == moderation.Reject {
+			return nil, errors.New("Poll option rejected: " + strings.Join(mr.Reasons, "; "))
+		}
+		poll.Options = append(poll.Options, mr.Body)
+	}
+	return poll, nil
+}
+
+func (cs *chirpyService) votePollHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	chirpIDStr := r.PathValue("chirpID")
+	chirpID, err := getIDFromPath(r, "chirpID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unexpected path value: %s", chirpIDStr))
+		return
+	}
+	vr, err := decodeBody[voteRequest](r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON request")
+		return
+	}
+	err = cs.db.VoteInPoll(chirpID, userID, vr.Option)
+	switch err {
+	case nil:
+	case database.ErrDoesNotExist:
+		respondWithError(w, http.StatusNotFound, "Poll not found")
+		return
+	case database.ErrPollClosed:
+		respondWithError(w, http.StatusConflict, "Poll is closed")
+		return
+	case database.ErrInvalidOption:
+		respondWithError(w, http.StatusBadRequest, "Invalid poll option")
+		return
+	default:
+		log.Printf("error recording vote in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to record vote")
+		return
+	}
+	chirp, err := cs.db.GetChirp(chirpID)
+	if err != nil {
+		log.Printf("Unable to get chirp from database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving chirp")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cs.chirpResponse(chirp, viewer{ID: userID}))
+}
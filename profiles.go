@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/thomasem/chirpy/internal/database"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+	maxAvatarLength      = 2048
+)
+
+var (
+	handlePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,15}$`)
+)
+
+// viewer is who a response is being rendered for. The zero value is an
+// anonymous visitor.
+type viewer struct {
+	ID    int
+	Admin bool
+}
+
+type profileRequest struct {
+	Handle      *string `json:"handle"`
+	DisplayName *string `json:"display_name"`
+	Bio         *string `json:"bio"`
+	Avatar      *string `json:"avatar"`
+	// DMsFromFollowingOnly limits who can message the user to people they
+	// follow
+	DMsFromFollowingOnly *bool `json:"dms_from_following_only"`
+	// Protected makes the user approve followers. Unprotecting approves
+	// everyone waiting.
+	Protected *bool `json:"protected"`
+}
+
+// getViewer identifies the caller on endpoints where authentication is
+// optional. Missing or invalid tokens are treated as anonymous.
+func (cs *chirpyService) getViewer(r *http.Request) viewer {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		return viewer{}
+	}
+	user, err := cs.db.GetUser(userID)
+	if err != nil {
+		return viewer{}
+	}
+	return viewer{ID: user.ID, Admin: user.Admin}
+}
+
+func validateProfile(handle string, displayName string, bio string, avatar string) error {
+	err := validateHandle(handle)
+	if err != nil {
+		return err
+	}
+	return validateProfileFields(displayName, bio, avatar)
+}
+
+func validateHandle(handle string) error {
+	if !handlePattern.MatchString(handle) {
+		return errors.New("handle must be 3-15 letters, digits or underscores")
+	}
+	return nil
+}
+
+// validateProfileFields checks the parts of a profile other than the handle.
+func validateProfileFields(displayName string, bio string, avatar string) error {
+	if utf8.RuneCountInString(displayName) > maxDisplayNameLength {
+		return errors.New("display name is too long")
+	}
+	if utf8.RuneCountInString(bio) > maxBioLength {
+		return errors.New("bio is too long")
+	}
+	if len(avatar) > maxAvatarLength {
+		return errors.New("avatar reference is too long")
+	}
+	return nil
+}
+
+func (cs *chirpyService) getProfileHandler(w http.ResponseWriter, r *http.Request) {
+	v := cs.getViewer(r)
+	user, err := cs.db.GetUserByHandle(r.PathValue("handle"))
+	if err == database.ErrDoesNotExist || (err == nil && user.Deactivated() && !v.Admin) {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Unable to get user from database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving user")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cs.userResponse(user, v))
+}
+
+func (cs *chirpyService) updateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	pr, err := decodeBody[profileRequest](r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON request")
+		return
+	}
+	user, err := cs.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	handle, displayName, bio, avatar := user.Handle, user.DisplayName, user.Bio, user.Avatar
+	if pr.Handle != nil {
+		handle = *pr.Handle
+	}
+	if pr.DisplayName != nil {
+		displayName = *pr.DisplayName
+	}
+	if pr.Bio != nil {
+		bio = *pr.Bio
+	}
+	if pr.Avatar != nil {
+		avatar = *pr.Avatar
+	}
+	// Only a handle being set is checked, so that users from before handles
+	// existed can edit the rest of their profile without choosing one
+	if pr.Handle != nil {
+		err = validateHandle(handle)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	err = validateProfileFields(displayName, bio, avatar)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if handle != user.Handle {
+		_, err = cs.db.ChangeHandle(userID, handle)
+		if err == database.ErrAlreadyExists || err == database.ErrHandleReserved {
+			respondWithError(w, http.StatusConflict, "Handle is not available")
+			return
+		}
+		if err != nil {
+			log.Printf("error changing handle in database: %s", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to change handle")
+			return
+		}
+	}
+	if pr.DMsFromFollowingOnly != nil && *pr.DMsFromFollowingOnly != user.DMsFromFollowingOnly {
+		_, err = cs.db.SetDMsFromFollowingOnly(userID, *pr.DMsFromFollowingOnly)
+		if err != nil {
+			log.Printf("error updating message settings in database: %s", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to update profile")
+			return
+		}
+	}
+	if pr.Protected != nil && *pr.Protected != user.Protected {
+		_, approved, err := cs.db.SetProtected(userID, *pr.Protected)
+		if err != nil {
+			log.Printf("error updating account protection in database: %s", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to update profile")
+			return
+		}
+		for _, id := range approved {
+			cs.bus.Publish(UserFollowed{FollowerID: id, FolloweeID: userID, Approved: true})
+		}
+	}
+	updated, err := cs.db.UpdateProfile(userID, displayName, bio, avatar)
+	if err != nil {
+		log.Printf("error updating profile in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to update profile")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cs.userResponse(updated, viewer{ID: updated.ID}))
+}
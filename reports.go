@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+	"unicode/utf8"
+
+	"github.com/thomasem/chirpy/internal/database"
+)
+
+const (
+	maxReportDetailsLength = 500
+)
+
+type reportRequest struct {
+	Reason  database.ReportReason `json:"reason"`
+	Details string                `json:"details"`
+}
+
+type resolveRequest struct {
+	Action database.ModerationAction `json:"action"`
+	Note   string                    `json:"note"`
+}
+
+type Report struct {
+	ID         int                       `json:"id"`
+	ReporterID int                       `json:"reporter_id,omitempty"`
+	ChirpID    int                       `json:"chirp_id,omitempty"`
+	UserID     int                       `json:"user_id"`
+	Reason     database.ReportReason     `json:"reason"`
+	Details    string                    `json:"details,omitempty"`
+	Status     database.ReportStatus     `json:"status"`
+	ClaimedBy  int                       `json:"claimed_by,omitempty"`
+	Resolution database.ModerationAction `json:"resolution,omitempty"`
+	CreatedAt  time.Time                 `json:"created_at"`
+	ResolvedAt *time.Time                `json:"resolved_at,omitempty"`
+}
+
+func validateReport(rr reportRequest) error {
+	if !slices.Contains(database.ReportReasons, rr.Reason) {
+		return fmt.Errorf("reason must be one of %v", database.ReportReasons)
+	}
+	if utf8.RuneCountInString(rr.Details) > maxReportDetailsLength {
+		return fmt.Errorf("details must be at most %d characters", maxReportDetailsLength)
+	}
+	return nil
+}
+
+func (cs *chirpyService) reportChirpHandler(w http.ResponseWriter, r *http.Request) {
+	cs.fileReport(w, r, "chirpID", cs.db.ReportChirp)
+}
+
+func (cs *chirpyService) reportUserHandler(w http.ResponseWriter, r *http.Request) {
+	cs.fileReport(w, r, "userID", cs.db.ReportUser)
+}
+
+func (cs *chirpyService) fileReport(w http.ResponseWriter, r *http.Request, pathName string, file func(int, int, database.ReportReason, string) (database.Report, error)) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	targetID, err := getIDFromPath(r, pathName)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unexpected path value: %s", r.PathValue(pathName)))
+		return
+	}
+	rr, err := decodeBody[reportRequest](r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON request")
+		return
+	}
+	err = validateReport(rr)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	report, err := file(userID, targetID, rr.Reason, rr.Details)
+	if err == database.ErrDoesNotExist {
+		respondWithError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	if err == database.ErrAlreadyExists {
+		respondWithError(w, http.StatusConflict, "You have already reported this")
+		return
+	}
+	if err != nil {
+		log.Printf("error filing report in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to file report")
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, Report(report))
+}
+
+// getReportsHandler lists the moderation queue: unresolved reports, oldest
+// first, unless a "status" is given.
+func (cs *chirpyService) getReportsHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cs.requireAdmin(w, r); !ok {
+		return
+	}
+	statuses := []database.ReportStatus{database.ReportOpen, database.ReportClaimed}
+	if s := r.URL.Query().Get("status"); s != "" {
+		statuses = []database.ReportStatus{database.ReportStatus(s)}
+	}
+	reports := cs.db.GetReports(statuses...)
+	response := make([]Report, 0, len(reports))
+	for _, report := range reports {
+		response = append(response, Report(report))
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cs *chirpyService) claimReportHandler(w http.ResponseWriter, r *http.Request) {
+	v, ok := cs.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	reportID, err := getIDFromPath(r, "reportID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid report ID in URL")
+		return
+	}
+	report, err := cs.db.ClaimReport(reportID, v.ID)
+	if respondWithReportError(w, err) {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, Report(report))
+}
+
+func (cs *chirpyService) resolveReportHandler(w http.ResponseWriter, r *http.Request) {
+	v, ok := cs.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	reportID, err := getIDFromPath(r, "reportID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid report ID in URL")
+		return
+	}
+	rr, err := decodeBody[resolveRequest](r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON request")
+		return
+	}
+	report, err := cs.db.ResolveReport(reportID, v.ID, rr.Action, rr.Note)
+	if respondWithReportError(w, err) {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, Report(report))
+}
+
+// respondWithReportError responds to errors claiming or resolving a report,
+// returning whether there was one.
+func respondWithReportError(w http.ResponseWriter, err error) bool {
+	switch err {
+	case nil:
+		return false
+	case database.ErrDoesNotExist:
+		respondWithError(w, http.StatusNotFound, "Report not found")
+	case database.ErrReportClaimed:
+		respondWithError(w, http.StatusConflict, "Report is claimed by another moderator")
+	case database.ErrReportResolved:
+		respondWithError(w, http.StatusConflict, "Report is already resolved")
+	case database.ErrInvalidAction:
+		respondWithError(w, http.StatusBadRequest, "Action must be dismiss, hide_chirp (for chirp reports) or suspend_user")
+	default:
+		log.Printf("error updating report in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to update report")
+	}
+	return true
+}
+
+func (cs *chirpyService) getAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cs.requireAdmin(w, r); !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cs.db.GetAuditLog())
+}
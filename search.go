@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/thomasem/chirpy/internal/database"
+	"github.com/thomasem/chirpy/internal/search"
+)
+
+func getSearchSort(r *http.Request) database.SearchSort {
+	if r.URL.Query().Get("sort") == "recent" {
+		return database.SortRecent
+	}
+	return database.SortRelevance
+}
+
+// getOffset reads the "offset" query parameter used by listings that aren't
+// ordered by ID, such as relevance-ranked search results.
+func getOffset(r *http.Request) (int, error) {
+	offsetString := r.URL.Query().Get("offset")
+	if offsetString == "" {
+		return 0, nil
+	}
+	offset, err := strconv.Atoi(offsetString)
+	if err != nil || offset < 0 {
+		return 0, strconv.ErrSyntax
+	}
+	return offset, nil
+}
+
+func (cs *chirpyService) searchHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := search.Parse(r.URL.Query().Get("q"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if q.Empty() {
+		respondWithError(w, http.StatusBadRequest, "Search query missing")
+		return
+	}
+	limit, _, err := getPagination(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	offset, err := getOffset(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid offset")
+		return
+	}
+	dq := database.SearchQuery{
+		Terms:    q.Terms,
+		Phrases:  q.Phrases,
+		Hashtags: q.Hashtags,
+		Since:    q.Since,
+		Until:    q.Until,
+		Sort:     getSearchSort(r),
+	}
+	for _, handle := range q.From {
+		user, err := cs.db.GetUserByHandle(handle)
+		if err != nil {
+			// An unknown author can't have written anything
+			respondWithJSON(w, http.StatusOK, []Chirp{})
+			return
+		}
+		dq.AuthorIDs = append(dq.AuthorIDs, user.ID)
+	}
+	v := cs.getViewer(r)
+	chirps := cs.db.SearchChirps(v.ID, dq, offset, limit)
+	respondWithJSON(w, http.StatusOK, cs.chirpsResponse(chirps, v))
+}
@@ -2,16 +2,23 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/thomasem/chirpy/internal/auth"
+	"github.com/thomasem/chirpy/internal/blob"
 	"github.com/thomasem/chirpy/internal/database"
+	"github.com/thomasem/chirpy/internal/events"
+	"github.com/thomasem/chirpy/internal/length"
+	"github.com/thomasem/chirpy/internal/moderation"
+	"github.com/thomasem/chirpy/internal/stream"
 )
 
 // TODOs:
@@ -27,17 +34,43 @@ const (
 
 	jwtExpiresInSeconds = 60 * 60           // 1 hour
 	rtExpiresInSeconds  = 60 * 60 * 24 * 60 // 60 days
+
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+var (
+	errTokenMissing = errors.New("token missing from request")
+	errDeactivated  = errors.New("user is suspended or being deleted")
+	errUserDeleted  = errors.New("user no longer exists")
 )
 
 type User struct {
-	ID          int    `json:"id"`
-	Email       string `json:"email"`
-	IsChirpyRed bool   `json:"is_chirpy_red"`
+	ID             int    `json:"id"`
+	Email          string `json:"email,omitempty"`
+	Handle         string `json:"handle"`
+	DisplayName    string `json:"display_name"`
+	Bio            string `json:"bio"`
+	Avatar         string `json:"avatar"`
+	IsChirpyRed    bool   `json:"is_chirpy_red"`
+	ChirpCount     int    `json:"chirp_count"`
+	FollowerCount  int    `json:"follower_count"`
+	FollowingCount int    `json:"following_count"`
+	// Protected accounts approve their followers, and only share their
+	// chirps with them
+	Protected bool `json:"protected"`
+	// Only shown to the user themselves and admins
+	Suspended            bool                   `json:"suspended,omitempty"`
+	DeletionScheduledAt  *time.Time             `json:"deletion_scheduled_at,omitempty"`
+	Subscription         *database.Subscription `json:"subscription,omitempty"`
+	DMsFromFollowingOnly *bool                  `json:"dms_from_following_only,omitempty"`
 }
 
 type userRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email       string `json:"email"`
+	Password    string `json:"password"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"display_name"`
 }
 
 type loginRequest struct {
@@ -56,44 +89,71 @@ type JWT struct {
 }
 
 type Chirp struct {
-	ID       int    `json:"id"`
-	AuthorID int    `json:"author_id"`
-	Body     string `json:"body"`
+	ID          int                 `json:"id"`
+	AuthorID    int                 `json:"author_id"`
+	Body        string              `json:"body"`
+	Entities    []Entity            `json:"entities"`
+	CreatedAt   time.Time           `json:"created_at"`
+	Edited      bool                `json:"edited"`
+	EditedAt    *time.Time          `json:"edited_at,omitempty"`
+	Attachments []Attachment        `json:"attachments"`
+	Poll        *Poll               `json:"poll,omitempty"`
+	Pinned      bool                `json:"pinned,omitempty"`
+	Hidden      bool                `json:"hidden,omitempty"`
+	Visibility  database.Visibility `json:"visibility"`
 }
 
 type chirpRequest struct {
-	Body string `json:"body"`
-}
-
-type polkaEventData struct {
-	UserID int `json:"user_id"`
-}
-
-type polkaEvent struct {
-	Event string         `json:"event"`
-	Data  polkaEventData `json:"data"`
+	Body          string       `json:"body"`
+	AttachmentIDs []int        `json:"attachment_ids"`
+	Poll          *pollRequest `json:"poll"`
+	// Visibility is public, followers or unlisted. It defaults to public for
+	// new chirps, and is left as it was when editing.
+	Visibility string `json:"visibility"`
+}
+
+// moderator decides whether a chirp body may be posted, and how it should be
+// altered first.
+type moderator interface {
+	Moderate(body string) moderation.Result
+}
+
+// serviceConfig holds the settings chirpyService is started with.
+type serviceConfig struct {
+	jwtSecret string
+	// polkaKeys are the secrets Polka may sign webhooks with: the current one
+	// and, while rotating, the previous one.
+	polkaKeys []string
+	// editWindow is how long after posting authors may edit a chirp;
+	// redEditWindow is the same for Chirpy Red users.
+	editWindow    time.Duration
+	redEditWindow time.Duration
+	// deletionGrace is how long deleted accounts wait before they're purged,
+	// during which logging in restores them.
+	deletionGrace time.Duration
 }
 
 type chirpyService struct {
 	fileserverHits int
+	eventCounts    map[string]int
 	metricsMux     *sync.RWMutex
 	db             *database.DB
-	jwtSecret      string
-	polkaKey       string
-}
-
-func cleanBody(body string) string {
-	badWords := []string{"kerfuffle", "sharbert", "fornax"}
-	words := strings.Split(body, " ")
-	for i := range words {
-		for _, bw := range badWords {
-			if strings.ToLower(words[i]) == bw {
-				words[i] = "****"
-				break
-			}
-		}
-	}
-	return strings.Join(words, " ")
+	moderator      moderator
+	blobs          blob.Store
+	config         serviceConfig
+	// schedulerWake nudges the scheduler to re-check when the next scheduled
+	// chirp is due
+	schedulerWake chan struct{}
+	// webhookWake nudges the webhook dispatcher when deliveries are queued
+	webhookWake   chan struct{}
+	webhookClient *http.Client
+	// bus carries domain events from handlers to the features that react
+	// to them
+	bus    *events.Bus
+	stream *stream.Hub
+	// sockets counts each user's open WebSocket connections
+	sockets    map[int]int
+	socketsMux *sync.Mutex
 }
 
 func getTokenFromRequest(r *http.Request) string {
@@ -101,11 +161,6 @@ func getTokenFromRequest(r *http.Request) string {
 	return strings.TrimSpace(strings.TrimPrefix(av, "Bearer"))
 }
 
-func getAPIKeyFromRequest(r *http.Request) string {
-	av := r.Header.Get(authorizationHeader)
-	return strings.TrimSpace(strings.TrimPrefix(av, "ApiKey"))
-}
-
 func getAuthorID(r *http.Request) (int, error) {
 	authorIDString := r.URL.Query().Get("author_id")
 	if authorIDString == "" {
@@ -122,6 +177,32 @@ func getSortOrder(r *http.Request) database.SortOrder {
 	return database.Asc
 }
 
+// getPagination reads the "limit" and "before" cursor query parameters used by
+// paginated, newest-first listings. "before" is the ID of the last item on the
+// previous page.
+func getPagination(r *http.Request) (limit int, before int, err error) {
+	limit = defaultPageLimit
+	q := r.URL.Query()
+	if ls := q.Get("limit"); ls != "" {
+		limit, err = strconv.Atoi(ls)
+		if err != nil || limit < 1 {
+			return 0, 0, fmt.Errorf("invalid limit: %s", ls)
+		}
+		limit = min(limit, maxPageLimit)
+	}
+	if bs := q.Get("before"); bs != "" {
+		before, err = strconv.Atoi(bs)
+		if err != nil || before < 1 {
+			return 0, 0, fmt.Errorf("invalid before cursor: %s", bs)
+		}
+	}
+	return limit, before, nil
+}
+
+func getIDFromPath(r *http.Request, name string) (int, error) {
+	return strconv.Atoi(r.PathValue(name))
+}
+
 func decodeBody[T any](r *http.Request) (T, error) {
 	var dst T
 	decoder := json.NewDecoder(r.Body)
@@ -150,17 +231,98 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 
 func (cs *chirpyService) generateJWT(userID int, expiresInSeconds int) (string, error) {
 	subject := fmt.Sprintf("%v", userID)
-	return auth.NewJWT(subject, cs.jwtSecret, expiresInSeconds)
+	return auth.NewJWT(subject, cs.config.jwtSecret, expiresInSeconds)
 }
 
 func (cs *chirpyService) getUserIDFromJWT(jwtString string) (int, error) {
-	claims, err := auth.GetClaimsFromJWT(jwtString, cs.jwtSecret)
+	claims, err := auth.GetClaimsFromJWT(jwtString, cs.config.jwtSecret)
 	if err != nil {
 		return 0, err
 	}
 	return strconv.Atoi(claims.Subject)
 }
 
+// getUserIDFromRequest authenticates the request by its Bearer JWT. Tokens of
+// suspended users, of users waiting to be deleted, and of deleted users are
+// refused.
+func (cs *chirpyService) getUserIDFromRequest(r *http.Request) (int, error) {
+	token := getTokenFromRequest(r)
+	if token == "" {
+		return 0, errTokenMissing
+	}
+	userID, err := cs.getUserIDFromJWT(token)
+	if err != nil {
+		return 0, err
+	}
+	user, err := cs.db.GetUser(userID)
+	if errors.Is(err, database.ErrDoesNotExist) {
+		return 0, errUserDeleted
+	}
+	if err != nil {
+		return 0, err
+	}
+	if user.Deactivated() {
+		return 0, errDeactivated
+	}
+	return userID, nil
+}
+
+// userResponse renders u for v. Email addresses and account state are private
+// to the user themselves and admins.
+func (cs *chirpyService) userResponse(u database.User, v viewer) User {
+	stats := cs.db.GetUserStats(u.ID)
+	user := User{
+		ID:             u.ID,
+		Handle:         u.Handle,
+		DisplayName:    u.DisplayName,
+		Bio:            u.Bio,
+		Avatar:         u.Avatar,
+		IsChirpyRed:    u.HasChirpyRed(time.Now()),
+		ChirpCount:     stats.Chirps,
+		FollowerCount:  stats.Followers,
+		FollowingCount: stats.Following,
+		Protected:      u.Protected,
+	}
+	if v.ID == u.ID || v.Admin {
+		user.Email = u.Email
+		user.Suspended = u.Suspended
+		user.DeletionScheduledAt = u.DeletionScheduledAt
+		user.Subscription = u.Subscription
+		user.DMsFromFollowingOnly = &u.DMsFromFollowingOnly
+	}
+	return user
+}
+
+// chirpResponse renders c for v. Poll results depend on whether v has voted.
+func (cs *chirpyService) chirpResponse(c database.Chirp, v viewer) Chirp {
+	chirp := Chirp{
+		ID:          c.ID,
+		AuthorID:    c.AuthorID,
+		Body:        c.Body,
+		Entities:    entitiesResponse(c.Entities),
+		CreatedAt:   c.CreatedAt,
+		Attachments: cs.attachmentsResponse(c.AttachmentIDs),
+		Hidden:      c.Hidden,
+		Visibility:  c.EffectiveVisibility(),
+	}
+	if !c.EditedAt.IsZero() {
+		chirp.Edited = true
+		chirp.EditedAt = &c.EditedAt
+	}
+	if c.Poll != nil {
+		chirp.Poll = cs.pollResponse(c, v)
+	}
+	return chirp
+}
+
+func (cs *chirpyService) chirpsResponse(chirps []database.Chirp, v viewer) []Chirp {
+	response := make([]Chirp, 0, len(chirps))
+	for _, chirp := range chirps {
+		response = append(response, cs.chirpResponse(chirp, v))
+	}
+	return response
+}
+
 func (cs *chirpyService) readyHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set(contentTypeHeader, textPlainContentType)
 	w.WriteHeader(http.StatusOK)
@@ -183,6 +345,8 @@ func (cs *chirpyService) metricsHandler(w http.ResponseWriter, r *http.Request)
 		<body>
 			<h1>Welcome, Chirpy Admin</h1>
 			<p>Chirpy has been visited %d times!</p>
+			<h2>Events</h2>
+			<ul>%s</ul>
 		</body>
 
 		</html>
@@ -191,7 +355,23 @@ func (cs *chirpyService) metricsHandler(w http.ResponseWriter, r *http.Request)
 	w.WriteHeader(http.StatusOK)
 	cs.metricsMux.RLock()
 	defer cs.metricsMux.RUnlock()
-	fmt.Fprintf(w, template, cs.fileserverHits)
+	names := make([]string, 0, len(cs.eventCounts))
+	for name := range cs.eventCounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var counts strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&counts, "<li>%s: %d</li>", name, cs.eventCounts[name])
+	}
+	fmt.Fprintf(w, template, cs.fileserverHits, counts.String())
+}
+
+// countEvent tallies domain events by name for the metrics page.
+func (cs *chirpyService) countEvent(e events.Event) {
+	cs.metricsMux.Lock()
+	defer cs.metricsMux.Unlock()
+	cs.eventCounts[e.Name()]++
 }
 
 func (cs *chirpyService) resetHandler(w http.ResponseWriter, r *http.Request) {
@@ -209,7 +389,7 @@ func (cs *chirpyService) createChirpHandler(w http.ResponseWriter, r *http.Reque
 		respondWithError(w, http.StatusUnauthorized, "Token missing from request")
 		return
 	}
-	userID, err := cs.getUserIDFromJWT(token)
+	userID, err := cs.getUserIDFromRequest(r)
 	if err != nil {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
@@ -219,26 +399,112 @@ func (cs *chirpyService) createChirpHandler(w http.ResponseWriter, r *http.Reque
 		respondWithError(w, http.StatusBadRequest, "Invalid JSON request")
 		return
 	}
-	if cr.Body == "" {
-		respondWithError(w, http.StatusBadRequest, "Chirp body missing")
+	author, err := cs.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
 		return
 	}
-	if len(cr.Body) > 140 {
-		respondWithError(w, http.StatusBadRequest, "Chirp is too long")
+	chirp, ok := cs.prepareChirp(w, author, cr.Body, cr.AttachmentIDs)
+	if !ok {
+		return
+	}
+	chirp.Visibility, err = parseVisibility(cr.Visibility, database.VisibilityPublic)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if cr.Poll != nil {
+		chirp.Poll, err = cs.buildPoll(*cr.Poll)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	newChirp, err := cs.db.CreateChirp(chirp)
+	if err == database.ErrInvalidAttachment {
+		respondWithError(w, http.StatusBadRequest, invalidAttachmentsMessage)
 		return
 	}
-
-	newChirp, err := cs.db.CreateChirp(cleanBody(cr.Body), userID)
 	if err != nil {
 		log.Printf("error creating chirp in database: %s", err)
 		respondWithError(w, http.StatusInternalServerError, "Failed to create new chirp")
 		return
 	}
-	respondWithJSON(w, http.StatusCreated, Chirp{
-		ID:       newChirp.ID,
-		AuthorID: newChirp.AuthorID,
-		Body:     newChirp.Body,
-	})
+	cs.bus.Publish(ChirpCreated{Chirp: newChirp})
+	respondWithJSON(w, http.StatusCreated, cs.chirpResponse(newChirp, viewer{ID: userID}))
+}
+
+// invalidChirpError explains to its author why a chirp can't be posted.
+type invalidChirpError struct {
+	msg string
+}
+
+func (e invalidChirpError) Error() string {
+	return e.msg
+}
+
+// buildChirp validates, moderates and parses a chirp body written by author.
+// The body may only be empty if the chirp has attachments.
+func (cs *chirpyService) buildChirp(author database.User, body string, attachmentIDs []int) (database.Chirp, error) {
+	if body == "" && len(attachmentIDs) == 0 {
+		return database.Chirp{}, invalidChirpError{"Chirp body missing"}
+	}
+	err := checkChirpLimits(author, body, attachmentIDs)
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	mr := cs.moderator.Moderate(body)
+	if mr.Action == moderation.Reject {
+		return database.Chirp{}, invalidChirpError{"Chirp rejected: " + strings.Join(mr.Reasons, "; ")}
+	}
+	entities := cs.parseEntities(mr.Body)
+	for _, e := range entities {
+		if e.Type == database.EntityMention && cs.db.IsBlocked(author.ID, e.UserID) {
+			return database.Chirp{}, invalidChirpError{"You cannot mention @" + e.Text}
+		}
+	}
+	return database.Chirp{
+		AuthorID:      author.ID,
+		Body:          mr.Body,
+		Entities:      entities,
+		Flags:         mr.Reasons,
+		AttachmentIDs: attachmentIDs,
+	}, nil
+}
+
+// parseVisibility validates the visibility a chirp was requested with,
+// returning def if none was given.
+func parseVisibility(s string, def database.Visibility) (database.Visibility, error) {
+	if s == "" {
+		return def, nil
+	}
+	v := database.Visibility(s)
+	if !v.Valid() {
+		return "", invalidChirpError{"Invalid visibility"}
+	}
+	return v, nil
+}
+
+func checkChirpLimits(author database.User, body string, attachmentIDs []int) error {
+	limit := chirpLimit(author)
+	if length.Chirp(body) > limit {
+		return invalidChirpError{fmt.Sprintf("Chirp is too long (limit is %d characters)", limit)}
+	}
+	if len(attachmentIDs) > database.MaxAttachmentsPerChirp {
+		return invalidChirpError{fmt.Sprintf("Chirps can have at most %d attachments", database.MaxAttachmentsPerChirp)}
+	}
+	return nil
+}
+
+// prepareChirp is buildChirp for handlers, responding with an error if the
+// chirp can't be posted.
+func (cs *chirpyService) prepareChirp(w http.ResponseWriter, author database.User, body string, attachmentIDs []int) (database.Chirp, bool) {
+	chirp, err := cs.buildChirp(author, body, attachmentIDs)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return database.Chirp{}, false
+	}
+	return chirp, true
 }
 
 func (cs *chirpyService) deleteChirpHandler(w http.ResponseWriter, r *http.Request) {
@@ -247,7 +513,7 @@ func (cs *chirpyService) deleteChirpHandler(w http.ResponseWriter, r *http.Reque
 		respondWithError(w, http.StatusUnauthorized, "Token missing from request")
 		return
 	}
-	userID, err := cs.getUserIDFromJWT(token)
+	userID, err := cs.getUserIDFromRequest(r)
 	if err != nil {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
@@ -272,11 +538,14 @@ func (cs *chirpyService) deleteChirpHandler(w http.ResponseWriter, r *http.Reque
 		w.WriteHeader(http.StatusForbidden)
 		return
 	}
+	attachments := cs.db.GetAttachments(chirp.AttachmentIDs)
 	err = cs.db.DeleteChirp(chirpID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to delete chirp")
 		return
 	}
+	cs.deleteAttachmentBlobs(attachments)
+	cs.bus.Publish(ChirpDeleted{Chirp: chirp})
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -287,14 +556,11 @@ func (cs *chirpyService) getChirpsHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 	sort := getSortOrder(r)
-	chirps := cs.db.GetChirps(authorID, sort)
-	response := make([]Chirp, 0, len(chirps))
-	for _, chirp := range chirps {
-		response = append(response, Chirp{
-			ID:       chirp.ID,
-			AuthorID: chirp.AuthorID,
-			Body:     chirp.Body,
-		})
+	v := cs.getViewer(r)
+	chirps := cs.db.GetChirps(v.ID, authorID, sort)
+	response := cs.chirpsResponse(chirps, v)
+	if authorID != 0 {
+		response = cs.withPinnedFirst(authorID, response, v)
 	}
 	respondWithJSON(w, http.StatusOK, response)
 }
@@ -306,8 +572,9 @@ func (cs *chirpyService) getChirpHandler(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unexpected path value: %s", chirpIDStr))
 		return
 	}
+	v := cs.getViewer(r)
 	chirp, err := cs.db.GetChirp(chirpID)
-	if err == database.ErrDoesNotExist {
+	if err == database.ErrDoesNotExist || (err == nil && !cs.db.CanView(v.ID, chirp)) {
 		respondWithError(w, http.StatusNotFound, "Chirp not found")
 		return
 	}
@@ -316,11 +583,7 @@ func (cs *chirpyService) getChirpHandler(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusInternalServerError, "Error retrieving chirp")
 		return
 	}
-	respondWithJSON(w, http.StatusOK, Chirp{
-		ID:       chirp.ID,
-		AuthorID: chirp.AuthorID,
-		Body:     chirp.Body,
-	})
+	respondWithJSON(w, http.StatusOK, cs.chirpResponse(chirp, v))
 }
 
 func (cs *chirpyService) createUserHandler(w http.ResponseWriter, r *http.Request) {
@@ -329,31 +592,40 @@ func (cs *chirpyService) createUserHandler(w http.ResponseWriter, r *http.Reques
 		respondWithError(w, http.StatusBadRequest, "Invalid JSON request")
 		return
 	}
-	if ur.Email == "" || ur.Password == "" {
+	if ur.Email == "" || ur.Password == "" || ur.Handle == "" {
 		respondWithError(w, http.StatusBadRequest, "User missing required fields")
 		return
 	}
+	err = validateProfile(ur.Handle, ur.DisplayName, "", "")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	exists := cs.db.UserExists(ur.Email)
 	if exists {
 		respondWithError(w, http.StatusConflict, "User already exists")
 		return
 	}
+	if !cs.db.HandleAvailable(ur.Handle) {
+		respondWithError(w, http.StatusConflict, "Handle is not available")
+		return
+	}
 	pwHash, err := auth.PasswordStringToHash(ur.Password)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "User password invalid")
 		return
 	}
-	user, err := cs.db.CreateUser(ur.Email, pwHash)
+	user, err := cs.db.CreateUser(ur.Email, pwHash, ur.Handle, ur.DisplayName)
+	if err == database.ErrAlreadyExists || err == database.ErrHandleReserved {
+		respondWithError(w, http.StatusConflict, "User already exists")
+		return
+	}
 	if err != nil {
 		log.Printf("error creating new user in database: %s", err)
 		respondWithError(w, http.StatusInternalServerError, "Failed to create new user")
 		return
 	}
-	respondWithJSON(w, http.StatusCreated, User{
-		ID:          user.ID,
-		Email:       user.Email,
-		IsChirpyRed: user.ChirpyRed,
-	})
+	respondWithJSON(w, http.StatusCreated, cs.userResponse(user, viewer{ID: user.ID}))
 }
 
 func (cs *chirpyService) loginHandler(w http.ResponseWriter, r *http.Request) {
@@ -371,6 +643,20 @@ func (cs *chirpyService) loginHandler(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusUnauthorized, "Incorrect password")
 		return
 	}
+	if au.Suspended {
+		respondWithError(w, http.StatusForbidden, "Account suspended")
+		return
+	}
+	if au.DeletionScheduledAt != nil {
+		// Logging in during the grace period changes their mind
+		err = cs.db.CancelUserDeletion(au.ID)
+		if err != nil {
+			log.Printf("error cancelling user deletion in database: %s", err)
+			respondWithError(w, http.StatusInternalServerError, "Unable to restore account")
+			return
+		}
+		au.DeletionScheduledAt = nil
+	}
 	jwt, err := cs.generateJWT(au.ID, jwtExpiresInSeconds)
 	if err != nil {
 		log.Printf("error generating JWT: %s", err)
@@ -390,11 +676,7 @@ func (cs *chirpyService) loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	respondWithJSON(w, http.StatusOK, loginResponse{
-		User: User{
-			ID:          au.ID,
-			Email:       au.Email,
-			IsChirpyRed: au.ChirpyRed,
-		},
+		User:         cs.userResponse(au.User, viewer{ID: au.ID}),
 		Token:        jwt,
 		RefreshToken: refreshToken,
 	})
@@ -415,6 +697,11 @@ func (cs *chirpyService) refreshTokenHandler(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusUnauthorized, "Expired token")
 		return
 	}
+	user, err := cs.db.GetUser(rt.UserID)
+	if err != nil || user.Deactivated() {
+		respondWithError(w, http.StatusForbidden, "Account suspended or being deleted")
+		return
+	}
 	jwt, err := cs.generateJWT(rt.UserID, jwtExpiresInSeconds)
 	if err != nil {
 		log.Printf("error generating JWT: %s", err)
@@ -442,14 +729,11 @@ func (cs *chirpyService) refreshTokenRevokeHandler(w http.ResponseWriter, r *htt
 }
 
 func (cs *chirpyService) getUsersHandler(w http.ResponseWriter, r *http.Request) {
+	v := cs.getViewer(r)
 	users := cs.db.GetUsers()
 	response := make([]User, 0, len(users))
 	for _, user := range users {
-		response = append(response, User{
-			ID:          user.ID,
-			Email:       user.Email,
-			IsChirpyRed: user.ChirpyRed,
-		})
+		response = append(response, cs.userResponse(user, v))
 	}
 	respondWithJSON(w, http.StatusOK, response)
 }
@@ -465,7 +749,7 @@ func (cs *chirpyService) updateUserHandler(w http.ResponseWriter, r *http.Reques
 		respondWithError(w, http.StatusUnauthorized, "Token missing from request")
 		return
 	}
-	userID, err := cs.getUserIDFromJWT(token)
+	userID, err := cs.getUserIDFromRequest(r)
 	if err != nil {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
@@ -481,47 +765,36 @@ func (cs *chirpyService) updateUserHandler(w http.ResponseWriter, r *http.Reques
 		respondWithError(w, http.StatusInternalServerError, "Failed to update user")
 		return
 	}
-	respondWithJSON(w, http.StatusOK, User{
-		ID:          updated.ID,
-		Email:       updated.Email,
-		IsChirpyRed: updated.ChirpyRed,
-	})
-}
-
-func (cs *chirpyService) polkaWebhookHandler(w http.ResponseWriter, r *http.Request) {
-	key := getAPIKeyFromRequest(r)
-	if key != cs.polkaKey {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-	pe, err := decodeBody[polkaEvent](r)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid JSON request")
-		return
-	}
-	if pe.Event != "user.upgraded" {
-		w.WriteHeader(http.StatusNoContent)
-		return
-	}
-	err = cs.db.UpgradeUser(pe.Data.UserID)
-	if err == database.ErrDoesNotExist {
-		w.WriteHeader(http.StatusNotFound)
-		return
-	}
-	if err != nil {
-		log.Printf("error upgrading user in database: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "failed to upgrade user")
-		return
-	}
-	w.WriteHeader(http.StatusNoContent)
+	respondWithJSON(w, http.StatusOK, cs.userResponse(updated, viewer{ID: updated.ID}))
 }
 
-func NewChirpyService(db *database.DB, jwtSecret string, polkaKey string) *chirpyService {
-	return &chirpyService{
+func NewChirpyService(db *database.DB, mod moderator, blobs blob.Store, config serviceConfig) *chirpyService {
+	cs := &chirpyService{
 		db:             db,
+		moderator:      mod,
+		blobs:          blobs,
 		metricsMux:     &sync.RWMutex{},
 		fileserverHits: 0,
-		jwtSecret:      jwtSecret,
-		polkaKey:       polkaKey,
-	}
+		eventCounts:    make(map[string]int),
+		config:         config,
+		schedulerWake:  make(chan struct{}, 1),
+		webhookWake:    make(chan struct{}, 1),
+		webhookClient: &http.Client{
+			Timeout:   webhookTimeout,
+			Transport: newWebhookTransport(),
+			// Endpoints must answer themselves; a redirect is a failure.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		bus:        events.New(),
+		stream:     stream.NewHub(streamReplaySize),
+		sockets:    make(map[int]int),
+		socketsMux: &sync.Mutex{},
+	}
+	events.Subscribe(cs.bus, cs.countEvent)
+	cs.subscribeWebhooks()
+	cs.subscribeNotifications()
+	cs.subscribeStream()
+	return cs
 }
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/thomasem/chirpy/internal/blob"
+	"github.com/thomasem/chirpy/internal/database"
+	"github.com/thomasem/chirpy/internal/moderation"
+)
+
+// newTestService returns a service backed by an empty database and media
+// store in a temporary directory, with no moderation rules.
+func newTestService(t *testing.T) *chirpyService {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := database.NewDB(filepath.Join(dir, "database.json"), true)
+	if err != nil {
+		t.Fatalf("NewDB: %s", err)
+	}
+	blobs, err := blob.NewLocalStore(filepath.Join(dir, "media"))
+	if err != nil {
+		t.Fatalf("NewLocalStore: %s", err)
+	}
+	cs := NewChirpyService(db, moderation.NewPipeline(), blobs, serviceConfig{
+		jwtSecret:     "test-secret",
+		editWindow:    time.Hour,
+		redEditWindow: time.Hour,
+	})
+	t.Cleanup(cs.bus.Close)
+	return cs
+}
+
+func mustCreateUser(t *testing.T, cs *chirpyService, handle string) database.User {
+	t.Helper()
+	user, err := cs.db.CreateUser(handle+"@example.com", "hash", handle, "")
+	if err != nil {
+		t.Fatalf("CreateUser(%s): %s", handle, err)
+	}
+	return user
+}
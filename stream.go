@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/thomasem/chirpy/internal/database"
+	"github.com/thomasem/chirpy/internal/entities"
+	"github.com/thomasem/chirpy/internal/events"
+	"github.com/thomasem/chirpy/internal/stream"
+)
+
+const (
+	eventStreamContentType = "text/event-stream"
+	lastEventIDHeader      = "Last-Event-ID"
+
+	// streamReplaySize is how many recent events are kept for clients that
+	// reconnect with Last-Event-ID.
+	streamReplaySize = 1000
+	// streamBuffer is how many events may be waiting to be written to a
+	// client before it's disconnected for falling behind.
+	streamBuffer       = 64
+	streamHeartbeat    = 15 * time.Second
+	streamWriteTimeout = 10 * time.Second
+	streamRetry        = 3 * time.Second
+
+	// eventResync tells a reconnecting client that events it missed are no
+	// longer available, so it should refetch what it's showing.
+	eventResync = "resync"
+)
+
+// streamFilter selects the events a stream client is sent: either the
+// viewer's notifications, or chirps that match every filter that's set and
+// are visible to the viewer.
+type streamFilter struct {
+	viewer        viewer
+	authorID      int
+	hashtag       string
+	timeline      bool
+	notifications bool
+}
+
+func (cs *chirpyService) matchesStream(f streamFilter, chirp database.Chirp) bool {
+	// Unlisted chirps only go to streams of their author's chirps
+	if chirp.EffectiveVisibility() == database.VisibilityUnlisted && f.authorID == 0 && !f.timeline {
+		return false
+	}
+	if f.authorID != 0 && chirp.AuthorID != f.authorID {
+		return false
+	}
+	if f.hashtag != "" && !slices.ContainsFunc(chirp.Entities, func(e database.Entity) bool {
+		return e.Type == database.EntityHashtag && e.Text == f.hashtag
+	}) {
+		return false
+	}
+	if f.timeline && !cs.db.InTimeline(f.viewer.ID, chirp.AuthorID) {
+		return false
+	}
+	return cs.db.CanView(f.viewer.ID, chirp)
+}
+
+// subscribeStream feeds chirp events and notifications to the stream hub. It's
+// synchronous, so the events a request publishes get stream IDs in the order
+// it published them. Changes are published after they're committed and the
+// database lock is released, though, so events from concurrent requests may
+// be numbered in a different order than their changes were committed in.
+func (cs *chirpyService) subscribeStream() {
+	events.Subscribe(cs.bus, func(e ChirpCreated) {
+		cs.stream.Publish(eventChirpCreated, e.Chirp)
+	})
+	events.Subscribe(cs.bus, func(e ChirpDeleted) {
+		cs.stream.Publish(eventChirpDeleted, e.Chirp)
+	})
+	events.Subscribe(cs.bus, func(e NotificationCreated) {
+		cs.stream.Publish(eventNotificationCreated, e.Notification)
+	})
+}
+
+// streamData renders a stream event for a client, reporting false if the
+// event doesn't pass the client's filter.
+//
+// New chirps are read again rather than sent as they were published, since
+// the event may be replayed long after: chirps that have since been deleted,
+// hidden or shared more narrowly are left out, and edited ones are sent as
+// they are now.
+func (cs *chirpyService) streamData(f streamFilter, e stream.Event) (any, bool) {
+	switch data := e.Data.(type) {
+	case database.Chirp:
+		if f.notifications {
+			return nil, false
+		}
+		if e.Name == eventChirpCreated {
+			chirp, err := cs.db.GetChirp(data.ID)
+			if err != nil || !cs.matchesStream(f, chirp) {
+				return nil, false
+			}
+			return cs.chirpResponse(chirp, f.viewer), true
+		}
+		if !cs.matchesStream(f, data) {
+			return nil, false
+		}
+		return chirpDeletedData{ID: data.ID, AuthorID: data.AuthorID}, true
+	case database.Notification:
+		if !f.notifications || data.RecipientID != f.viewer.ID {
+			return nil, false
+		}
+		return cs.notificationResponse(data, f.viewer), true
+	}
+	return nil, false
+}
+
+// streamHandler serves new and deleted chirps as Server-Sent Events, filtered
+// by ?author_id=, ?hashtag= and ?timeline=true, the last of which needs a
+// token. Clients that reconnect with Last-Event-ID are sent the events they
+// missed, if they're still kept, or a resync event if not. Clients that fall
+// behind are disconnected and can reconnect the same way.
+func (cs *chirpyService) streamHandler(w http.ResponseWriter, r *http.Request) {
+	f := streamFilter{viewer: cs.getViewer(r)}
+	var err error
+	f.authorID, err = getAuthorID(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid author_id")
+		return
+	}
+	q := r.URL.Query()
+	f.hashtag = entities.NormalizeHashtag(q.Get("hashtag"))
+	f.timeline = q.Get("timeline") == "true"
+	if f.timeline && f.viewer.ID == 0 {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	var lastID uint64
+	if s := r.Header.Get(lastEventIDHeader); s != "" {
+		lastID, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid Last-Event-ID")
+			return
+		}
+	}
+
+	sub, missed, complete, err := cs.stream.Subscribe(lastID, streamBuffer)
+	if err != nil {
+		respondWithError(w, http.StatusServiceUnavailable, "Server is shutting down")
+		return
+	}
+	defer cs.stream.Unsubscribe(sub)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set(contentTypeHeader, eventStreamContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	write := func(format string, args ...any) error {
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		_, err := fmt.Fprintf(w, format, args...)
+		if err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	err = write("retry: %d\n\n", streamRetry.Milliseconds())
+	if err == nil && !complete {
+		err = write("event: %s\ndata: {}\n\n", eventResync)
+	}
+	for _, e := range missed {
+		if err != nil {
+			return
+		}
+		err = cs.writeStreamEvent(write, f, e)
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for err == nil {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.Done:
+			return
+		case e := <-sub.C:
+			err = cs.writeStreamEvent(write, f, e)
+		case <-heartbeat.C:
+			err = write(": heartbeat\n\n")
+		}
+	}
+}
+
+// writeStreamEvent sends an event to a client if it passes the client's
+// filter.
+func (cs *chirpyService) writeStreamEvent(write func(string, ...any) error, f streamFilter, e stream.Event) error {
+	data, ok := cs.streamData(f, e)
+	if !ok {
+		return nil
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("error encoding stream event: %s", err)
+		return nil
+	}
+	return write("id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Name, payload)
+}
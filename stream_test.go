@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thomasem/chirpy/internal/database"
+	"github.com/thomasem/chirpy/internal/stream"
+)
+
+func TestStreamRespectsVisibility(t *testing.T) {
+	cs := newTestService(t)
+	author := mustCreateUser(t, cs, "author")
+	follower := mustCreateUser(t, cs, "follower")
+	stranger := mustCreateUser(t, cs, "stranger")
+	if _, err := cs.db.FollowUser(follower.ID, author.ID); err != nil {
+		t.Fatalf("FollowUser: %s", err)
+	}
+	chirps := map[database.Visibility]database.Chirp{}
+	for _, v := range []database.Visibility{database.VisibilityPublic, database.VisibilityFollowers, database.VisibilityUnlisted} {
+		chirp, err := cs.db.CreateChirp(database.Chirp{
+			AuthorID:   author.ID,
+			Body:       "#tag",
+			Entities:   []database.Entity{{Type: database.EntityHashtag, Text: "tag", End: 4}},
+			Visibility: v,
+			CreatedAt:  time.Now().UTC(),
+		})
+		if err != nil {
+			t.Fatalf("CreateChirp: %s", err)
+		}
+		chirps[v] = chirp
+	}
+
+	type delivered struct{ public, followers, unlisted bool }
+	tests := []struct {
+		name   string
+		filter streamFilter
+		want   delivered
+	}{
+		{"anonymous, unfiltered", streamFilter{}, delivered{public: true}},
+		{"stranger, unfiltered", streamFilter{viewer: viewer{ID: stranger.ID}}, delivered{public: true}},
+		{"stranger, hashtag", streamFilter{viewer: viewer{ID: stranger.ID}, hashtag: "tag"}, delivered{public: true}},
+		{"stranger, author", streamFilter{viewer: viewer{ID: stranger.ID}, authorID: author.ID}, delivered{public: true, unlisted: true}},
+		{"follower, unfiltered", streamFilter{viewer: viewer{ID: follower.ID}}, delivered{public: true, followers: true}},
+		{"follower, hashtag", streamFilter{viewer: viewer{ID: follower.ID}, hashtag: "tag"}, delivered{public: true, followers: true}},
+		{"follower, timeline", streamFilter{viewer: viewer{ID: follower.ID}, timeline: true}, delivered{true, true, true}},
+		{"author, author", streamFilter{viewer: viewer{ID: author.ID}, authorID: author.ID}, delivered{true, true, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for v, want := range map[database.Visibility]bool{
+				database.VisibilityPublic:    tt.want.public,
+				database.VisibilityFollowers: tt.want.followers,
+				database.VisibilityUnlisted:  tt.want.unlisted,
+			} {
+				for _, name := range []string{eventChirpCreated, eventChirpDeleted} {
+					_, got := cs.streamData(tt.filter, stream.Event{ID: 1, Name: name, Data: chirps[v]})
+					if got != want {
+						t.Errorf("%s of %s chirp delivered = %v, want %v", name, v, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+type streamMessage struct {
+	id   string
+	name string
+	data string
+}
+
+// readStreamEvents reads n events from a Server-Sent Events stream, skipping
+// retry hints and heartbeats.
+func readStreamEvents(t *testing.T, r *bufio.Reader, n int) []streamMessage {
+	t.Helper()
+	var msgs []streamMessage
+	var msg streamMessage
+	for len(msgs) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading stream after %d events: %s", len(msgs), err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		field, value, _ := strings.Cut(line, ": ")
+		switch field {
+		case "id":
+			msg.id = value
+		case "event":
+			msg.name = value
+		case "data":
+			msg.data = value
+		case "":
+			if msg.name != "" {
+				msgs = append(msgs, msg)
+			}
+			msg = streamMessage{}
+		}
+	}
+	return msgs
+}
+
+func TestStreamReplayRereadsChirps(t *testing.T) {
+	cs := newTestService(t)
+	author := mustCreateUser(t, cs, "author")
+	moderator := mustCreateUser(t, cs, "moderator")
+	sub, _, _, err := cs.stream.Subscribe(0, streamBuffer)
+	if err != nil {
+		t.Fatalf("Subscribe: %s", err)
+	}
+	post := func(body string) database.Chirp {
+		t.Helper()
+		w := serveAs(t, cs, cs.createChirpHandler, author.ID, http.MethodPost, `{"body":"`+body+`"}`)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create: status %d: %s", w.Code, w.Body)
+		}
+		var c Chirp
+		if err := json.Unmarshal(w.Body.Bytes(), &c); err != nil {
+			t.Fatalf("decoding chirp: %s", err)
+		}
+		return database.Chirp{ID: c.ID}
+	}
+	post("seen before disconnecting")
+	lastID := (<-sub.C).ID
+	cs.stream.Unsubscribe(sub)
+
+	// While the client is away, one chirp is edited, one deleted and one
+	// hidden by a moderator
+	edited, deleted, hidden := post("first draft"), post("deleted"), post("hidden")
+	chirpID := func(c database.Chirp) string { return strconv.Itoa(c.ID) }
+	if w := serveAs(t, cs, cs.editChirpHandler, author.ID, http.MethodPatch, `{"body":"final draft"}`, "chirpID", chirpID(edited)); w.Code != http.StatusOK {
+		t.Fatalf("edit: status %d: %s", w.Code, w.Body)
+	}
+	if w := serveAs(t, cs, cs.deleteChirpHandler, author.ID, http.MethodDelete, "", "chirpID", chirpID(deleted)); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: status %d: %s", w.Code, w.Body)
+	}
+	report, err := cs.db.ReportChirp(moderator.ID, hidden.ID, database.ReasonSpam, "")
+	if err != nil {
+		t.Fatalf("ReportChirp: %s", err)
+	}
+	if _, err := cs.db.ResolveReport(report.ID, moderator.ID, database.ActionHideChirp, ""); err != nil {
+		t.Fatalf("ResolveReport: %s", err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(cs.streamHandler))
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(lastEventIDHeader, strconv.FormatUint(lastID, 10))
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("connecting to stream: %s", err)
+	}
+	defer resp.Body.Close()
+
+	// The deletion is the last event published, so once it arrives
+	// everything else that was going to be replayed has been
+	msgs := readStreamEvents(t, bufio.NewReader(resp.Body), 2)
+	if msgs[0].name != eventChirpCreated || !strings.Contains(msgs[0].data, `"final draft"`) {
+		t.Errorf("first replayed event = %+v, want the edited chirp as it is now", msgs[0])
+	}
+	want := fmt.Sprintf(`{"id":%d,"author_id":%d}`, deleted.ID, author.ID)
+	if msgs[1].name != eventChirpDeleted || msgs[1].data != want {
+		t.Errorf("second replayed event = %+v, want the deletion of chirp %d", msgs[1], deleted.ID)
+	}
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/thomasem/chirpy/internal/database"
+	"github.com/thomasem/chirpy/internal/events"
+	"github.com/thomasem/chirpy/internal/webhook"
+)
+
+const (
+	webhookSignatureHeader = "Chirpy-Signature"
+	webhookEventHeader     = "Chirpy-Event"
+	webhookDeliveryHeader  = "Chirpy-Delivery"
+	webhookUserAgent       = "Chirpy-Webhooks/1.0"
+
+	maxWebhookEndpoints = 5
+	webhookTimeout      = 10 * time.Second
+	// webhookLease is how long a delivery being sent is held back from
+	// other dispatcher passes, so one interrupted by a restart is retried.
+	webhookLease = 3 * webhookTimeout
+	webhookBatch = 20
+	// A failed delivery is retried after firstRetryDelay, doubling each time
+	// up to maxRetryDelay, and given up on after maxDeliveryAttempts.
+	maxDeliveryAttempts = 8
+	firstRetryDelay     = 30 * time.Second
+	maxRetryDelay       = 6 * time.Hour
+	// deliveryRetention is how long finished deliveries are kept in the log.
+	deliveryRetention = 30 * 24 * time.Hour
+)
+
+// webhookEvents are the event types endpoints can subscribe to. Each is sent
+// to the endpoints of the user it concerns: the author of a chirp or the
+// user being followed.
+var webhookEvents = []string{
+	eventChirpCreated,
+	eventChirpUpdated,
+	eventChirpDeleted,
+	eventUserFollowed,
+	eventUserUnfollowed,
+}
+
+type WebhookEndpoint struct {
+	ID     int      `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	// Only returned when the endpoint is created
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WebhookDelivery struct {
+	ID            int                        `json:"id"`
+	EndpointID    int                        `json:"endpoint_id"`
+	Event         string                     `json:"event"`
+	Payload       json.RawMessage            `json:"payload"`
+	Status        database.DeliveryStatus    `json:"status"`
+	Attempts      []database.DeliveryAttempt `json:"attempts"`
+	NextAttemptAt *time.Time                 `json:"next_attempt_at,omitempty"`
+	CreatedAt     time.Time                  `json:"created_at"`
+	CompletedAt   *time.Time                 `json:"completed_at,omitempty"`
+}
+
+type webhookEndpointRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// webhookPayload is the body of every delivery.
+type webhookPayload struct {
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+type chirpDeletedData struct {
+	ID       int `json:"id"`
+	AuthorID int `json:"author_id"`
+}
+
+type followEventData struct {
+	Follower   User `json:"follower"`
+	FolloweeID int  `json:"followee_id"`
+}
+
+func webhookEndpointResponse(e database.WebhookEndpoint) WebhookEndpoint {
+	return WebhookEndpoint{
+		ID:        e.ID,
+		URL:       e.URL,
+		Events:    e.Events,
+		CreatedAt: e.CreatedAt,
+	}
+}
+
+func webhookDeliveryResponse(d database.WebhookDelivery) WebhookDelivery {
+	delivery := WebhookDelivery{
+		ID:          d.ID,
+		EndpointID:  d.EndpointID,
+		Event:       d.Event,
+		Payload:     d.Payload,
+		Status:      d.Status,
+		Attempts:    orEmpty(d.Attempts),
+		CreatedAt:   d.CreatedAt,
+		CompletedAt: d.CompletedAt,
+	}
+	if d.Status == database.DeliveryPending {
+		delivery.NextAttemptAt = &d.NextAttemptAt
+	}
+	return delivery
+}
+
+// validateEndpoint checks a webhook endpoint request, returning its event
+// types without duplicates. URLs must point at public addresses; this is
+// checked again whenever a webhook is sent, in case the host's address
+// changes.
+func validateEndpoint(ctx context.Context, er webhookEndpointRequest) ([]string, error) {
+	u, err := url.Parse(er.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return nil, errors.New("Webhook URL must be an absolute http or https URL")
+	}
+	err = webhook.CheckHost(ctx, u.Hostname())
+	if err == webhook.ErrForbiddenAddress {
+		return nil, errors.New("Webhook URL must not point at a private or local address")
+	}
+	if err != nil {
+		return nil, errors.New("Webhook URL host could not be resolved")
+	}
+	if len(er.Events) == 0 {
+		return nil, errors.New("Webhook must subscribe to at least one event")
+	}
+	var events []string
+	for _, event := range er.Events {
+		if !slices.Contains(webhookEvents, event) {
+			return nil, fmt.Errorf("Unknown webhook event '%s'", event)
+		}
+		if !slices.Contains(events, event) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (cs *chirpyService) createWebhookEndpointHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	er, err := decodeBody[webhookEndpointRequest](r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON request")
+		return
+	}
+	events, err := validateEndpoint(r.Context(), er)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		log.Printf("error generating webhook secret: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create webhook")
+		return
+	}
+	endpoint, err := cs.db.CreateWebhookEndpoint(database.WebhookEndpoint{
+		OwnerID: userID,
+		URL:     er.URL,
+		Events:  events,
+		Secret:  secret,
+	}, maxWebhookEndpoints)
+	if err == database.ErrEndpointLimit {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("You can have at most %d webhooks", maxWebhookEndpoints))
+		return
+	}
+	if err != nil {
+		log.Printf("error creating webhook endpoint in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create webhook")
+		return
+	}
+	response := webhookEndpointResponse(endpoint)
+	response.Secret = endpoint.Secret
+	respondWithJSON(w, http.StatusCreated, response)
+}
+
+func (cs *chirpyService) getWebhookEndpointsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	endpoints := cs.db.GetWebhookEndpoints(userID)
+	response := make([]WebhookEndpoint, 0, len(endpoints))
+	for _, e := range endpoints {
+		response = append(response, webhookEndpointResponse(e))
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// getOwnEndpoint loads the webhook endpoint named in the URL, responding with
+// an error unless it belongs to userID.
+func (cs *chirpyService) getOwnEndpoint(w http.ResponseWriter, r *http.Request, userID int) (database.WebhookEndpoint, bool) {
+	endpointID, err := getIDFromPath(r, "endpointID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid webhook ID in URL")
+		return database.WebhookEndpoint{}, false
+	}
+	endpoint, err := cs.db.GetWebhookEndpoint(endpointID)
+	if err == database.ErrDoesNotExist || (err == nil && endpoint.OwnerID != userID) {
+		respondWithError(w, http.StatusNotFound, "Webhook not found")
+		return database.WebhookEndpoint{}, false
+	}
+	if err != nil {
+		log.Printf("Unable to get webhook endpoint from database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving webhook")
+		return database.WebhookEndpoint{}, false
+	}
+	return endpoint, true
+}
+
+func (cs *chirpyService) deleteWebhookEndpointHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	endpoint, ok := cs.getOwnEndpoint(w, r, userID)
+	if !ok {
+		return
+	}
+	err = cs.db.DeleteWebhookEndpoint(endpoint.ID)
+	if err != nil {
+		log.Printf("error deleting webhook endpoint in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete webhook")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getWebhookDeliveriesHandler lists an endpoint's deliveries, newest first.
+// ?status=dead lists the deliveries that were given up on.
+func (cs *chirpyService) getWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	endpoint, ok := cs.getOwnEndpoint(w, r, userID)
+	if !ok {
+		return
+	}
+	status := database.DeliveryStatus(r.URL.Query().Get("status"))
+	deliveries := cs.db.GetWebhookDeliveries(endpoint.ID, status)
+	response := make([]WebhookDelivery, 0, len(deliveries))
+	for _, d := range deliveries {
+		response = append(response, webhookDeliveryResponse(d))
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// redeliverWebhookHandler queues a finished delivery, usually a dead one, to
+// be sent again. A dead delivery gets one more attempt.
+func (cs *chirpyService) redeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := cs.getUserIDFromRequest(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	endpoint, ok := cs.getOwnEndpoint(w, r, userID)
+	if !ok {
+		return
+	}
+	deliveryID, err := getIDFromPath(r, "deliveryID")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid delivery ID in URL")
+		return
+	}
+	delivery, err := cs.db.GetWebhookDelivery(deliveryID)
+	if err == database.ErrDoesNotExist || (err == nil && delivery.EndpointID != endpoint.ID) {
+		respondWithError(w, http.StatusNotFound, "Delivery not found")
+		return
+	}
+	if err == nil {
+		delivery, err = cs.db.RedeliverWebhook(deliveryID)
+	}
+	if err != nil {
+		log.Printf("error redelivering webhook in database: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to redeliver webhook")
+		return
+	}
+	cs.wakeWebhookDispatcher()
+	respondWithJSON(w, http.StatusAccepted, webhookDeliveryResponse(delivery))
+}
+
+// emitWebhook queues event for the endpoints of ownerID that subscribe to it.
+func (cs *chirpyService) emitWebhook(ownerID int, event string, data any) {
+	if !cs.db.HasWebhookSubscribers(ownerID, event) {
+		return
+	}
+	payload, err := json.Marshal(webhookPayload{
+		Event:     event,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		log.Printf("error encoding %s webhook: %s", event, err)
+		return
+	}
+	_, err = cs.db.QueueWebhookDeliveries(ownerID, event, payload)
+	if err != nil {
+		log.Printf("error queueing %s webhook in database: %s", event, err)
+		return
+	}
+	cs.wakeWebhookDispatcher()
+}
+
+func (cs *chirpyService) emitChirpWebhook(event string, chirp database.Chirp) {
+	cs.emitWebhook(chirp.AuthorID, event, cs.chirpResponse(chirp, viewer{ID: chirp.AuthorID}))
+}
+
+func (cs *chirpyService) emitFollowWebhook(event string, followerID int, followeeID int) {
+	follower, err := cs.db.GetUser(followerID)
+	if err != nil {
+		return
+	}
+	cs.emitWebhook(followeeID, event, followEventData{
+		Follower:   cs.userResponse(follower, viewer{ID: followeeID}),
+		FolloweeID: followeeID,
+	})
+}
+
+// subscribeWebhooks queues webhook deliveries for domain events. Queueing
+// happens in the background so publishers don't wait on it.
+func (cs *chirpyService) subscribeWebhooks() {
+	events.SubscribeAsync(cs.bus, func(e ChirpCreated) {
+		cs.emitChirpWebhook(eventChirpCreated, e.Chirp)
+	})
+	events.SubscribeAsync(cs.bus, func(e ChirpUpdated) {
+		cs.emitChirpWebhook(eventChirpUpdated, e.Chirp)
+	})
+	events.SubscribeAsync(cs.bus, func(e ChirpDeleted) {
+		cs.emitWebhook(e.Chirp.AuthorID, eventChirpDeleted, chirpDeletedData{ID: e.Chirp.ID, AuthorID: e.Chirp.AuthorID})
+	})
+	events.SubscribeAsync(cs.bus, func(e UserFollowed) {
+		cs.emitFollowWebhook(eventUserFollowed, e.FollowerID, e.FolloweeID)
+	})
+	events.SubscribeAsync(cs.bus, func(e UserUnfollowed) {
+		cs.emitFollowWebhook(eventUserUnfollowed, e.FollowerID, e.FolloweeID)
+	})
+}
+
+func (cs *chirpyService) wakeWebhookDispatcher() {
+	select {
+	case cs.webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// runWebhookDispatcher sends queued webhook deliveries as they fall due, until
+// done is closed. The queue lives in the database, so deliveries that were
+// due while the server was down are sent as soon as it starts.
+func (cs *chirpyService) runWebhookDispatcher(done <-chan struct{}) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+		case <-cs.webhookWake:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		}
+		cs.sendDueWebhooks()
+		wait := maxSchedulerSleep
+		if next, ok := cs.db.NextWebhookAttemptTime(); ok {
+			wait = max(minSchedulerSleep, min(wait, time.Until(next)))
+		}
+		timer.Reset(wait)
+	}
+}
+
+func (cs *chirpyService) sendDueWebhooks() {
+	deliveries, err := cs.db.ClaimDueWebhookDeliveries(time.Now(), webhookLease, webhookBatch)
+	if err != nil {
+		log.Printf("error claiming webhook deliveries in database: %s", err)
+		return
+	}
+	var wg sync.WaitGroup
+	for _, d := range deliveries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cs.sendWebhook(d)
+		}()
+	}
+	wg.Wait()
+}
+
+// sendWebhook makes one attempt at a delivery and schedules a retry, with
+// exponential backoff, if it fails.
+func (cs *chirpyService) sendWebhook(d database.WebhookDelivery) {
+	endpoint, err := cs.db.GetWebhookEndpoint(d.EndpointID)
+	if err != nil {
+		// The endpoint was deleted along with its deliveries
+		return
+	}
+	attempt := cs.postWebhook(endpoint, d)
+	status, retryAt := database.DeliverySucceeded, time.Time{}
+	if attempt.Error != "" {
+		n := len(d.Attempts) + 1
+		if n >= maxDeliveryAttempts {
+			status = database.DeliveryDead
+			log.Printf("giving up on webhook delivery %d to %s after %d attempts", d.ID, endpoint.URL, n)
+		} else {
+			status, retryAt = database.DeliveryPending, time.Now().Add(retryDelay(n))
+		}
+	}
+	_, err = cs.db.RecordWebhookAttempt(d.ID, attempt, status, retryAt)
+	if err != nil && err != database.ErrDoesNotExist {
+		log.Printf("error recording webhook attempt in database: %s", err)
+	}
+}
+
+// newWebhookTransport returns a transport that only connects to public
+// addresses, checked as each connection is dialed so that DNS changes can't
+// point it at this host or a private network. It ignores proxy settings,
+// which would hide the real destination.
+func newWebhookTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	dialer := &net.Dialer{Timeout: webhookTimeout, Control: webhook.DialControl}
+	t.DialContext = dialer.DialContext
+	return t
+}
+
+// retryDelay is how long to wait after the nth failed attempt.
+func retryDelay(n int) time.Duration {
+	delay := firstRetryDelay
+	for i := 1; i < n && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// postWebhook sends a delivery to its endpoint. Anything but a 2xx response
+// counts as a failure.
+func (cs *chirpyService) postWebhook(endpoint database.WebhookEndpoint, d database.WebhookDelivery) database.DeliveryAttempt {
+	start := time.Now()
+	attempt := database.DeliveryAttempt{At: start.UTC()}
+	req, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	req.Header.Set(contentTypeHeader, jsonContentType)
+	req.Header.Set("User-Agent", webhookUserAgent)
+	req.Header.Set(webhookEventHeader, d.Event)
+	req.Header.Set(webhookDeliveryHeader, strconv.Itoa(d.ID))
+	req.Header.Set(webhookSignatureHeader, webhook.Sign(endpoint.Secret, start, d.Payload))
+	resp, err := cs.webhookClient.Do(req)
+	attempt.DurationMS = time.Since(start).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = "unexpected response: " + resp.Status
+	}
+	return attempt
+}
+
+func (cs *chirpyService) purgeWebhookDeliveries() {
+	err := cs.db.PurgeWebhookDeliveries(time.Now().Add(-deliveryRetention))
+	if err != nil {
+		log.Printf("error purging webhook deliveries in database: %s", err)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/thomasem/chirpy/internal/database"
+	"github.com/thomasem/chirpy/internal/webhook"
+)
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+// newWebhookReceiver starts a local endpoint that answers with status and
+// records what it's sent. Local addresses are refused by the real webhook
+// transport, so the service is given a plain client to reach it.
+func newWebhookReceiver(t *testing.T, cs *chirpyService, status int) (database.WebhookEndpoint, <-chan receivedWebhook) {
+	t.Helper()
+	received := make(chan receivedWebhook, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- receivedWebhook{header: r.Header.Clone(), body: body}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	cs.webhookClient = &http.Client{Timeout: webhookTimeout}
+
+	owner := mustCreateUser(t, cs, "owner")
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		t.Fatalf("NewSecret: %s", err)
+	}
+	endpoint, err := cs.db.CreateWebhookEndpoint(database.WebhookEndpoint{
+		OwnerID: owner.ID,
+		URL:     srv.URL,
+		Events:  []string{eventChirpCreated},
+		Secret:  secret,
+	}, maxWebhookEndpoints)
+	if err != nil {
+		t.Fatalf("CreateWebhookEndpoint: %s", err)
+	}
+	return endpoint, received
+}
+
+// queueDelivery queues a delivery to endpoint and claims it for sending.
+func queueDelivery(t *testing.T, cs *chirpyService, endpoint database.WebhookEndpoint) database.WebhookDelivery {
+	t.Helper()
+	_, err := cs.db.QueueWebhookDeliveries(endpoint.OwnerID, eventChirpCreated, []byte(`{"event":"chirp.created"}`))
+	if err != nil {
+		t.Fatalf("QueueWebhookDeliveries: %s", err)
+	}
+	deliveries, err := cs.db.ClaimDueWebhookDeliveries(time.Now(), webhookLease, webhookBatch)
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("ClaimDueWebhookDeliveries: got %d deliveries, err %v", len(deliveries), err)
+	}
+	return deliveries[0]
+}
+
+func TestSendWebhookSigned(t *testing.T) {
+	cs := newTestService(t)
+	endpoint, received := newWebhookReceiver(t, cs, http.StatusNoContent)
+	d := queueDelivery(t, cs, endpoint)
+
+	cs.sendWebhook(d)
+
+	got := <-received
+	if got.header.Get(webhookEventHeader) != eventChirpCreated {
+		t.Errorf("%s = %q, want %q", webhookEventHeader, got.header.Get(webhookEventHeader), eventChirpCreated)
+	}
+	if got.header.Get(webhookDeliveryHeader) != strconv.Itoa(d.ID) {
+		t.Errorf("%s = %q, want %d", webhookDeliveryHeader, got.header.Get(webhookDeliveryHeader), d.ID)
+	}
+	if string(got.body) != string(d.Payload) {
+		t.Errorf("body = %s, want %s", got.body, d.Payload)
+	}
+	_, err := webhook.Verify(got.header.Get(webhookSignatureHeader), got.body, []string{endpoint.Secret}, time.Minute, time.Now())
+	if err != nil {
+		t.Errorf("signature doesn't verify: %s", err)
+	}
+	_, err = webhook.Verify(got.header.Get(webhookSignatureHeader), got.body, []string{"whsec_other"}, time.Minute, time.Now())
+	if !errors.Is(err, webhook.ErrInvalidSignature) {
+		t.Errorf("signature verified with the wrong secret: %v", err)
+	}
+
+	d, err = cs.db.GetWebhookDelivery(d.ID)
+	if err != nil {
+		t.Fatalf("GetWebhookDelivery: %s", err)
+	}
+	if d.Status != database.DeliverySucceeded || len(d.Attempts) != 1 || d.Attempts[0].StatusCode != http.StatusNoContent {
+		t.Errorf("delivery = %+v, want one successful attempt", d)
+	}
+}
+
+func TestSendWebhookRetriesWithBackoff(t *testing.T) {
+	cs := newTestService(t)
+	endpoint, received := newWebhookReceiver(t, cs, http.StatusInternalServerError)
+	d := queueDelivery(t, cs, endpoint)
+
+	start := time.Now()
+	cs.sendWebhook(d)
+	<-received
+
+	d, err := cs.db.GetWebhookDelivery(d.ID)
+	if err != nil {
+		t.Fatalf("GetWebhookDelivery: %s", err)
+	}
+	if d.Status != database.DeliveryPending {
+		t.Fatalf("status = %s, want %s", d.Status, database.DeliveryPending)
+	}
+	if len(d.Attempts) != 1 || d.Attempts[0].StatusCode != http.StatusInternalServerError || d.Attempts[0].Error == "" {
+		t.Errorf("attempts = %+v, want one failed attempt with status 500", d.Attempts)
+	}
+	if wait := d.NextAttemptAt.Sub(start); wait < firstRetryDelay || wait > firstRetryDelay+time.Minute {
+		t.Errorf("next attempt in %s, want about %s", wait, firstRetryDelay)
+	}
+}
+
+func TestSendWebhookDeadLetters(t *testing.T) {
+	cs := newTestService(t)
+	endpoint, received := newWebhookReceiver(t, cs, http.StatusServiceUnavailable)
+	d := queueDelivery(t, cs, endpoint)
+	for i := 1; i < maxDeliveryAttempts; i++ {
+		_, err := cs.db.RecordWebhookAttempt(d.ID, database.DeliveryAttempt{At: time.Now(), Error: "failed"}, database.DeliveryPending, time.Now())
+		if err != nil {
+			t.Fatalf("RecordWebhookAttempt: %s", err)
+		}
+	}
+	d, err := cs.db.GetWebhookDelivery(d.ID)
+	if err != nil {
+		t.Fatalf("GetWebhookDelivery: %s", err)
+	}
+
+	cs.sendWebhook(d)
+	<-received
+
+	d, err = cs.db.GetWebhookDelivery(d.ID)
+	if err != nil {
+		t.Fatalf("GetWebhookDelivery: %s", err)
+	}
+	if d.Status != database.DeliveryDead {
+		t.Errorf("status after %d attempts = %s, want %s", len(d.Attempts), d.Status, database.DeliveryDead)
+	}
+	if len(d.Attempts) != maxDeliveryAttempts {
+		t.Errorf("got %d attempts, want %d", len(d.Attempts), maxDeliveryAttempts)
+	}
+	if next, ok := cs.db.NextWebhookAttemptTime(); ok {
+		t.Errorf("dead delivery is still scheduled for %s", next)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		n    int
+		want time.Duration
+	}{
+		{1, firstRetryDelay},
+		{2, 2 * firstRetryDelay},
+		{3, 4 * firstRetryDelay},
+		{6, 32 * firstRetryDelay},
+		{10, 512 * firstRetryDelay},
+		{11, maxRetryDelay},
+		{100, maxRetryDelay},
+	}
+	for _, tt := range tests {
+		if got := retryDelay(tt.n); got != tt.want {
+			t.Errorf("retryDelay(%d) = %s, want %s", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookTransportRefusesLocalAddresses(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Timeout: webhookTimeout, Transport: newWebhookTransport()}
+	_, err := client.Post(srv.URL, jsonContentType, nil)
+	if !errors.Is(err, webhook.ErrForbiddenAddress) {
+		t.Errorf("posting to %s: got %v, want ErrForbiddenAddress", srv.URL, err)
+	}
+	if requests != 0 {
+		t.Errorf("local receiver got %d requests", requests)
+	}
+}
+
+func TestValidateEndpointRejectsLocalURLs(t *testing.T) {
+	for _, u := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://10.0.0.1/hook",
+		"http://192.168.1.1/hook",
+		"https://172.16.0.5/hook",
+		"http://[::1]/hook",
+		"http://[fd00::1]/hook",
+		"http://[::ffff:127.0.0.1]/hook",
+		"http://0.0.0.0/hook",
+	} {
+		_, err := validateEndpoint(context.Background(), webhookEndpointRequest{URL: u, Events: []string{eventChirpCreated}})
+		if err == nil {
+			t.Errorf("validateEndpoint accepted %s", u)
+		}
+	}
+	_, err := validateEndpoint(context.Background(), webhookEndpointRequest{URL: "https://93.184.215.14/hook", Events: []string{eventChirpCreated}})
+	if err != nil {
+		t.Errorf("validateEndpoint rejected a public address: %s", err)
+	}
+}
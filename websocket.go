@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/thomasem/chirpy/internal/entities"
+	"github.com/thomasem/chirpy/internal/stream"
+	"github.com/thomasem/chirpy/internal/websocket"
+)
+
+const (
+	// maxSocketsPerUser is how many WebSocket connections a user may have
+	// open at once.
+	maxSocketsPerUser = 5
+	// maxSocketSubscriptions is how many subscriptions a connection may have.
+	maxSocketSubscriptions = 20
+	// socketReadLimit bounds the size of messages from clients, which are
+	// only ever small commands.
+	socketReadLimit    = 4096
+	socketPingInterval = 30 * time.Second
+	// socketPongTimeout is how long a client may go without sending anything,
+	// including pongs, before it's disconnected.
+	socketPongTimeout = 2 * socketPingInterval
+)
+
+// Client messages
+const (
+	socketSubscribe   = "subscribe"
+	socketUnsubscribe = "unsubscribe"
+	socketPing        = "ping"
+)
+
+// Server messages
+const (
+	socketSubscribed   = "subscribed"
+	socketUnsubscribed = "unsubscribed"
+	socketEvent        = "event"
+	socketPong         = "pong"
+	socketError        = "error"
+)
+
+// Subscription channels
+const (
+	channelTimeline      = "timeline"
+	channelAuthor        = "author"
+	channelHashtag       = "hashtag"
+	channelNotifications = "notifications"
+)
+
+var (
+	errBinaryMessage = errors.New("only text messages are supported")
+	// errSocketUserGone ends connections whose user has been suspended,
+	// scheduled for deletion or deleted since they connected.
+	errSocketUserGone = errors.New("account is no longer active")
+)
+
+var socketUpgrader = websocket.Upgrader{
+	ReadLimit:    socketReadLimit,
+	WriteTimeout: streamWriteTimeout,
+}
+
+// socketRequest is a message from a client. ID names the subscription being
+// made or ended.
+type socketRequest struct {
+	Type     string `json:"type"`
+	ID       string `json:"id"`
+	Channel  string `json:"channel"`
+	AuthorID int    `json:"author_id"`
+	Hashtag  string `json:"hashtag"`
+}
+
+// socketMessage is a message to a client. Events carry the ID of the
+// subscription they're for.
+type socketMessage struct {
+	Type  string `json:"type"`
+	ID    string `json:"id,omitempty"`
+	Event string `json:"event,omitempty"`
+	Data  any    `json:"data,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// socket is a client's WebSocket connection and its subscriptions, which are
+// only touched by the connection's handler goroutine. The viewer is read
+// again before every request, event and ping, and the subscriptions'
+// filters use it rather than the one they were made with.
+type socket struct {
+	cs   *chirpyService
+	conn *websocket.Conn
+	v    viewer
+	subs map[string]streamFilter
+}
+
+// acquireSocket counts a new connection for userID, reporting false if they
+// already have as many open as they're allowed.
+func (cs *chirpyService) acquireSocket(userID int) bool {
+	cs.socketsMux.Lock()
+	defer cs.socketsMux.Unlock()
+	if cs.sockets[userID] >= maxSocketsPerUser {
+		return false
+	}
+	cs.sockets[userID]++
+	return true
+}
+
+func (cs *chirpyService) releaseSocket(userID int) {
+	cs.socketsMux.Lock()
+	defer cs.socketsMux.Unlock()
+	cs.sockets[userID]--
+	if cs.sockets[userID] == 0 {
+		delete(cs.sockets, userID)
+	}
+}
+
+// websocketHandler upgrades to a WebSocket connection over which clients
+// subscribe to channels with JSON messages:
+//
+//	{"type": "subscribe", "id": "home", "channel": "timeline"}
+//	{"type": "subscribe", "id": "alice", "channel": "author", "author_id": 1}
+//	{"type": "subscribe", "id": "go", "channel": "hashtag", "hashtag": "go"}
+//	{"type": "subscribe", "id": "inbox", "channel": "notifications"}
+//	{"type": "unsubscribe", "id": "home"}
+//	{"type": "ping"}
+//
+// Each is answered with a subscribed, unsubscribed, pong or error message
+// with the same ID, and events are pushed as
+//
+//	{"type": "event", "id": "home", "event": "chirp.created", "data": {...}}
+//
+// The server pings every 30 seconds and disconnects clients it hasn't heard
+// from in a minute, and clients that fall behind are disconnected with close
+// code 1013 so they can reconnect and refetch. Connections of users who are
+// suspended or deleted are closed with code 1008.
+func (cs *chirpyService) websocketHandler(w http.ResponseWriter, r *http.Request) {
+	v := cs.getViewer(r)
+	if v.ID == 0 {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or missing token")
+		return
+	}
+	if !cs.acquireSocket(v.ID) {
+		respondWithError(w, http.StatusTooManyRequests, "Too many open connections")
+		return
+	}
+	defer cs.releaseSocket(v.ID)
+	sub, _, _, err := cs.stream.Subscribe(0, streamBuffer)
+	if err != nil {
+		respondWithError(w, http.StatusServiceUnavailable, "Server is shutting down")
+		return
+	}
+	defer cs.stream.Unsubscribe(sub)
+
+	conn, err := socketUpgrader.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	s := &socket{cs: cs, conn: conn, v: v, subs: make(map[string]streamFilter)}
+
+	requests := make(chan []byte)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go s.read(requests, readErr, done)
+
+	ping := time.NewTicker(socketPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-sub.Done:
+			if errors.Is(sub.Err(), stream.ErrLagging) {
+				conn.CloseWith(websocket.CloseTryAgainLater, "fell behind")
+			} else {
+				conn.CloseWith(websocket.CloseGoingAway, "server shutting down")
+			}
+			return
+		case err := <-readErr:
+			if errors.Is(err, errBinaryMessage) {
+				conn.CloseWith(websocket.CloseUnsupportedData, err.Error())
+			}
+			return
+		case msg := <-requests:
+			if err = s.refresh(); err == nil {
+				err = s.handle(msg)
+			}
+		case e := <-sub.C:
+			if err = s.refresh(); err == nil {
+				err = s.push(e)
+			}
+		case <-ping.C:
+			if err = s.refresh(); err == nil {
+				err = conn.Ping()
+			}
+		}
+		if errors.Is(err, errSocketUserGone) {
+			conn.CloseWith(websocket.ClosePolicyViolation, err.Error())
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// refresh reads the socket's user again, returning errSocketUserGone if
+// they're no longer active.
+func (s *socket) refresh() error {
+	user, err := s.cs.db.GetUser(s.v.ID)
+	if err != nil || user.Deactivated() {
+		return errSocketUserGone
+	}
+	s.v = viewer{ID: user.ID, Admin: user.Admin}
+	return nil
+}
+
+// read passes the client's messages to the handler until the connection
+// fails or closes, or the handler is done.
+func (s *socket) read(requests chan<- []byte, readErr chan<- error, done <-chan struct{}) {
+	extend := func() { s.conn.SetReadDeadline(time.Now().Add(socketPongTimeout)) }
+	s.conn.SetPongHandler(extend)
+	for {
+		extend()
+		t, msg, err := s.conn.ReadMessage()
+		if err == nil && t != websocket.TextMessage {
+			err = errBinaryMessage
+		}
+		if err != nil {
+			readErr <- err
+			return
+		}
+		select {
+		case requests <- msg:
+		case <-done:
+			return
+		}
+	}
+}
+
+func (s *socket) send(msg socketMessage) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("error encoding websocket message: %s", err)
+		return nil
+	}
+	return s.conn.WriteMessage(websocket.TextMessage, payload)
+}
+
+func (s *socket) handle(msg []byte) error {
+	var req socketRequest
+	err := json.Unmarshal(msg, &req)
+	if err != nil {
+		return s.send(socketMessage{Type: socketError, Error: "invalid JSON message"})
+	}
+	switch req.Type {
+	case socketPing:
+		return s.send(socketMessage{Type: socketPong, ID: req.ID})
+	case socketSubscribe:
+		f, err := s.filter(req)
+		if err != nil {
+			return s.send(socketMessage{Type: socketError, ID: req.ID, Error: err.Error()})
+		}
+		s.subs[req.ID] = f
+		return s.send(socketMessage{Type: socketSubscribed, ID: req.ID})
+	case socketUnsubscribe:
+		if _, ok := s.subs[req.ID]; !ok {
+			return s.send(socketMessage{Type: socketError, ID: req.ID, Error: "subscription not found"})
+		}
+		delete(s.subs, req.ID)
+		return s.send(socketMessage{Type: socketUnsubscribed, ID: req.ID})
+	}
+	return s.send(socketMessage{Type: socketError, ID: req.ID, Error: fmt.Sprintf("unknown message type '%s'", req.Type)})
+}
+
+// filter validates a subscribe request and returns the filter for its
+// channel.
+func (s *socket) filter(req socketRequest) (streamFilter, error) {
+	if req.ID == "" {
+		return streamFilter{}, errors.New("subscription ID is required")
+	}
+	if _, ok := s.subs[req.ID]; ok {
+		return streamFilter{}, errors.New("subscription ID is already in use")
+	}
+	if len(s.subs) >= maxSocketSubscriptions {
+		return streamFilter{}, errors.New("too many subscriptions")
+	}
+	f := streamFilter{viewer: s.v}
+	switch req.Channel {
+	case channelTimeline:
+		f.timeline = true
+	case channelAuthor:
+		if req.AuthorID <= 0 {
+			return streamFilter{}, errors.New("invalid author_id")
+		}
+		f.authorID = req.AuthorID
+	case channelHashtag:
+		f.hashtag = entities.NormalizeHashtag(req.Hashtag)
+		if f.hashtag == "" {
+			return streamFilter{}, errors.New("invalid hashtag")
+		}
+	case channelNotifications:
+		f.notifications = true
+	default:
+		return streamFilter{}, fmt.Errorf("unknown channel '%s'", req.Channel)
+	}
+	return f, nil
+}
+
+// push sends an event to each of the client's subscriptions it passes.
+func (s *socket) push(e stream.Event) error {
+	for id, f := range s.subs {
+		f.viewer = s.v
+		data, ok := s.cs.streamData(f, e)
+		if !ok {
+			continue
+		}
+		err := s.send(socketMessage{Type: socketEvent, ID: id, Event: e.Name, Data: data})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/thomasem/chirpy/internal/websocket"
+)
+
+// socketClient is a minimal WebSocket client for the handler's tests. It only
+// sends small text messages.
+type socketClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func dialSocket(t *testing.T, cs *chirpyService, userID int, origin string) (*socketClient, *http.Response) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(cs.websocketHandler))
+	t.Cleanup(srv.Close)
+	token, err := cs.generateJWT(userID, 0)
+	if err != nil {
+		t.Fatalf("generateJWT: %s", err)
+	}
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dialing: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	req := "GET / HTTP/1.1\r\nHost: " + srv.Listener.Addr().String() + "\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n" +
+		authorizationHeader + ": Bearer " + token + "\r\n"
+	if origin != "" {
+		req += "Origin: " + origin + "\r\n"
+	}
+	if _, err := conn.Write([]byte(req + "\r\n")); err != nil {
+		t.Fatalf("writing handshake: %s", err)
+	}
+	c := &socketClient{t: t, conn: conn, br: bufio.NewReader(conn)}
+	resp, err := http.ReadResponse(c.br, nil)
+	if err != nil {
+		t.Fatalf("reading handshake response: %s", err)
+	}
+	return c, resp
+}
+
+// send writes a masked text frame.
+func (c *socketClient) send(msg string) {
+	c.t.Helper()
+	mask := []byte{1, 2, 3, 4}
+	f := append([]byte{0x80 | byte(websocket.TextMessage), 0x80 | byte(len(msg))}, mask...)
+	for i := 0; i < len(msg); i++ {
+		f = append(f, msg[i]^mask[i%4])
+	}
+	if _, err := c.conn.Write(f); err != nil {
+		c.t.Fatalf("writing frame: %s", err)
+	}
+}
+
+// receive reads a frame, skipping pings.
+func (c *socketClient) receive() (websocket.MessageType, []byte) {
+	c.t.Helper()
+	for {
+		var header [2]byte
+		if _, err := io.ReadFull(c.br, header[:]); err != nil {
+			c.t.Fatalf("reading frame: %s", err)
+		}
+		n := int(header[1] & 0x7f)
+		if n == 126 {
+			var ext [2]byte
+			io.ReadFull(c.br, ext[:])
+			n = int(binary.BigEndian.Uint16(ext[:]))
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(c.br, payload); err != nil {
+			c.t.Fatalf("reading payload: %s", err)
+		}
+		if op := websocket.MessageType(header[0] & 0x0f); op != websocket.PingMessage {
+			return op, payload
+		}
+	}
+}
+
+func (c *socketClient) receiveMessage() socketMessage {
+	c.t.Helper()
+	op, payload := c.receive()
+	if op != websocket.TextMessage {
+		c.t.Fatalf("got frame %d %q, want a text message", op, payload)
+	}
+	var msg socketMessage
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		c.t.Fatalf("decoding %s: %s", payload, err)
+	}
+	return msg
+}
+
+func TestWebsocketRefusesOtherOrigins(t *testing.T) {
+	cs := newTestService(t)
+	user := mustCreateUser(t, cs, "user")
+	if _, resp := dialSocket(t, cs, user.ID, "https://evil.example"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("cross-origin handshake: status %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+	// Sockets refused at the handshake don't count against the user's limit
+	for i := 0; i < maxSocketsPerUser; i++ {
+		if _, resp := dialSocket(t, cs, user.ID, ""); resp.StatusCode != http.StatusSwitchingProtocols {
+			t.Fatalf("handshake %d: status %d, want 101", i+1, resp.StatusCode)
+		}
+	}
+}
+
+func TestWebsocketClosesWhenUserDeactivated(t *testing.T) {
+	cs := newTestService(t)
+	user := mustCreateUser(t, cs, "user")
+	author := mustCreateUser(t, cs, "author")
+	c, resp := dialSocket(t, cs, user.ID, "")
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake: status %d, want 101", resp.StatusCode)
+	}
+	c.send(`{"type":"subscribe","id":"a","channel":"author","author_id":` + strconv.Itoa(author.ID) + `}`)
+	if msg := c.receiveMessage(); msg.Type != socketSubscribed {
+		t.Fatalf("subscribe answered with %+v", msg)
+	}
+	post := func() {
+		t.Helper()
+		if w := serveAs(t, cs, cs.createChirpHandler, author.ID, http.MethodPost, `{"body":"hello"}`); w.Code != http.StatusCreated {
+			t.Fatalf("create: status %d: %s", w.Code, w.Body)
+		}
+	}
+	post()
+	if msg := c.receiveMessage(); msg.Type != socketEvent || msg.Event != eventChirpCreated {
+		t.Fatalf("got %+v, want the new chirp", msg)
+	}
+
+	if _, err := cs.db.SuspendUser(user.ID, author.ID, "spam"); err != nil {
+		t.Fatalf("SuspendUser: %s", err)
+	}
+	post()
+	op, payload := c.receive()
+	if op != websocket.CloseMessage || len(payload) < 2 || binary.BigEndian.Uint16(payload) != websocket.ClosePolicyViolation {
+		t.Errorf("after suspension got frame %d %q, want a close with %d", op, payload, websocket.ClosePolicyViolation)
+	}
+}